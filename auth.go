@@ -0,0 +1,428 @@
+package dispatcher
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Averianov/cidispatcher/wrapper"
+	sl "github.com/Averianov/cisystemlog"
+	"github.com/alicebob/miniredis/v2/server"
+)
+
+// AuthEnabled generate credentials for every task, require AUTH on embedded bus and check ACL of messages;
+// off by default, because services with legacy envelope {s, k, v} do not authenticate on bus
+var AuthEnabled bool
+
+// Permission allow task to send keys to channels; "*" and glob patterns are supported;
+// key may be set with string value as "STATUS:EXIT"
+type Permission struct {
+	To   []string `json:"to"`
+	Keys []string `json:"keys"`
+}
+
+// keystore keep credentials of tasks and authenticated user of every bus connection
+type keystore struct {
+	sync.RWMutex
+	tokens map[string]string
+	peers  map[*server.Peer]string
+}
+
+// Register add credentials of task to bus
+func (d *Dispatcher) Register(name, token string) {
+	if !AuthEnabled {
+		return
+	}
+	d.keys.Lock()
+	d.keys.tokens[name] = token
+	d.keys.Unlock()
+	d.Redis.RequireUserAuth(name, token)
+}
+
+// Unregister remove credentials of task from bus
+func (d *Dispatcher) Unregister(name string) {
+	if !AuthEnabled {
+		return
+	}
+	d.keys.Lock()
+	delete(d.keys.tokens, name)
+	d.keys.Unlock()
+	d.Redis.RequireUserAuth(name, "")
+}
+
+// Token return credentials of task; used as wrapper.Keystore in master
+func (d *Dispatcher) Token(name string) (token string, ok bool) {
+	d.keys.RLock()
+	defer d.keys.RUnlock()
+	token, ok = d.keys.tokens[strings.ToUpper(name)]
+	return
+}
+
+// WriteToken save credentials of service started not by dispatcher (as SENDER) to private runtime directory
+func (d *Dispatcher) WriteToken(name, token string) (err error) {
+	err = os.MkdirAll(wrapper.RuntimeDir(), 0700)
+	if err != nil {
+		return
+	}
+	return os.WriteFile(wrapper.TokenFile(name), []byte(token), 0600)
+}
+
+// SetupAuth create credentials of master and sender and guard bus; auth is refused on external Redis,
+// where sender of message between services can not be checked by bus
+func (d *Dispatcher) SetupAuth() (err error) {
+	d.keys.tokens = map[string]string{}
+	d.keys.peers = map[*server.Peer]string{}
+	if !AuthEnabled {
+		return
+	}
+	if d.Redis == nil {
+		return fmt.Errorf("%s", "auth works only on embedded bus; set AuthEnabled = false to use external Redis")
+	}
+
+	token := wrapper.NewToken()
+	d.Register(wrapper.MASTER, token)
+	os.Setenv(wrapper.CI_TOKEN, token)
+
+	token = wrapper.NewToken()
+	d.Register(wrapper.SENDER, token)
+	err = d.WriteToken(wrapper.SENDER, token)
+	if err != nil {
+		return
+	}
+
+	wrapper.Keystore = d.Token
+	d.Redis.Server().SetPreHook(d.busGuard)
+	return
+}
+
+// busGuard remember authenticated user of connection and check every PUBLISH:
+// sender of message must be the user and message must be allowed by ACL
+func (d *Dispatcher) busGuard(peer *server.Peer, cmd string, args ...string) bool {
+	if user, forbidden := d.kvForbidden(peer, cmd, args); forbidden {
+		sl.L.Warning("[master] %s not allowed change store by %s", user, cmd)
+		peer.WriteError("NOPERM service may change only own keys and global namespace of store; locks and queues by scripts")
+		return true
+	}
+
+	switch cmd {
+	case "EVAL", "EVALSHA", "EVAL_RO", "EVALSHA_RO", "SCRIPT":
+		if len(args) == 0 || !d.knownScript(peer, cmd, args[0]) {
+			peer.WriteError("NOPERM only scripts of wrapper allowed")
+			return true
+		}
+	case "AUTH":
+		if len(args) == 2 {
+			d.authPeer(peer, args[0], args[1])
+		}
+	case "HELLO":
+		for i, arg := range args {
+			if strings.ToUpper(arg) == "AUTH" && i+2 < len(args) {
+				d.authPeer(peer, args[i+1], args[i+2])
+			}
+		}
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		d.keys.RLock()
+		user, ok := d.keys.peers[peer]
+		d.keys.RUnlock()
+		if !ok || user == wrapper.MASTER || user == wrapper.SENDER { // not authenticated rejected by miniredis
+			return false
+		}
+		for _, name := range args {
+			if d.listenForbidden(user, name, cmd == "PSUBSCRIBE") {
+				sl.L.Warning("[master] %s not allowed listen %s", user, name)
+				peer.WriteError("NOPERM service may listen only own channel, tags, topics, answers and own namespace of store")
+				return true
+			}
+		}
+	case "PUBLISH":
+		if len(args) != 2 {
+			return false
+		}
+		d.keys.RLock()
+		user, ok := d.keys.peers[peer]
+		d.keys.RUnlock()
+		if !ok { // not authenticated; rejected by miniredis
+			return false
+		}
+
+		msg := wrapper.RedisMessage{}
+		err := msg.UnmarshalBinary([]byte(args[1]))
+		switch true {
+		case err != nil:
+			sl.L.Warning("[master] %s publish wrong message to %s: %s", user, args[0], err.Error())
+			peer.WriteError("NOPERM wrong message")
+			return true
+		case msg.Sender != user && user != wrapper.MASTER:
+			sl.L.Warning("[master] %s publish to %s as %s", user, args[0], msg.Sender)
+			peer.WriteError("NOPERM sender of message is not authenticated user")
+			return true
+		case !d.Allowed(msg.Sender, args[0], msg.Key, msg.Value):
+			sl.L.Warning("[master] %s not allowed send %s to %s", msg.Sender, msg.Key, args[0])
+			peer.WriteError("NOPERM message not allowed by ACL")
+			return true
+		}
+	}
+	return false
+}
+
+// knownScript allow master any script, services only scripts of wrapper
+func (d *Dispatcher) knownScript(peer *server.Peer, cmd, arg string) bool {
+	d.keys.RLock()
+	user, ok := d.keys.peers[peer]
+	d.keys.RUnlock()
+	switch true {
+	case !ok || user == wrapper.MASTER: // not authenticated rejected by miniredis
+		return true
+	case cmd == "EVAL" || cmd == "EVAL_RO":
+		sum := sha1.Sum([]byte(arg))
+		return wrapper.KnownScript(hex.EncodeToString(sum[:]))
+	case cmd == "SCRIPT":
+		return strings.ToUpper(arg) == "EXISTS"
+	}
+	return wrapper.KnownScript(arg)
+}
+
+// storeReads are commands of services which do not change store: connection, pub/sub and reading
+var storeReads = map[string]bool{
+	"AUTH": true, "HELLO": true, "PING": true, "ECHO": true, "SELECT": true, "CLIENT": true, "QUIT": true,
+	"SUBSCRIBE": true, "UNSUBSCRIBE": true, "PSUBSCRIBE": true, "PUNSUBSCRIBE": true, "PUBSUB": true, "PUBLISH": true,
+	"GET": true, "MGET": true, "EXISTS": true, "TTL": true, "PTTL": true, "TYPE": true, "STRLEN": true, "SCAN": true, "KEYS": true,
+	"SMEMBERS": true, "SCARD": true, "SISMEMBER": true, "HGET": true, "HMGET": true, "HGETALL": true, "HLEN": true,
+	"LLEN": true, "LRANGE": true, "ZCARD": true, "ZRANGE": true, "ZRANGEBYSCORE": true, "ZSCORE": true,
+	"EVAL_RO": true, "EVALSHA_RO": true, "SCRIPT": true, // scripts checked by knownScript
+}
+
+// storeWriteKeys return keys changed by write command of service; ok is false for command not allowed to services
+func storeWriteKeys(cmd string, args []string) (keys []string, ok bool) {
+	switch cmd {
+	case "SET", "SETEX", "PSETEX", "SETNX", "GETSET", "GETDEL", "APPEND", "INCR", "INCRBY", "INCRBYFLOAT", "DECR", "DECRBY",
+		"EXPIRE", "PEXPIRE", "PERSIST", "HSET", "HDEL", "HINCRBY", "SADD", "SREM", "LPUSH", "RPUSH", "LPOP", "RPOP", "LREM",
+		"ZADD", "ZREM":
+		keys = args[:min(1, len(args))]
+	case "DEL", "UNLINK":
+		keys = args
+	case "MSET", "MSETNX":
+		for i := 0; i < len(args); i += 2 {
+			keys = append(keys, args[i])
+		}
+	case "RENAME", "RENAMENX", "COPY", "SMOVE", "LMOVE", "RPOPLPUSH":
+		keys = args[:min(2, len(args))]
+	default:
+		return nil, false
+	}
+	return keys, true
+}
+
+// kvForbidden check that command of service change only own or global namespace of store, own set of locks,
+// own membership in groups and own load; locks and queues only by scripts of wrapper on behalf of user.
+// Commands unknown for services (as FLUSHALL) are forbidden
+func (d *Dispatcher) kvForbidden(peer *server.Peer, cmd string, args []string) (user string, forbidden bool) {
+	if storeReads[cmd] {
+		return
+	}
+	var keys, argv []string
+	script, known := false, true
+	switch cmd {
+	case "EVAL", "EVALSHA":
+		script = true
+		if len(args) > 1 {
+			n, _ := strconv.Atoi(args[1])
+			if n > 0 && 2+n <= len(args) {
+				keys, argv = args[2:2+n], args[2+n:]
+			}
+		}
+	default:
+		keys, known = storeWriteKeys(cmd, args)
+	}
+
+	d.keys.RLock()
+	user, ok := d.keys.peers[peer]
+	d.keys.RUnlock()
+	if !ok || user == wrapper.MASTER { // commands of scripts checked by EVAL; not authenticated rejected by miniredis
+		return
+	}
+	if !known {
+		return user, true
+	}
+	// own members of set or fields of hash: SADD / SREM / HINCRBY / HDEL key <user>
+	own := func(cmds ...string) bool {
+		if !slices.Contains(cmds, cmd) || len(args) < 2 {
+			return false
+		}
+		if cmd == "HINCRBY" {
+			return args[1] == user
+		}
+		for _, member := range args[1:] {
+			if member != user {
+				return false
+			}
+		}
+		return true
+	}
+	for _, key := range keys {
+		switch true {
+		case strings.HasPrefix(key, wrapper.KV_PREFIX):
+			ns, _, _ := strings.Cut(strings.TrimPrefix(key, wrapper.KV_PREFIX), ":")
+			if ns != user && ns != wrapper.KV_GLOBAL {
+				return user, true
+			}
+		case strings.HasPrefix(key, wrapper.OWNERS_PREFIX):
+			if strings.TrimPrefix(key, wrapper.OWNERS_PREFIX) != user {
+				return user, true
+			}
+		case strings.HasPrefix(key, wrapper.LOCK_PREFIX), strings.HasPrefix(key, wrapper.FENCE_PREFIX):
+			// first argument of lock scripts is owner or value "<OWNER>:<FENCE>"
+			if !script || len(argv) == 0 || (argv[0] != user && !strings.HasPrefix(argv[0], user+":")) {
+				return user, true
+			}
+		case strings.HasPrefix(key, wrapper.QUEUE_PREFIX):
+			if !script {
+				return user, true
+			}
+		case strings.HasPrefix(key, wrapper.GROUP_KEY_PREFIX):
+			if !own("SADD", "SREM") {
+				return user, true
+			}
+		case key == wrapper.LOAD_KEY:
+			if !own("HINCRBY", "HDEL") {
+				return user, true
+			}
+		case strings.HasPrefix(key, wrapper.STORE_PREFIX) && !script: // other keys of dispatcher
+			return user, true
+		}
+	}
+	return
+}
+
+// listenForbidden check channel or pattern subscribed by service: it must not cover channels of other services,
+// tags of other services, answers on requests of others or changes of other namespaces of store; other names are topics
+func (d *Dispatcher) listenForbidden(user, name string, pattern bool) bool {
+	literal := name // prefix of pattern before first wildcard
+	if i := strings.IndexAny(name, "*?[\\"); pattern && i >= 0 {
+		literal = name[:i]
+	}
+	wildcard := literal != name
+	if !wildcard && (name == user || name == wrapper.BROADCAST) {
+		return false
+	}
+
+	for _, prefix := range []string{wrapper.TAG_PREFIX, wrapper.KV_TOPIC_PREFIX, wrapper.INBOX_PREFIX} {
+		if !strings.HasPrefix(literal, prefix) {
+			if wildcard && strings.HasPrefix(prefix, literal) { // as "*" or "T*"
+				return true
+			}
+			continue
+		}
+		switch prefix {
+		case wrapper.TAG_PREFIX:
+			return wildcard || !slices.Contains(d.taskTags(user), strings.TrimPrefix(name, prefix))
+		case wrapper.KV_TOPIC_PREFIX: // KV.<NAMESPACE>.<key pattern>
+			ns, _, ok := strings.Cut(strings.TrimPrefix(literal, prefix), ".")
+			return !ok || (ns != user && ns != wrapper.KV_GLOBAL)
+		default: // own answer is subscribed by exact name of inbox
+			return wildcard
+		}
+	}
+
+	services := []string{wrapper.MASTER, wrapper.SENDER}
+	for _, task := range d.TaskList() {
+		services = append(services, task.Name)
+	}
+	d.keys.RLock()
+	for service := range d.keys.tokens {
+		services = append(services, service)
+	}
+	d.keys.RUnlock()
+	for _, service := range services {
+		if service != user && (service == name || (pattern && wrapper.MatchPattern(name, service))) {
+			return true
+		}
+	}
+	return false
+}
+
+// taskTags return tags of task from its env
+func (d *Dispatcher) taskTags(name string) (tags []string) {
+	task, ok := d.Task(name)
+	if !ok {
+		return
+	}
+	task.Lock()
+	defer task.Unlock()
+	for _, env := range task.Env {
+		if val, ok := strings.CutPrefix(env, wrapper.TAGS+"="); ok {
+			tags = strings.Split(val, ",")
+		}
+	}
+	return
+}
+
+func (d *Dispatcher) authPeer(peer *server.Peer, user, password string) {
+	if token, ok := d.Token(user); !ok || token != password {
+		return
+	}
+	d.keys.Lock()
+	if _, ok := d.keys.peers[peer]; !ok {
+		peer.OnDisconnect(func() {
+			d.keys.Lock()
+			delete(d.keys.peers, peer)
+			d.keys.Unlock()
+		})
+	}
+	d.keys.peers[peer] = strings.ToUpper(user)
+	d.keys.Unlock()
+}
+
+// Allowed check ACL: may sender send key with value to channel
+func (d *Dispatcher) Allowed(sender, channel, key string, value any) bool {
+	sender, channel = strings.ToUpper(sender), strings.ToUpper(channel)
+	if sender == wrapper.MASTER || sender == wrapper.SENDER {
+		return true
+	}
+	if strings.HasPrefix(channel, wrapper.INBOX_PREFIX) { // answer on request
+		return true
+	}
+	if key == wrapper.KV_CHANGED { // change of own or global namespace of store
+		return strings.HasPrefix(channel, wrapper.KVTopic(sender, "")) || strings.HasPrefix(channel, wrapper.KVTopic(wrapper.KV_GLOBAL, ""))
+	}
+	val, _ := value.(string)
+	val = strings.ToUpper(val)
+	if channel == wrapper.MASTER && key == wrapper.STATUS { // own lifecycle always allowed
+		switch val {
+		case wrapper.LAUNCHED, wrapper.READY, wrapper.STOPPED, wrapper.COMPLETED, wrapper.GETINFO:
+			return true
+		}
+	}
+	if channel == wrapper.MASTER && (key == wrapper.FAILURE || key == wrapper.CONFIG || key == wrapper.HEARTBEAT) {
+		return true
+	}
+
+	task, ok := d.Task(sender)
+	if !ok {
+		return false
+	}
+	if task.Acl == nil { // by default only application exit is forbidden
+		return !(channel == wrapper.MASTER && key == wrapper.STATUS && val == wrapper.EXIT)
+	}
+	for _, perm := range task.Acl {
+		if matchAny(perm.To, channel) && (matchAny(perm.Keys, key) || matchAny(perm.Keys, key+":"+val)) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToUpper(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package dispatcher
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Averianov/cidispatcher/wrapper"
+)
+
+// TestStoreGuard service change only own and global keys of store; every key of command is checked
+func TestStoreGuard(t *testing.T) {
+	d, addr := testDispatcher(t)
+	a := testWrapper(t, d, addr, "A")
+	testWrapper(t, d, addr, "B")
+	ctx := context.Background()
+
+	allowed := [][]any{
+		{"SET", wrapper.KVKey("A", "x"), "1"},
+		{"SET", wrapper.KVKey(wrapper.KV_GLOBAL, "x"), "1"},
+		{"MSET", wrapper.KVKey("A", "y"), "1", wrapper.KVKey(wrapper.KV_GLOBAL, "y"), "2"},
+		{"RENAME", wrapper.KVKey(wrapper.KV_GLOBAL, "y"), wrapper.KVKey("A", "z")},
+		{"SADD", wrapper.GROUP_KEY_PREFIX + "G", "A"},
+		{"HINCRBY", wrapper.LOAD_KEY, "A", "1"},
+		{"SET", "app:own", "1"}, // keys out of store of dispatcher
+		{"GET", wrapper.KVKey("B", "x")},
+	}
+	for _, cmd := range allowed {
+		if err := a.RClient.Do(ctx, cmd...).Err(); err != nil && err.Error() != "redis: nil" {
+			t.Errorf("%v: %v", cmd, err)
+		}
+	}
+
+	forbidden := [][]any{
+		{"SET", wrapper.KVKey("B", "x"), "1"},
+		{"MSET", wrapper.KVKey(wrapper.KV_GLOBAL, "a"), "1", wrapper.KVKey("B", "b"), "2"},
+		{"RENAME", wrapper.KVKey(wrapper.KV_GLOBAL, "x"), wrapper.KVKey("B", "y")},
+		{"COPY", wrapper.KVKey(wrapper.KV_GLOBAL, "x"), wrapper.KVKey("B", "y")},
+		{"SMOVE", wrapper.KVKey("A", "s"), wrapper.KVKey("B", "s"), "m"},
+		{"LMOVE", wrapper.KVKey("A", "l"), wrapper.KVKey("B", "l"), "LEFT", "RIGHT"},
+		{"FLUSHALL"},
+		{"FLUSHDB"},
+		{"SWAPDB", "0", "1"},
+		{"SADD", wrapper.GROUP_KEY_PREFIX + "G", "B"},
+		{"DEL", wrapper.GROUP_KEY_PREFIX + "G"},
+		{"HINCRBY", wrapper.LOAD_KEY, "B", "1"},
+		{"RPUSH", wrapper.QUEUE_PREFIX + "q:ready", "job"},
+		{"SET", wrapper.LOCK_PREFIX + "x", "A:1"},
+		{"SET", wrapper.STORE_PREFIX + "other", "1"},
+	}
+	for _, cmd := range forbidden {
+		if err := a.RClient.Do(ctx, cmd...).Err(); err == nil || !strings.Contains(err.Error(), "NOPERM") {
+			t.Errorf("%v allowed: %v", cmd, err)
+		}
+	}
+
+	// scripts of wrapper work on behalf of service
+	if _, err := a.Enqueue(ctx, "q", "job"); err != nil {
+		t.Error(err)
+	}
+	lock, err := a.Lock(ctx, "x", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.Unlock(ctx)
+
+	// master is not limited
+	if err := d.Wpr.RClient.Del(ctx, wrapper.KVKey("A", "x"), wrapper.GROUP_KEY_PREFIX+"G").Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestSpoofedSender bus reject message which sender is not the authenticated user, even signed by own token
+func TestSpoofedSender(t *testing.T) {
+	d, addr := testDispatcher(t)
+	a := testWrapper(t, d, addr, "A")
+	testWrapper(t, d, addr, "B")
+	d.Lock()
+	d.Tasks["A"] = &Task{Name: "A"}
+	d.Unlock()
+	ctx := context.Background()
+
+	publish := func(sender, channel, key string, value any) error {
+		msg := &wrapper.RedisMessage{Sender: sender, Key: key, Value: value, Version: wrapper.PROTOCOL_VERSION}
+		msg.Sign(a.Token)
+		return a.RClient.Publish(ctx, channel, msg).Err()
+	}
+	if err := publish("A", "B", "KEY", "value"); err != nil {
+		t.Fatal(err)
+	}
+	for _, sender := range []string{"B", wrapper.MASTER, wrapper.SENDER} {
+		if err := publish(sender, "B", "KEY", "value"); err == nil || !strings.Contains(err.Error(), "NOPERM") {
+			t.Errorf("published as %s: %v", sender, err)
+		}
+	}
+	if err := a.RClient.Publish(ctx, "B", "not a message").Err(); err == nil || !strings.Contains(err.Error(), "NOPERM") {
+		t.Errorf("published wrong message: %v", err)
+	}
+}
+
+// TestAllowed decisions of ACL by sender, channel, key and value
+func TestAllowed(t *testing.T) {
+	d := &Dispatcher{Tasks: map[string]*Task{
+		"A": {Name: "A"},
+		"B": {Name: "B", Acl: []Permission{
+			{To: []string{"logger"}, Keys: []string{"*"}},
+			{To: []string{"master"}, Keys: []string{"STATUS:STOP", "START"}},
+		}},
+	}}
+	for _, c := range []struct {
+		sender, channel, key string
+		value                any
+		allowed              bool
+	}{
+		{wrapper.MASTER, "A", wrapper.STATUS, wrapper.EXIT, true},
+		{wrapper.SENDER, wrapper.MASTER, wrapper.STATUS, wrapper.EXIT, true},
+		{"A", "B", "KEY", "value", true},                           // without ACL all
+		{"A", wrapper.MASTER, wrapper.STATUS, wrapper.EXIT, false}, // except application exit
+		{"A", wrapper.MASTER, wrapper.STATUS, wrapper.READY, true},
+		{"B", "LOGGER", "KEY", nil, true},
+		{"B", "A", "KEY", nil, false},
+		{"B", wrapper.MASTER, wrapper.STATUS, wrapper.STOP, true},
+		{"B", wrapper.MASTER, wrapper.STATUS, wrapper.EXIT, false},
+		{"B", wrapper.MASTER, "START", "A", true},
+		{"B", wrapper.MASTER, wrapper.STATUS, wrapper.LAUNCHED, true}, // own lifecycle
+		{"B", wrapper.MASTER, wrapper.HEARTBEAT, nil, true},
+		{"B", wrapper.INBOX_PREFIX + "1", "PONG", nil, true},
+		{"B", wrapper.KVTopic("B", "x"), wrapper.KV_CHANGED, nil, true},
+		{"B", wrapper.KVTopic("A", "x"), wrapper.KV_CHANGED, nil, false},
+		{"UNKNOWN", "A", "KEY", nil, false},
+	} {
+		if got := d.Allowed(c.sender, c.channel, c.key, c.value); got != c.allowed {
+			t.Errorf("%s -> %s %s:%v: allowed %v", c.sender, c.channel, c.key, c.value, got)
+		}
+	}
+}
+
+// TestAuthExternalRedis auth is refused without guard of embedded bus
+func TestAuthExternalRedis(t *testing.T) {
+	d := &Dispatcher{}
+	if err := d.SetupAuth(); err == nil {
+		t.Fatal("auth enabled on external Redis")
+	}
+}
+
+// TestSubscribeGuard service listen only own channel, tags, topics, own answers and own namespace of store
+func TestSubscribeGuard(t *testing.T) {
+	d, addr := testDispatcher(t)
+	a := testWrapper(t, d, addr, "A")
+	testWrapper(t, d, addr, "B")
+	d.Lock()
+	d.Tasks["A"] = &Task{Name: "A", Env: []string{wrapper.TAGS + "=LOGS"}}
+	d.Unlock()
+	ctx := context.Background()
+
+	listen := func(pattern bool, name string) error {
+		ps := a.RClient.Subscribe(ctx)
+		defer ps.Close()
+		if pattern {
+			ps.PSubscribe(ctx, name)
+		} else {
+			ps.Subscribe(ctx, name)
+		}
+		_, err := ps.Receive(ctx)
+		return err
+	}
+	for _, c := range []struct {
+		pattern bool
+		name    string
+		allowed bool
+	}{
+		{false, "A", true},
+		{false, wrapper.BROADCAST, true},
+		{false, wrapper.TagChannel("logs"), true},
+		{false, wrapper.INBOX_PREFIX + "1", true},
+		{false, wrapper.KVTopic("A", "cfg"), true},
+		{false, "events.user", true},
+		{true, "events.*", true},
+		{true, wrapper.KVTopic("A", "*"), true},
+		{true, wrapper.KVTopic(wrapper.KV_GLOBAL, "cfg/*"), true},
+		{false, "B", false},
+		{false, wrapper.MASTER, false},
+		{false, wrapper.TagChannel("other"), false},
+		{false, wrapper.KVTopic("B", "cfg"), false},
+		{true, "*", false},
+		{true, "B*", false},
+		{true, "?", false},
+		{true, wrapper.TAG_PREFIX + "*", false},
+		{true, wrapper.KV_TOPIC_PREFIX + "*", false},
+		{true, wrapper.KVTopic("B", "*"), false},
+		{true, wrapper.INBOX_PREFIX + "*", false},
+	} {
+		err := listen(c.pattern, c.name)
+		if c.allowed && err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if !c.allowed && (err == nil || !strings.Contains(err.Error(), "NOPERM")) {
+			t.Errorf("%s allowed: %v", c.name, err)
+		}
+	}
+
+	// refused topic does not break listener
+	got := make(chan string, 1)
+	a.SetOnMessage(func(msg *wrapper.RedisMessage) {
+		if msg.Key == "KEY" {
+			got <- msg.Sender
+		}
+	})
+	a.Subscribe("B*")
+	time.Sleep(100 * time.Millisecond)
+	d.Wpr.SendToService("A", "KEY", "value")
+	select {
+	case <-got:
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not delivered after refused subscription")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"time"
 
 	"github.com/Averianov/cidispatcher/wrapper"
@@ -16,13 +17,48 @@ var rk = func(sender, key string, value any) {
 	sl.L.Info("[%s] GOT {sender: %s value: %s}", Name, sender, value)
 }
 
-func main() {
-	wrapper.RadioKat = rk
-	wrapper.CreateWrapper(Name, -1, -1)
+type logger struct {
+	wpr *wrapper.Wrapper
+}
+
+func (l *logger) Start(ctx context.Context) error {
+	//### Jobs from workers #####################################################
+	consumer := l.wpr.Consumer(Name, 2, func(ctx context.Context, job *wrapper.Job) error {
+		value, err := wrapper.DecodeJob[string](job)
+		if err != nil {
+			return err
+		}
+		sl.L.Info("[%s] JOB {sender: %s value: %s attempt: %d}", Name, job.Sender, value, job.Attempts)
+		return nil
+	})
+	l.wpr.Go(func() error { return consumer.Run(ctx) })
 
 	//### Work #################################################################
-	for {
-		time.Sleep(5 * time.Second)
-		sl.L.Debug("do any service work")
-	}
+	l.wpr.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(5 * time.Second):
+				sl.L.Debug("do any service work")
+			}
+		}
+	})
+	return nil
+}
+
+func (l *logger) Stop(ctx context.Context) error {
+	return nil
+}
+
+// Reload got new env of service from master
+func (l *logger) Reload(cfg map[string]string) error {
+	sl.L.Info("[%s] reload: %v", Name, cfg)
+	return nil
+}
+
+func main() {
+	wrapper.RadioKat = rk
+	wpr := wrapper.CreateWrapper(Name, -1, -1)
+	wrapper.Run(context.Background(), &logger{wpr: wpr})
 }
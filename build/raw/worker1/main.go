@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+
 	"github.com/Averianov/cidispatcher/build/raw/worker1/service"
 	"github.com/Averianov/cidispatcher/wrapper"
 	sl "github.com/Averianov/cisystemlog"
@@ -14,18 +16,12 @@ func main() {
 
 	wpr := wrapper.CreateWrapper(Name, -1, -1)
 
-	// RadioKat implementation
+	// RadioKat implementation; STOP handled by wrapper.Run
 	wrapper.RadioKat = func(sender, key string, value any) {
-		switch key {
-		case wrapper.STOP:
-			sl.L.Info("[%s] try stop parent process", wpr.Name)
-			close(wpr.StopChan)
-		default:
-			sl.L.Info("[%s] GOT: from %s: %s", wpr.Name, sender, value)
-		}
+		sl.L.Info("[%s] GOT: from %s: %s", wpr.Name, sender, value)
 	}
 
-	service.Srv(wpr)
+	wrapper.Run(context.Background(), &service.Srv{Wpr: wpr})
 	//wpr.StartService("worker2") // инициировать запуск worker2 через master_sock
 	//wpr.StopService(wpr.Name)   // "должен быть остановлен" - чтобы повторно не запускался
 }
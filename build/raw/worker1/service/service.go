@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"time"
 
 	"github.com/Averianov/cidispatcher/wrapper"
@@ -9,26 +10,44 @@ import (
 )
 
 // ### Work #################################################################
-func Srv(wpr *wrapper.Wrapper) {
+type Srv struct {
+	Wpr *wrapper.Wrapper
+}
+
+func (s *Srv) Start(ctx context.Context) error {
+	s.Wpr.Go(func() error {
+		s.work(ctx)
+		return nil
+	})
+	return nil
+}
+
+func (s *Srv) Stop(ctx context.Context) error {
+	sl.L.Warning("[%s] Stopping", s.Wpr.Name)
+	return nil
+}
+
+func (s *Srv) work(ctx context.Context) {
 	var err error
-	defer sl.L.Warning("[%s] End task by timeout", wpr.Name)
+	defer sl.L.Warning("[%s] End task by timeout", s.Wpr.Name)
 
 	var i int = 0
 	for {
 		select {
-		case <-wpr.StopChan:
-			sl.L.Warning("[%s] Stopping from Channel", wpr.Name)
+		case <-ctx.Done():
+			sl.L.Warning("[%s] Stopping from context", s.Wpr.Name)
 			return
 		default:
-			err = wpr.SendToService("logger", wpr.Name, ciutils.IntToStr(i))
+			err = s.Wpr.SendToService("logger", s.Wpr.Name, ciutils.IntToStr(i))
 			if err != nil {
 				time.Sleep(5 * time.Second)
 				sl.L.Warning(err.Error())
 				continue
 			}
 			if i == 10 {
-				wpr.StartService("worker2")
+				s.Wpr.StartService("worker2")
 				//wpr.StopService("logger")
+				s.Wpr.Stop()
 				return
 			}
 
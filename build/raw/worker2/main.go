@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -14,33 +15,45 @@ const (
 	logger = "logger"
 )
 
+type worker struct {
+	wpr *wrapper.Wrapper
+}
+
+func (w *worker) Start(ctx context.Context) error {
+	w.wpr.Go(func() error {
+		w.work(ctx)
+		return nil
+	})
+	return nil
+}
+
+func (w *worker) Stop(ctx context.Context) error {
+	sl.L.Warning("[%s] Stopping", w.wpr.Name)
+	return nil
+}
+
 // В конце останавливает себя и логгер
-func main() {
+func (w *worker) work(ctx context.Context) {
 	var err error
-	wpr := wrapper.CreateWrapper(Name, -1, -1)
-	// RadioKat implementation
-	wrapper.RadioKat = func(sender, key string, value any) {
-		sl.L.Info("[%s] GOT {sender: %s value: %s}", Name, sender, value)
-	}
-
-	defer sl.L.Warning("[%s] End task by timeout", wpr.Name)
+	defer sl.L.Warning("[%s] End task by timeout", w.wpr.Name)
 	var i int = 0
 	for {
 		select {
-		case <-wpr.StopChan:
-			sl.L.Warning("[%s] Stopping from Channel", wpr.Name)
+		case <-ctx.Done():
+			sl.L.Warning("[%s] Stopping from context", w.wpr.Name)
 			return
 		default:
-			err = wpr.SendToService(logger, wpr.Name, ciutils.IntToStr(i))
+			_, err = w.wpr.Enqueue(ctx, logger, ciutils.IntToStr(i)) // kept in bus until logger handle it
 			if err != nil {
 				sl.L.Warning(err.Error())
 				continue
 			}
 			if i == 10 {
-				fmt.Printf("[%s] Stopping by timeout\n", wpr.Name)
+				fmt.Printf("[%s] Stopping by timeout\n", w.wpr.Name)
 				//wpr.StopService("worker1")
 				//wpr.StopService(logger)
-				wpr.StartService("worker3")
+				w.wpr.StartService("worker3")
+				w.wpr.Stop()
 				return
 			}
 
@@ -49,3 +62,13 @@ func main() {
 		}
 	}
 }
+
+func main() {
+	wpr := wrapper.CreateWrapper(Name, -1, -1)
+	// RadioKat implementation
+	wrapper.RadioKat = func(sender, key string, value any) {
+		sl.L.Info("[%s] GOT {sender: %s value: %s}", Name, sender, value)
+	}
+
+	wrapper.Run(context.Background(), &worker{wpr: wpr})
+}
@@ -22,9 +22,9 @@ func main() {
 		MustStart: false, 
 		Required: []string{}, 
 		Env: map[string]string{"testname":"testvalue"}}
-	dspr.ProcessConfigs[WORKER1] = dspr.ProcessConfig{WORKER1, true, []string{LOGGER}, map[string]string{}}
-	dspr.ProcessConfigs[WORKER2] = dspr.ProcessConfig{WORKER2, false, []string{LOGGER}, map[string]string{}}
-	dspr.ProcessConfigs[WORKER3] = dspr.ProcessConfig{WORKER3, false, []string{LOGGER}, map[string]string{}}
+	dspr.ProcessConfigs[WORKER1] = dspr.ProcessConfig{Name: WORKER1, MustStart: true, Required: []string{LOGGER}, Env: map[string]string{}}
+	dspr.ProcessConfigs[WORKER2] = dspr.ProcessConfig{Name: WORKER2, MustStart: false, Required: []string{LOGGER}, Env: map[string]string{}}
+	dspr.ProcessConfigs[WORKER3] = dspr.ProcessConfig{Name: WORKER3, MustStart: false, Required: []string{LOGGER}, Env: map[string]string{}}
 
 	dspr.CreateDispatcher(0, 4, 1)
 	dspr.D.Launch()
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"os"
+
 	dspr "github.com/Averianov/cidispatcher"
 )
 
 func main() {
-	dspr.ManualSender()
-}
\ No newline at end of file
+	os.Exit(dspr.CLI(os.Args[1:]))
+}
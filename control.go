@@ -0,0 +1,79 @@
+package dispatcher
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Averianov/cidispatcher/wrapper"
+	sl "github.com/Averianov/cisystemlog"
+)
+
+// OnMessage of master: answer requests of sender (CLI); other messages prepared by RadioKat
+func (d *Dispatcher) OnMessage(msg *wrapper.RedisMessage) {
+	if msg.ReplyTo != "" && strings.ToUpper(msg.Sender) == wrapper.SENDER && d.Command(msg) {
+		return
+	}
+	wrapper.RunRadioKat(msg.Sender, msg.Key, msg.Value)
+}
+
+// Command execute request of sender and reply result; return false for unknown command
+func (d *Dispatcher) Command(msg *wrapper.RedisMessage) bool {
+	val, _ := msg.Value.(string)
+	var err error
+	switch msg.Key {
+	case wrapper.STATUS:
+		switch strings.ToUpper(val) {
+		case wrapper.GETINFO:
+			d.Wpr.Reply(msg, wrapper.STATUS, d.Status())
+			return true
+		case wrapper.EXIT:
+			sl.L.Alert("[master] got exit from %s", msg.Sender)
+			d.Wpr.Reply(msg, wrapper.STATUS, wrapper.OK)
+			go d.StopAll()
+			return true
+		}
+		return false
+
+	case wrapper.START:
+		err = d.command(val, func(task *Task) { d.RecurciveEnable(task) })
+	case wrapper.STOP:
+		err = d.command(val, func(task *Task) {
+			sl.L.Alert("[master] start recurcive stopping tasks from %s", task.Name)
+			d.RecurciveStop(task)
+		})
+	case wrapper.RESTART:
+		err = d.command(val, func(task *Task) {
+			task.Lock()
+			running := task.StLaunched
+			task.Unlock()
+			if running {
+				task.Restart()
+			} else {
+				d.RecurciveEnable(task)
+			}
+		})
+	case wrapper.RELOAD:
+		err = d.Reload()
+	default:
+		return false
+	}
+
+	answer := wrapper.OK
+	if err != nil {
+		answer = err.Error()
+	}
+	d.Wpr.Reply(msg, msg.Key, answer)
+	return true
+}
+
+// command apply fn to task or all replicas of group by name
+func (d *Dispatcher) command(name string, fn func(task *Task)) (err error) {
+	tasks := d.Members(name)
+	if len(tasks) == 0 || strings.ToUpper(name) == wrapper.SENDER {
+		return fmt.Errorf("unknown task %s", name)
+	}
+	for _, task := range tasks {
+		fn(task)
+	}
+	return
+}
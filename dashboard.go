@@ -0,0 +1,381 @@
+//go:build linux
+
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	"unsafe"
+
+	"github.com/Averianov/cidispatcher/wrapper"
+)
+
+const (
+	TOP_MESSAGE_WIDTH int = 60 // max printed length of value of message
+
+	// ANSI sequences supported by any VT100 compatible terminal (and over SSH)
+	ansiAltScreen  string = "\033[?1049h"
+	ansiMainScreen string = "\033[?1049l"
+	ansiHideCursor string = "\033[?25l"
+	ansiShowCursor string = "\033[?25h"
+	ansiHome       string = "\033[H"
+	ansiClearLine  string = "\033[K"
+	ansiClearDown  string = "\033[J"
+	ansiReverse    string = "\033[7m"
+	ansiReset      string = "\033[0m"
+)
+
+// channelStat is messages seen by dashboard on one channel
+type channelStat struct {
+	Channel string
+	Count   int
+	Last    time.Time
+	Sender  string
+	Key     string
+	Value   string
+	lastID  string
+}
+
+// dashboard is state of interactive top
+type dashboard struct {
+	sync.Mutex
+	status   DispatcherStatus
+	err      string // last error of status request
+	channels map[string]*channelStat
+	selected string // name of selected task
+	result   string // result of last command
+}
+
+// terminal is raw mode of terminal; restore return it to previous state
+type terminal struct {
+	fd    int
+	saved syscall.Termios
+}
+
+type winsize struct {
+	Row, Col, X, Y uint16
+}
+
+func ioctl(fd int, req uint, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(req), uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// rawTerminal switch off line buffering and echo; Ctrl-C still send SIGINT
+func rawTerminal(fd int) (term *terminal, err error) {
+	term = &terminal{fd: fd}
+	if err = ioctl(fd, syscall.TCGETS, unsafe.Pointer(&term.saved)); err != nil {
+		return nil, err
+	}
+	raw := term.saved
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	err = ioctl(fd, syscall.TCSETS, unsafe.Pointer(&raw))
+	return
+}
+
+func (term *terminal) restore() {
+	ioctl(term.fd, syscall.TCSETS, unsafe.Pointer(&term.saved))
+}
+
+// size of terminal; 80x24 when unknown
+func (term *terminal) size() (width, height int) {
+	var ws winsize
+	if err := ioctl(term.fd, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil || ws.Col == 0 || ws.Row == 0 {
+		return 80, 24
+	}
+	return int(ws.Col), int(ws.Row)
+}
+
+// top show live state of tasks and messages of bus until q or SIGINT / SIGTERM
+func (c *cli) top() (code int) {
+	term, err := rawTerminal(int(os.Stdin.Fd()))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "top require terminal")
+		return EXIT_USAGE
+	}
+	defer term.restore()
+	fmt.Fprint(c.out, ansiAltScreen+ansiHideCursor)
+	defer fmt.Fprint(c.out, ansiShowCursor+ansiMainScreen)
+
+	db := &dashboard{channels: map[string]*channelStat{}}
+	c.wpr.SetOnMessage(db.record)
+	c.wpr.Subscribe("*")
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+
+	keys := make(chan string, 8)
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- string(buf[:n])
+		}
+	}()
+
+	updated := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case updated <- struct{}{}:
+		default:
+		}
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			db.refresh(c)
+			notify()
+			select {
+			case <-stop:
+				return
+			case <-time.After(c.every):
+			}
+		}
+	}()
+
+	for {
+		width, height := term.size()
+		fmt.Fprint(c.out, db.render(width, height))
+		select {
+		case <-sig:
+			return EXIT_OK
+		case <-winch:
+		case <-updated:
+		case key, ok := <-keys:
+			if !ok {
+				return EXIT_OK
+			}
+			switch key {
+			case "q", "Q":
+				return EXIT_OK
+			case "k", "\033[A", "\033OA":
+				db.move(-1)
+			case "j", "\033[B", "\033OB":
+				db.move(1)
+			case "s":
+				db.control(c, wrapper.START, notify)
+			case "x":
+				db.control(c, wrapper.STOP, notify)
+			case "r":
+				db.control(c, wrapper.RESTART, notify)
+			}
+		}
+	}
+}
+
+// refresh request state of tasks
+func (db *dashboard) refresh(c *cli) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	reply, err := c.wpr.Request(ctx, wrapper.MASTER, wrapper.STATUS, wrapper.GETINFO)
+	var status DispatcherStatus
+	if err == nil {
+		status, err = wrapper.Decode[DispatcherStatus](reply)
+	}
+
+	db.Lock()
+	defer db.Unlock()
+	if err != nil {
+		db.err = fmt.Sprintf("dispatcher not answered: %s", err.Error())
+		return
+	}
+	db.err, db.status = "", status
+	if db.selected == "" && len(status.Tasks) > 0 {
+		db.selected = status.Tasks[0].Name
+	}
+}
+
+// record message seen on bus; requests of sender tools (dashboard itself) and answers are skipped
+func (db *dashboard) record(msg *wrapper.RedisMessage) {
+	if strings.HasPrefix(msg.Channel, wrapper.INBOX_PREFIX) || (msg.Sender == wrapper.SENDER && msg.ReplyTo != "") {
+		return
+	}
+	db.Lock()
+	defer db.Unlock()
+	stat, ok := db.channels[msg.Channel]
+	if !ok {
+		stat = &channelStat{Channel: msg.Channel}
+		db.channels[msg.Channel] = stat
+	}
+	if msg.ID != "" && msg.ID == stat.lastID { // own channel delivered by subscription and pattern
+		return
+	}
+	value := strings.Join(strings.Fields(fmt.Sprint(msg.Value)), " ")
+	if len(value) > TOP_MESSAGE_WIDTH {
+		value = value[:TOP_MESSAGE_WIDTH-3] + "..."
+	}
+	stat.Count++
+	stat.Last, stat.Sender, stat.Key, stat.Value, stat.lastID = time.Now(), msg.Sender, msg.Key, value, msg.ID
+}
+
+// move selection up or down
+func (db *dashboard) move(step int) {
+	db.Lock()
+	defer db.Unlock()
+	tasks := db.status.Tasks
+	if len(tasks) == 0 {
+		return
+	}
+	i := 0
+	for j, task := range tasks {
+		if task.Name == db.selected {
+			i = j
+		}
+	}
+	i = min(max(i+step, 0), len(tasks)-1)
+	db.selected = tasks[i].Name
+}
+
+// control send command for selected task; result shown in footer
+func (db *dashboard) control(c *cli, key string, notify func()) {
+	db.Lock()
+	name := db.selected
+	if name != "" {
+		db.result = fmt.Sprintf("%s %s ...", strings.ToLower(key), name)
+	}
+	db.Unlock()
+	if name == "" {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		defer cancel()
+		answer := ""
+		reply, err := c.wpr.Request(ctx, wrapper.MASTER, key, name)
+		if err != nil {
+			answer = err.Error()
+		} else {
+			answer = fmt.Sprint(reply.Value)
+		}
+		db.Lock()
+		db.result = fmt.Sprintf("%s %s: %s", strings.ToLower(key), name, answer)
+		db.Unlock()
+		notify()
+	}()
+}
+
+// render frame of dashboard for terminal of width x height
+func (db *dashboard) render(width, height int) string {
+	db.Lock()
+	defer db.Unlock()
+	status := db.status
+
+	running, unhealthy := 0, 0
+	for _, task := range status.Tasks {
+		if task.State == STATE_RUNNING {
+			running++
+		}
+		if task.Health == HEALTH_UNHEALTHY {
+			unhealthy++
+		}
+	}
+	header := []string{fmt.Sprintf("cidispatcher  pid %d  uptime %s  tasks %d  running %d  unhealthy %d  %s",
+		status.Pid, time.Duration(status.Uptime)*time.Second, len(status.Tasks), running, unhealthy, time.Now().Format(time.TimeOnly))}
+	if db.err != "" {
+		header = append(header, db.err)
+	}
+	header = append(header, "")
+
+	var table strings.Builder
+	tw := tabwriter.NewWriter(&table, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATE\tHEALTH\tPID\tUPTIME\tRESTARTS\tCPU%\tRSS\tTHR\tFDS")
+	selected := -1
+	for i, task := range status.Tasks {
+		pid, uptime, cpu, rss, threads, fds := "-", "-", "-", "-", "-", "-"
+		if task.Pid > 0 {
+			pid = fmt.Sprint(task.Pid)
+			uptime = (time.Duration(task.Uptime) * time.Second).String()
+		}
+		if task.Usage != nil {
+			cpu = fmt.Sprintf("%.1f", task.Usage.CPUPercent)
+			rss = fmt.Sprintf("%.1fM", float64(task.Usage.RSS)/(1<<20))
+			threads, fds = fmt.Sprint(task.Usage.Threads), fmt.Sprint(task.Usage.FDs)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n", task.Name, task.State, task.Health,
+			pid, uptime, task.Restarts, cpu, rss, threads, fds)
+		if task.Name == db.selected {
+			selected = i + 1
+		}
+	}
+	tw.Flush()
+	tasks := strings.Split(strings.TrimRight(table.String(), "\n"), "\n")
+
+	var tree strings.Builder
+	status.tree(&tree)
+	deps := append([]string{"", "DEPENDENCIES"}, strings.Split(strings.TrimRight(tree.String(), "\n"), "\n")...)
+
+	channels := make([]*channelStat, 0, len(db.channels))
+	for _, stat := range db.channels {
+		channels = append(channels, stat)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Last.After(channels[j].Last) })
+	var messages strings.Builder
+	tw = tabwriter.NewWriter(&messages, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHANNEL\tCOUNT\tLAST\tSENDER\tKEY\tVALUE")
+	for _, stat := range channels {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", stat.Channel, stat.Count, stat.Last.Format(time.TimeOnly), stat.Sender, stat.Key, stat.Value)
+	}
+	tw.Flush()
+	msgs := append([]string{""}, strings.Split(strings.TrimRight(messages.String(), "\n"), "\n")...)
+
+	footer := "up/down select  s start  x stop  r restart  q quit"
+	if db.result != "" {
+		footer += "  |  " + db.result
+	}
+
+	// tasks always shown; dependencies and messages get rest of lines
+	lines := append(header, tasks...)
+	rest := height - len(lines) - 1
+	for _, section := range [][]string{deps, msgs} {
+		if rest <= 1 {
+			break
+		}
+		if len(section) > rest {
+			section = section[:rest]
+		}
+		lines = append(lines, section...)
+		rest -= len(section)
+	}
+	if len(lines) > height-1 {
+		lines = lines[:max(height-1, 0)]
+	}
+	lines = append(lines, footer)
+
+	var b strings.Builder
+	b.WriteString(ansiHome)
+	for i, line := range lines {
+		if runes := []rune(line); len(runes) > width {
+			line = string(runes[:width])
+		}
+		if i == len(header)+selected && selected > 0 {
+			line = ansiReverse + line + ansiReset
+		}
+		b.WriteString(line + ansiClearLine)
+		if i < len(lines)-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	b.WriteString(ansiClearDown)
+	return b.String()
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Averianov/cidispatcher/wrapper"
@@ -17,6 +18,7 @@ import (
 
 const (
 	DEFAULT_CHECK_DURATION time.Duration = 10 // seconds
+	LOG_LEVEL_DEBUG        int32         = 4  // level of logs with Debug messages
 )
 
 var (
@@ -29,12 +31,35 @@ type ProcessConfig struct {
 	MustStart bool              `json:"must_start"`
 	Required  []string          `json:"required"`
 	Env       map[string]string `json:"env"`
+	Type      string            `json:"type"`               // TYPE_SERVICE (default) or TYPE_ONESHOT
+	Retry     *RetryPolicy      `json:"retry,omitempty"`    // relaunching of failed oneshot task
+	Schedule  *Schedule         `json:"schedule,omitempty"` // launch as one-shot task by schedule
+	Replicas  int               `json:"replicas"`           // count of instances NAME#0..NAME#N-1; 0 - single task NAME
+	Tags      []string          `json:"tags"`               // groups of task for broadcast and anycast messages
+	Acl       []Permission      `json:"acl,omitempty"`      // messages allowed to send; nil - all except application exit
+	Limits    []Limit           `json:"limits,omitempty"`   // soft thresholds of resources of process
+
+	WatchdogTimeout time.Duration `json:"watchdog_timeout,omitempty"` // restart service without wpr.Ping for this time; 0 - off
+	WatchdogDump    bool          `json:"watchdog_dump,omitempty"`    // save goroutine dump (SIGQUIT) of hanging service to log dir
+	StopSequence    []StopStep    `json:"stop_sequence,omitempty"`    // steps of stopping process; nil - DefaultStopSequence
+	Pipe            bool          `json:"pipe,omitempty"`             // connect process to bus by inherited socketpair instead of own connection
 }
 
 type Dispatcher struct {
+	sync.RWMutex
 	CheckDureation time.Duration
+	Started        time.Time
 	Wpr            *wrapper.Wrapper
 	Tasks          map[string]*Task
+	Configs        map[string]ProcessConfig // prepared configs by task (or replicas group) name
+	LogLevel       int32
+	SizeLogFile    int64
+	RedisAddr      string               // address of bus for tasks
+	Redis          *miniredis.Miniredis // embedded bus; nil with external Redis
+	keys           keystore
+	descendants    map[int]*Task            // descendants of task processes seen by reaper
+	removed        map[string]ProcessConfig // configs of removed tasks which may be still stopping
+	exitOnce       sync.Once
 }
 
 func CreateDispatcher(cd time.Duration, logLevel int32, sizeLogFile int64) (d *Dispatcher) {
@@ -48,14 +73,32 @@ func CreateDispatcher(cd time.Duration, logLevel int32, sizeLogFile int64) (d *D
 
 	D = new(Dispatcher)
 	D.CheckDureation = cd
+	D.Started = time.Now()
 	D.Tasks = map[string]*Task{}
+	D.Configs = map[string]ProcessConfig{}
+	D.LogLevel = logLevel
+	D.SizeLogFile = sizeLogFile
 
-	var mr *miniredis.Miniredis
-	mr, err = miniredis.Run()
+	if BusURL != "" {
+		D.RedisAddr = BusURL
+		sl.L.Info("[master] use external Redis")
+	} else {
+		var mr *miniredis.Miniredis
+		mr, D.RedisAddr, err = StartBus()
+		if err != nil {
+			panic(fmt.Sprintf("[master] %s", err.Error()))
+		}
+		sl.L.Info("[master] Radis server up on %s", D.RedisAddr)
+		D.Redis = mr
+	}
+	err = D.SetupAuth()
 	if err != nil {
 		panic(fmt.Sprintf("[master] %s", err.Error()))
 	}
-	sl.L.Info("[master] Radis server up on %s", mr.Port())
+	err = D.WriteBusAddr()
+	if err != nil {
+		sl.L.Warning("[master] %s", err.Error())
+	}
 
 	// var f *os.File
 	// f, err = os.OpenFile(wrapper.PORT_FILE_PATH, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
@@ -65,51 +108,193 @@ func CreateDispatcher(cd time.Duration, logLevel int32, sizeLogFile int64) (d *D
 	// f.WriteString(mr.Port())
 	// f.Close()
 
-	os.Setenv(wrapper.CI_REDIS_PORT, mr.Port())
+	os.Setenv(wrapper.CI_REDIS_ADDR, D.RedisAddr)
 	D.Wpr = wrapper.CreateWrapper(wrapper.MASTER, logLevel, sizeLogFile)
 	wrapper.RadioKat = D.RadioKat
+	D.Wpr.SetOnMessage(D.OnMessage) // requests of CLI
+	err = D.LoadSnapshot()
+	if err != nil {
+		sl.L.Warning("[master] load store err: %s", err.Error())
+	}
 
 	// upload Payload Data
 	// sl.L.Debug("[master] ToGo: %v\n", ftgc.ToGo) // static map with byte data from FileToGoConverter
 	for _, pc := range ProcessConfigs {
-		pc.Name = strings.ToUpper(pc.Name)
-		if raw, ok := ftgc.ToGo[pc.Name]; ok { // name in map FileToGoConverter in uppercase; name in uppercase
-			sl.L.Info("[master] add task %s", pc.Name)
-			D.Tasks[pc.Name] = &Task{
-				Name:        pc.Name,
-				ElfPayload:  raw,
-				StMustStart: pc.MustStart,
-				Required:    []string{},
-				Wpr:         D.Wpr,
-			}
-			for _, required := range pc.Required {
-				D.Tasks[pc.Name].Required = append(D.Tasks[pc.Name].Required, strings.ToUpper(required))
-			}
-			pc.Env[wrapper.NAME] = pc.Name
-			pc.Env[wrapper.LOG_LEVEL] = ciutils.IntToStr(int(logLevel))
-			pc.Env[wrapper.SIZE_LOG_FILE] = ciutils.Int64ToStr(sizeLogFile)
-			pc.Env[wrapper.CI_REDIS_PORT] = mr.Port()
-			for name, val := range pc.Env {
-				D.Tasks[pc.Name].Env = append(D.Tasks[pc.Name].Env, fmt.Sprintf("%s=%s", strings.ToUpper(name), strings.ToUpper(val)))
-			}
-			sl.L.Debug("[master] task %s - got envs:\n%v", pc.Name, D.Tasks[pc.Name].Env)
-		} else {
-			panic(fmt.Sprintf("[master] not found %s raw data\n", pc.Name))
+		err = D.AddTasks(pc)
+		if err != nil {
+			panic(fmt.Sprintf("[master] %s", err.Error()))
 		}
 	}
 
-	D.Tasks[wrapper.SENDER] = &Task{
+	D.AddTask(&Task{
 		Name:        wrapper.SENDER,
+		Type:        TYPE_SERVICE,
 		ElfPayload:  nil,
 		StMustStart: false,
 		Required:    []string{},
 		Wpr:         D.Wpr,
-	}
+		Replica:     -1,
+	})
 	return D
 }
 
+// AddTasks prepare process config and add its task (or replicas of task)
+func (d *Dispatcher) AddTasks(pc ProcessConfig) (err error) {
+	pc, err = PrepareConfig(pc)
+	if err != nil {
+		return
+	}
+
+	d.Lock()
+	d.Configs[pc.Name] = pc
+	d.Unlock()
+
+	if pc.Replicas < 1 {
+		d.AddTask(d.NewTask(pc, -1))
+		return
+	}
+	for i := 0; i < pc.Replicas; i++ {
+		d.AddTask(d.NewTask(pc, i))
+	}
+	return
+}
+
+// PrepareConfig validate process config and bring names to uppercase
+func PrepareConfig(pc ProcessConfig) (ProcessConfig, error) {
+	var err error
+	pc.Name = strings.ToUpper(pc.Name)
+	if _, ok := ftgc.ToGo[pc.Name]; !ok { // name in map FileToGoConverter in uppercase; name in uppercase
+		return pc, fmt.Errorf("not found %s raw data", pc.Name)
+	}
+
+	switch pc.Type {
+	case "":
+		pc.Type = TYPE_SERVICE
+		if pc.Schedule != nil {
+			pc.Type = TYPE_ONESHOT
+		}
+	case TYPE_SERVICE, TYPE_ONESHOT:
+	default:
+		return pc, fmt.Errorf("task %s - unknown type %s", pc.Name, pc.Type)
+	}
+	if pc.Schedule != nil {
+		err = pc.Schedule.Prepare()
+		if err != nil {
+			return pc, fmt.Errorf("task %s - wrong schedule: %s", pc.Name, err.Error())
+		}
+	}
+	limits := make([]Limit, len(pc.Limits))
+	for i, limit := range pc.Limits {
+		if err = limit.prepare(); err != nil {
+			return pc, fmt.Errorf("task %s - %s", pc.Name, err.Error())
+		}
+		limits[i] = limit
+	}
+	pc.Limits = limits
+	if pc.StopSequence != nil {
+		pc.StopSequence, err = prepareStopSequence(pc.StopSequence)
+		if err != nil {
+			return pc, fmt.Errorf("task %s - %s", pc.Name, err.Error())
+		}
+	}
+	required := make([]string, len(pc.Required))
+	for i, name := range pc.Required {
+		required[i] = strings.ToUpper(name)
+	}
+	pc.Required = required
+	return pc, nil
+}
+
+// NewTask create task from prepared process config; replica < 0 - task without replicas
+func (d *Dispatcher) NewTask(pc ProcessConfig, replica int) (task *Task) {
+	task = &Task{
+		Name:        pc.Name,
+		ElfPayload:  ftgc.ToGo[pc.Name],
+		StMustStart: pc.MustStart,
+		Required:    append([]string{}, pc.Required...),
+		Wpr:         d.Wpr,
+		Type:        pc.Type,
+		Retry:       pc.Retry,
+		Replica:     replica,
+		Acl:         pc.Acl,
+		Limits:      pc.Limits,
+
+		WatchdogTimeout: pc.WatchdogTimeout,
+		WatchdogDump:    pc.WatchdogDump,
+		StopSequence:    pc.StopSequence,
+		Pipe:            pc.Pipe,
+	}
+	task.PayloadHash = PayloadHash(task.ElfPayload)
+
+	if replica >= 0 {
+		task.Name = ReplicaName(pc.Name, replica)
+		task.Group = pc.Name
+	}
+
+	if pc.Schedule != nil {
+		task.Schedule = pc.Schedule
+		task.StMustStart = pc.Schedule.AtStart
+		if pc.Schedule.Periodic() {
+			task.NextRun = pc.Schedule.Next(ciutils.Now())
+		}
+	}
+
+	task.Env = d.TaskEnv(pc, task.Name, replica)
+	if AuthEnabled {
+		token := wrapper.NewToken()
+		d.Register(task.Name, token)
+		task.Env = append(task.Env, fmt.Sprintf("%s=%s", wrapper.CI_TOKEN, token))
+	}
+	sl.L.Debug("[master] task %s - got envs:\n%v", task.Name, task.Env)
+	return
+}
+
+// TaskEnv return env of task process without credentials
+func (d *Dispatcher) TaskEnv(pc ProcessConfig, name string, replica int) (taskEnv []string) {
+	env := map[string]string{}
+	for key, val := range pc.Env {
+		env[key] = val
+	}
+	if replica >= 0 {
+		env[wrapper.REPLICA_GROUP] = pc.Name
+		env[wrapper.REPLICA_INDEX] = ciutils.IntToStr(replica)
+	}
+	if len(pc.Tags) > 0 {
+		env[wrapper.TAGS] = strings.Join(pc.Tags, ",")
+	}
+	if pc.WatchdogTimeout > 0 {
+		env[wrapper.WATCHDOG] = ciutils.Int64ToStr(pc.WatchdogTimeout.Milliseconds())
+	}
+	env[wrapper.NAME] = name
+	env[wrapper.LOG_LEVEL] = ciutils.IntToStr(int(d.LogLevel))
+	env[wrapper.SIZE_LOG_FILE] = ciutils.Int64ToStr(d.SizeLogFile)
+	for key, val := range env {
+		taskEnv = append(taskEnv, fmt.Sprintf("%s=%s", strings.ToUpper(key), strings.ToUpper(val)))
+	}
+	// case-sensitive values
+	taskEnv = append(taskEnv, fmt.Sprintf("%s=%s", wrapper.CI_REDIS_ADDR, d.RedisAddr))
+	return
+}
+
+// AddTask add task to dispatcher
+func (d *Dispatcher) AddTask(task *Task) {
+	sl.L.Info("[master] add task %s", task.Name)
+	d.Lock()
+	d.Tasks[task.Name] = task
+	d.Unlock()
+}
+
 func (d *Dispatcher) Launch() {
 	//defer os.Remove(wrapper.PORT_FILE_PATH)
+	err := Subreaper()
+	if err != nil {
+		sl.L.Warning("[master] %s", err.Error())
+	}
+	go d.Reaper()
+	go d.Snapshots()
+	if InitMode {
+		sl.L.Info("[master] init mode: stop grace period %v", stopGrace())
+	}
 	time.Sleep(3 * time.Second)
 	d.StatusChecker()
 }
@@ -121,31 +306,79 @@ func (d *Dispatcher) RadioKat(sender, key string, value any) {
 		//sl.L.Debug("[master] got: %s-%s", key, val)
 		switch key {
 		case wrapper.STATUS:
-			if task, ok := d.Tasks[strings.ToUpper(sender)]; ok || strings.ToUpper(sender) == wrapper.MASTER{
+			task, ok := d.Task(sender)
+			if ok || strings.ToUpper(sender) == wrapper.MASTER {
 				switch strings.ToUpper(val) {
-				case wrapper.LAUNCHED:
-					task.Started()
-				case wrapper.STOPPED:
-					task.Stopped()
+				case wrapper.LAUNCHED, wrapper.STOPPED, wrapper.READY, wrapper.COMPLETED:
+					if task == nil { // lifecycle of master itself
+						break
+					}
+					switch strings.ToUpper(val) {
+					case wrapper.LAUNCHED:
+						task.Started()
+					case wrapper.STOPPED:
+						task.Stopped()
+					case wrapper.READY:
+						task.Ready()
+					case wrapper.COMPLETED:
+						task.Completed()
+					}
 				case wrapper.GETINFO:
-					smsg := d.StatusAfterChanges()
-					d.Wpr.SendToService(task.Name, wrapper.STATUS, smsg)
+					if task != nil {
+						d.Wpr.SendToService(task.Name, wrapper.STATUS, d.Status())
+					}
 				case wrapper.EXIT:
 					sl.L.Alert("[master] got exit from application")
 					d.StopAll()
 				}
 			}
 
+		case wrapper.SIGNAL:
+			if strings.ToUpper(sender) == wrapper.MASTER {
+				d.Signal(val)
+			}
+
+		case wrapper.RELOAD:
+			if strings.ToUpper(sender) == wrapper.MASTER || strings.ToUpper(sender) == wrapper.SENDER {
+				go d.Reload()
+			}
+
+		case wrapper.CONFIG:
+			if task, ok := d.Task(sender); ok {
+				task.Lock()
+				task.StReloadable = strings.ToUpper(val) == wrapper.RELOAD
+				task.Unlock()
+			}
+
+		case wrapper.FAILURE:
+			if task, ok := d.Task(sender); ok {
+				task.Fail(val)
+			}
+
+		case wrapper.HEARTBEAT:
+			if task, ok := d.Task(sender); ok {
+				task.Heartbeat()
+			}
+
 		case wrapper.START:
-			if target, ok := d.Tasks[strings.ToUpper(val)]; ok {
+			for _, target := range d.Members(val) {
 				target.Enable()
 			}
 
 		case wrapper.STOP:
-			if target, ok := d.Tasks[strings.ToUpper(val)]; ok {
+			for _, target := range d.Members(val) {
 				sl.L.Alert("[master] start recurcive stopping tasks from %s", target.Name)
 				d.RecurciveStop(target)
 			}
+
+		case wrapper.SCALE:
+			group, replicas, err := parseScale(val)
+			if err == nil {
+				err = d.Scale(group, replicas)
+			}
+			if err != nil {
+				sl.L.Warning("[master] scale err: %s", err.Error())
+			}
 		}
 	} else {
 		sl.L.Debug("[master] get unknow value: %s-%s", key, value)
@@ -156,9 +389,12 @@ func (d *Dispatcher) RecurciveStop(task *Task) {
 	task.Disable()
 	task.Stop()
 	sl.L.Info("[master] task %s - looping marked to stop", task.Name)
-	for _, childrenTask := range D.Tasks { // potencial children task
+	if task.Group != "" && len(d.Members(task.Group)) > 0 { // other replicas still serve children tasks
+		return
+	}
+	for _, childrenTask := range d.TaskList() { // potencial children task
 		for _, mainTaskName := range childrenTask.Required {
-			if task.Name == mainTaskName {
+			if task.Name == mainTaskName || task.Group == mainTaskName {
 				d.RecurciveStop(childrenTask)
 			}
 		}
@@ -166,10 +402,11 @@ func (d *Dispatcher) RecurciveStop(task *Task) {
 }
 
 func (d *Dispatcher) StopAll() {
-	for _, t := range d.Tasks {
+	tasks := d.TaskList()
+	for _, t := range tasks {
 		t.Disable()
 	}
-	for _, t := range d.Tasks {
+	for _, t := range tasks {
 		t.Stop()
 	}
 }
@@ -177,7 +414,13 @@ func (d *Dispatcher) StopAll() {
 func (d *Dispatcher) RecurciveEnable(task *Task) {
 	task.Enable()
 	for _, mainTaskName := range task.Required {
-		if mainTask, ok := D.Tasks[mainTaskName]; ok && !mainTask.StMustStart {
+		for _, mainTask := range d.Members(mainTaskName) {
+			if mainTask.StMustStart {
+				continue
+			}
+			if mainTask.Oneshot() && (mainTask.StCompleted || mainTask.StFailed) { // job not repeated for dependants
+				continue
+			}
 			sl.L.Info("[master] task %s - looping enable main task %s", task.Name, mainTask.Name)
 			d.RecurciveEnable(mainTask)
 		}
@@ -185,14 +428,28 @@ func (d *Dispatcher) RecurciveEnable(task *Task) {
 }
 
 func (d *Dispatcher) ReadyToWork(task *Task) (ready bool) {
-	for _, rq := range task.Required { // check available main tasks
-		req := d.Tasks[rq]
-		if req.StMustStart && req.StLaunched {
+	if !d.satisfied(task) {
+		return false
+	}
+	sl.L.Debug("[master] task %s - ready to work", task.Name)
+	return true
+}
+
+// satisfied all required tasks of task are available
+func (d *Dispatcher) satisfied(task *Task) bool {
+	for _, rq := range task.Required { // check available main tasks; for replicas enough one of them
+		satisfied := false
+		for _, req := range d.Members(rq) {
+			if req.Satisfied() {
+				satisfied = true
+				break
+			}
+		}
+		if satisfied {
 			continue
 		}
 		return false
 	}
-	sl.L.Debug("[master] task %s - ready to work", task.Name)
 	return true
 }
 
@@ -220,14 +477,28 @@ func (d *Dispatcher) StatusChecker() (err error) {
 		default:
 			if timeToCheck {
 				//### Tasks status before changes ####################################
-				d.StatusBeforeChanges()
+				if d.LogLevel >= LOG_LEVEL_DEBUG { // table of all tasks built only for debug logs
+					d.StatusBeforeChanges()
+				}
+
+				//### check Scheduled tasks ##########################
+				d.CheckSchedules()
+
+				//### sample resources of processes and check limits ##
+				d.SampleUsage()
+				d.CheckLimits()
+				d.CheckWatchdogs()
 
 				//### check Gracefull shutdown application ##########
 				readyToExit := true
-				for _, task := range d.Tasks {
+				for _, task := range d.TaskList() {
 					if task.Name == wrapper.SENDER {
 						continue
 					}
+					if task.Schedule != nil && (!task.NextRun.IsZero() || task.RunQueued) { // wait next scheduled run
+						readyToExit = false
+						break
+					}
 					if task.StMustStart || task.StMustStart != task.StLaunched { // if not ready to shutdown - disable marker
 						sl.L.Debug("[master] some tasks still in work; continue work")
 						readyToExit = false
@@ -245,29 +516,38 @@ func (d *Dispatcher) StatusChecker() (err error) {
 
 				if readyToExit {
 					sl.L.Info("[master] Gracefull shutdown application")
-					time.Sleep(time.Second * 3)
-					os.Exit(0)
+					d.Exit(time.Second * 3)
 				}
 
 				//### check Tasks #####################################
-				for _, task := range d.Tasks {
+				for _, task := range d.TaskList() {
 					if task.Name == wrapper.SENDER {
 						continue
 					}
 
 					switch true {
 					case task.StMustStart && task.StInProgress && task.StLaunched: // only check why still in progress
+						if ciutils.Now().Sub(task.RunStarted) < 2*d.CheckDureation { // time to start
+							continue
+						}
 						sl.L.Debug("[master] task %s - still in starting progress; try shutdown zombie process", task.Name)
-						err = task.Stop() // send reminders
+						err = task.Stop() // stop sequence
 						if err != nil {
 							sl.L.Warning("[master] %s err: %s ", task.Name, err.Error())
 						}
 					case task.StMustStart && task.StInProgress && !task.StLaunched: // when still not started
-						err = task.Stop() // send reminders
+						if ciutils.Now().Sub(task.RunStarted) < 2*d.CheckDureation { // time to start
+							continue
+						}
+						err = task.Stop() // stop sequence
 						if err != nil {
 							sl.L.Warning("[master] %s err: %s ", task.Name, err.Error())
 						}
 					case task.StMustStart && !task.StInProgress && !task.StLaunched: // must started
+						if task.WaitRetry() {
+							sl.L.Debug("[master] task %s - wait retry", task.Name)
+							continue
+						}
 						if d.ReadyToWork(task) {
 							if task.ElfPayload == nil {
 								sl.L.Alert("[master] task %s - service not available", task.Name)
@@ -285,9 +565,8 @@ func (d *Dispatcher) StatusChecker() (err error) {
 					case task.StMustStart && !task.StInProgress && task.StLaunched: // successfull launched
 						if !d.ReadyToWork(task) {
 							sl.L.Debug("[master] task %s not ready to work ", task.Name)
-							d.RecurciveEnable(task)         // enabling all main tasks
-							task.Reminder = KILLING_ATTEMPT // kill process who work without main processes
-							err = task.Stop()
+							d.RecurciveEnable(task) // enabling all main tasks
+							err = task.Stop()       // stop process who work without main processes
 							if err != nil {
 								sl.L.Warning("[master] %s err: %s ", task.Name, err.Error())
 							}
@@ -296,26 +575,30 @@ func (d *Dispatcher) StatusChecker() (err error) {
 						continue
 					case !task.StMustStart && !task.StInProgress && task.StLaunched: // must stopped
 						sl.L.Debug("[master] task %s - try shutdown worked process", task.Name)
-						err = task.Stop() // send reminders
+						err = task.Stop() // stop sequence
 						if err != nil {
 							sl.L.Warning("[master] %s err: %s ", task.Name, err.Error())
 						}
 					case !task.StMustStart && task.StInProgress && task.StLaunched: // when still not stopped
 						sl.L.Debug("[master] task %s - still in stopping progress; try shutdown zombie process", task.Name)
-						err = task.Stop() // send reminders
+						err = task.Stop() // stop sequence
 						if err != nil {
 							sl.L.Warning("[master] %s err: %s ", task.Name, err.Error())
 						}
 					case !task.StMustStart && task.StInProgress && !task.StLaunched: // only check why still in progress
-						err = task.Stop() // send reminders
+						err = task.Stop() // stop sequence
 						if err != nil {
 							sl.L.Warning("[master] %s err: %s ", task.Name, err.Error())
 						}
 					}
 				}
 
+				d.CleanRemoved()
+
 				//### Tasks status after changes ####################################
-				d.StatusAfterChanges()
+				if d.LogLevel >= LOG_LEVEL_DEBUG {
+					d.StatusAfterChanges()
+				}
 
 				timeToCheck = false // for exclude many check trying
 			} else {
@@ -325,20 +608,16 @@ func (d *Dispatcher) StatusChecker() (err error) {
 	}
 }
 
-func (d *Dispatcher) StatusBeforeChanges() (msg string) {
-	for _, task := range d.Tasks {
-		msg = msg + fmt.Sprintf("				[master]  %s	(Must: %v;	InProgress %v;	Current: %v)\n",
-			task.Name, task.StMustStart, task.StInProgress, task.StLaunched)
-	}
-	sl.L.Debug("[master] \n\n################################\n%s", msg)
+// StatusBeforeChanges log and return state of tasks before check; called on debug level only
+func (d *Dispatcher) StatusBeforeChanges() (status DispatcherStatus) {
+	status = d.Status()
+	sl.L.Debug("[master] \n\n################################\n%s", status.String())
 	return
 }
 
-func (d *Dispatcher) StatusAfterChanges() (msg string) {
-	for _, task := range d.Tasks {
-		msg = msg + fmt.Sprintf("				[master]  %s	(Must: %v;	InProgress %v;	Current: %v)\n",
-			task.Name, task.StMustStart, task.StInProgress, task.StLaunched)
-	}
-	sl.L.Debug("[master] \n\n%s\n################################\n\n", msg)
+// StatusAfterChanges log and return state of tasks after check; called on debug level only
+func (d *Dispatcher) StatusAfterChanges() (status DispatcherStatus) {
+	status = d.Status()
+	sl.L.Debug("[master] \n\n%s\n################################\n\n", status.String())
 	return
 }
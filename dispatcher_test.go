@@ -0,0 +1,64 @@
+package dispatcher
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Averianov/cidispatcher/wrapper"
+	sl "github.com/Averianov/cisystemlog"
+)
+
+func TestMain(m *testing.M) {
+	sl.CreateLogs("TEST", "", 1, 0)
+	AuthEnabled = true
+	os.Exit(m.Run())
+}
+
+// testDispatcher start embedded bus with auth and master wrapper; runtime files and logs go to temporary directory
+func testDispatcher(t *testing.T) (d *Dispatcher, addr string) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv(wrapper.CI_RUNTIME_DIR, dir)
+	t.Setenv(wrapper.CI_TOKEN, "")
+
+	d = &Dispatcher{Tasks: map[string]*Task{}, Configs: map[string]ProcessConfig{}, CheckDureation: 200 * time.Millisecond}
+	mr, addr, err := StartBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+	d.Redis, d.RedisAddr = mr, addr
+	if err = d.SetupAuth(); err != nil {
+		t.Fatal(err)
+	}
+	os.Unsetenv(wrapper.CI_TOKEN)
+	d.Wpr = testWrapper(t, d, addr, wrapper.MASTER)
+	return
+}
+
+// testWrapper connect service with own token to bus of dispatcher
+func testWrapper(t *testing.T, d *Dispatcher, addr, name string) *wrapper.Wrapper {
+	t.Helper()
+	token := wrapper.NewToken()
+	d.Register(name, token)
+	tr, err := wrapper.DialTransport(name, "tcp", addr, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wpr := wrapper.CreateWrapperWithTransport(name, 1, 0, tr)
+	t.Cleanup(func() { wpr.Transport.Close() })
+	return wpr
+}
+
+// waitFor poll cond until it is true or timeout
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(timeout); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,186 @@
+package dispatcher
+
+import (
+	"os"
+	"slices"
+	"time"
+
+	"github.com/Averianov/cidispatcher/wrapper"
+	sl "github.com/Averianov/cisystemlog"
+)
+
+const (
+	SIGNAL_STOP    string = "stop"    // stop all tasks by stop sequences and exit
+	SIGNAL_FORWARD string = "forward" // send signal to process groups of tasks; SIGTERM and SIGINT also disable tasks and exit
+	SIGNAL_RELOAD  string = "reload"  // reload configs
+	SIGNAL_IGNORE  string = "ignore"
+
+	DEFAULT_STOP_GRACE time.Duration = 10 * time.Second // grace period of container stop (docker) in init mode
+	STOP_GRACE_RESERVE time.Duration = time.Second      // part of grace period kept for exit of master
+)
+
+// InitMode master is init process of container (PID 1): stopping of tasks keeps grace period
+// (StopGrace or DEFAULT_STOP_GRACE) after SIGTERM
+var InitMode bool = os.Getpid() == 1
+
+// StopGrace is time from signal of stop to SIGKILL of master from container runtime (as terminationGracePeriodSeconds);
+// stop sequences are shortened to it and processes still alive at its end are killed; 0 - without limit
+var StopGrace time.Duration
+
+// osExit end master process; replaced in tests
+var osExit = os.Exit
+
+// SignalPolicy is handling of signals of master process by name of signal; signal without policy stops master
+var SignalPolicy = map[string]string{
+	"SIGTERM": SIGNAL_STOP,
+	"SIGINT":  SIGNAL_STOP,
+	"SIGHUP":  SIGNAL_RELOAD,
+}
+
+// stopGrace return grace period of stopping; in init mode DEFAULT_STOP_GRACE if StopGrace not set
+func stopGrace() time.Duration {
+	if StopGrace <= 0 && InitMode {
+		return DEFAULT_STOP_GRACE
+	}
+	return StopGrace
+}
+
+// Signal handle signal of master process by SignalPolicy
+func (d *Dispatcher) Signal(name string) {
+	policy, ok := SignalPolicy[name]
+	if !ok {
+		policy = SIGNAL_STOP
+	}
+	sl.L.Alert("[master] got %s: %s", name, policy)
+	switch policy {
+	case SIGNAL_RELOAD:
+		go d.Reload()
+	case SIGNAL_IGNORE:
+	case SIGNAL_FORWARD:
+		if name == "SIGTERM" || name == "SIGINT" {
+			d.Shutdown(name)
+			break
+		}
+		for _, task := range d.TaskList() {
+			if process := task.process(); process != nil {
+				if err := task.signal(process, stopSignals[name]); err != nil {
+					sl.L.Warning("[master] task %s - forward %s err: %s", task.Name, name, err.Error())
+				}
+			}
+		}
+	default:
+		d.Shutdown("")
+	}
+}
+
+// process return running process of task; nil if not launched
+func (task *Task) process() *os.Process {
+	task.Lock()
+	defer task.Unlock()
+	if task.Cmd == nil || task.Cmd.Process == nil {
+		return nil
+	}
+	return task.Cmd.Process
+}
+
+// Shutdown disable all tasks and stop them by stop sequences or by forwarded signal (if not empty);
+// with grace period steps are shortened to it, rest of processes killed at its end and master exit
+func (d *Dispatcher) Shutdown(forward string) {
+	grace := stopGrace()
+	within := max(grace-STOP_GRACE_RESERVE, grace/2)
+	tasks := d.TaskList()
+	for _, task := range tasks {
+		task.Disable()
+	}
+	for _, task := range tasks {
+		steps := task.stopSteps()
+		if forward != "" {
+			steps = []StopStep{{Signal: forward}}
+		}
+		if grace > 0 {
+			steps = withinGrace(steps, within)
+		}
+		task.StopBy(steps)
+	}
+	if grace > 0 {
+		go d.exitAfterTasks(time.Now().Add(within))
+	}
+}
+
+// exitAfterTasks wait exit of task processes until deadline, kill the rest and exit master
+func (d *Dispatcher) exitAfterTasks(deadline time.Time) {
+	killed := false
+	for {
+		running := 0
+		for _, task := range d.TaskList() {
+			process := task.process()
+			if process == nil {
+				continue
+			}
+			running++
+			if !killed && time.Now().After(deadline) {
+				sl.L.Alert("[master] task %s - not stopped in grace period; kill", task.Name)
+				task.Kill(process)
+			}
+		}
+		if running == 0 || killed && time.Now().After(deadline.Add(STOP_GRACE_RESERVE/2)) {
+			break
+		}
+		killed = killed || time.Now().After(deadline)
+		time.Sleep(100 * time.Millisecond)
+	}
+	d.Exit(0)
+}
+
+// required task must start by config or other task require it
+func (d *Dispatcher) required(task *Task, tasks []*Task) bool {
+	name := task.Name
+	if task.Group != "" {
+		name = task.Group
+	}
+	d.RLock()
+	pc := d.Configs[name]
+	d.RUnlock()
+	if pc.MustStart {
+		return true
+	}
+	for _, other := range tasks {
+		if slices.Contains(other.Required, name) || slices.Contains(other.Required, task.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExitCode of master: 1 if some required task failed - job failed all retries or last run of service
+// finished with error not by stop; else 0
+func (d *Dispatcher) ExitCode() (code int) {
+	tasks := d.TaskList()
+	for _, task := range tasks {
+		if task.Name == wrapper.SENDER || !d.required(task, tasks) {
+			continue
+		}
+		task.Lock()
+		failed := task.StFailed || (!task.Oneshot() && len(task.Runs) > 0 && task.Runs[len(task.Runs)-1].Failed())
+		task.Unlock()
+		if failed {
+			sl.L.Alert("[master] required task %s failed", task.Name)
+			code = 1
+		}
+	}
+	return
+}
+
+// Exit save state of dispatcher and exit master with ExitCode after wait; next calls wait exit
+func (d *Dispatcher) Exit(wait time.Duration) {
+	d.exitOnce.Do(func() {
+		if err := d.SaveSnapshot(); err != nil {
+			sl.L.Warning("[master] save store err: %s", err.Error())
+		}
+		os.Remove(wrapper.BusAddrFile())
+		code := d.ExitCode()
+		time.Sleep(wait)
+		sl.L.Info("[master] exit with code %d", code)
+		osExit(code)
+	})
+}
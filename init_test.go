@@ -0,0 +1,203 @@
+package dispatcher
+
+import (
+	"cmp"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Averianov/cidispatcher/wrapper"
+)
+
+// testExit catch exit of master; return channel with exit codes
+func testExit(t *testing.T) chan int {
+	codes := make(chan int, 1)
+	osExit = func(code int) { codes <- code }
+	t.Cleanup(func() { osExit = os.Exit })
+	return codes
+}
+
+// testSignalTask launch shell loop which record got signals to file "signals"; SIGTERM also exit it
+func testSignalTask(t *testing.T, d *Dispatcher, ignoreTerm bool) *Task {
+	t.Helper()
+	payload, err := os.ReadFile("/bin/sh")
+	if err != nil {
+		t.Skip("no /bin/sh")
+	}
+	term := "echo TERM >> signals; exit 0"
+	if ignoreTerm {
+		term = "echo TERM >> signals"
+	}
+	task := &Task{Name: "SH", Type: TYPE_SERVICE, Wpr: d.Wpr, Replica: -1, StMustStart: true, ElfPayload: payload,
+		StopSequence: []StopStep{{Signal: "SIGTERM"}}}
+	d.AddTask(task)
+	script := "trap '" + term + "' TERM; trap 'echo USR1 >> signals' USR1; echo > signals; while :; do sleep 0.05; done"
+	if err = task.LaunchInMemory([]string{"-c", script}); err != nil {
+		t.Fatal(err)
+	}
+	task.Started()
+	t.Cleanup(func() {
+		if p := task.process(); p != nil {
+			p.Kill()
+		}
+	})
+	waitFor(t, 2*time.Second, func() bool { _, err := os.Stat("signals"); return err == nil })
+	return task
+}
+
+func TestExitCode(t *testing.T) {
+	failed := []RunResult{{ExitCode: 1}}
+	tests := []struct {
+		name   string
+		task   *Task
+		config ProcessConfig
+		code   int
+	}{
+		{"service ok", &Task{Runs: []RunResult{{ExitCode: 0}}}, ProcessConfig{MustStart: true}, 0},
+		{"service failed", &Task{Runs: failed}, ProcessConfig{MustStart: true}, 1},
+		{"service killed", &Task{Runs: []RunResult{{ExitCode: -1, Killed: true}}}, ProcessConfig{MustStart: true}, 1},
+		{"service stopped", &Task{Runs: []RunResult{{ExitCode: -1, Killed: true, Stopped: true}}}, ProcessConfig{MustStart: true}, 0},
+		{"failed not required", &Task{Runs: failed}, ProcessConfig{}, 0},
+		{"job failed all retries", &Task{Type: TYPE_ONESHOT, StFailed: true}, ProcessConfig{MustStart: true}, 1},
+		{"job failed run before retry", &Task{Type: TYPE_ONESHOT, Runs: failed}, ProcessConfig{MustStart: true}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Dispatcher{Tasks: map[string]*Task{}, Configs: map[string]ProcessConfig{}}
+			task := tt.task
+			task.Name, task.Replica = "TASK", -1
+			if task.Type == "" {
+				task.Type = TYPE_SERVICE
+			}
+			d.Tasks[task.Name] = task
+			d.Configs[task.Name] = tt.config
+			if code := d.ExitCode(); code != tt.code {
+				t.Fatalf("code %d, want %d", code, tt.code)
+			}
+		})
+	}
+
+	// failed task required by other task; SENDER not counted
+	d := &Dispatcher{Tasks: map[string]*Task{}, Configs: map[string]ProcessConfig{}}
+	d.Tasks["DB"] = &Task{Name: "DB", Type: TYPE_SERVICE, Replica: -1, Runs: failed}
+	d.Tasks["APP"] = &Task{Name: "APP", Type: TYPE_SERVICE, Replica: -1, Required: []string{"DB"}}
+	d.Tasks[wrapper.SENDER] = &Task{Name: wrapper.SENDER, Type: TYPE_SERVICE, Replica: -1, Runs: failed}
+	d.Configs[wrapper.SENDER] = ProcessConfig{MustStart: true}
+	if code := d.ExitCode(); code != 1 {
+		t.Fatal("failed required task not counted")
+	}
+	delete(d.Tasks, "APP")
+	if code := d.ExitCode(); code != 0 {
+		t.Fatal("SENDER or not required task counted")
+	}
+}
+
+// TestSignalPolicy signals of master handled by policy: ignored, forwarded to tasks, reload or stop and exit
+func TestSignalPolicy(t *testing.T) {
+	policy, grace, loader := SignalPolicy, StopGrace, ConfigLoader
+	t.Cleanup(func() { SignalPolicy, StopGrace, ConfigLoader = policy, grace, loader })
+	StopGrace = 1500 * time.Millisecond // tasks stopped within 750ms, then master exit
+
+	tests := []struct {
+		signal, policy string
+		got            string // signals recorded by task
+		exit           bool   // tasks disabled and master exit
+	}{
+		{"SIGHUP", SIGNAL_IGNORE, "", false},
+		{"SIGUSR1", SIGNAL_FORWARD, "USR1", false},
+		{"SIGTERM", SIGNAL_FORWARD, "TERM", true},
+		{"SIGINT", SIGNAL_STOP, "TERM", true}, // by stop sequence of task
+		{"SIGQUIT", "", "TERM", true},         // without policy stop master
+	}
+	for _, tt := range tests {
+		t.Run(tt.signal+" "+cmp.Or(tt.policy, "default"), func(t *testing.T) {
+			d, _ := testDispatcher(t)
+			exits := testExit(t)
+			task := testSignalTask(t, d, false)
+			SignalPolicy = map[string]string{}
+			if tt.policy != "" {
+				SignalPolicy[tt.signal] = tt.policy
+			}
+
+			d.Signal(tt.signal)
+			if tt.exit {
+				select {
+				case <-exits:
+				case <-time.After(3 * time.Second):
+					t.Fatal("master not exited")
+				}
+				task.Lock()
+				disabled := !task.StMustStart
+				task.Unlock()
+				if task.process() != nil || !disabled {
+					t.Fatal("task not stopped and disabled")
+				}
+			} else {
+				time.Sleep(300 * time.Millisecond)
+				task.Lock()
+				disabled := !task.StMustStart
+				task.Unlock()
+				if task.process() == nil || disabled {
+					t.Fatal("task stopped")
+				}
+			}
+			raw, _ := os.ReadFile("signals")
+			if got := strings.TrimSpace(string(raw)); got != tt.got {
+				t.Fatalf("task got %q, want %q", got, tt.got)
+			}
+		})
+	}
+
+	t.Run("SIGHUP reload", func(t *testing.T) {
+		d, _ := testDispatcher(t)
+		SignalPolicy = map[string]string{"SIGHUP": SIGNAL_RELOAD}
+		loaded := make(chan struct{}, 1)
+		ConfigLoader = func() (map[string]ProcessConfig, error) {
+			loaded <- struct{}{}
+			return nil, errors.New("configs not changed")
+		}
+		d.Signal("SIGHUP")
+		select {
+		case <-loaded:
+		case <-time.After(2 * time.Second):
+			t.Fatal("configs not reloaded")
+		}
+	})
+}
+
+// TestExitAfterTasks master exit when tasks exited; task still running at deadline is killed
+func TestExitAfterTasks(t *testing.T) {
+	d, _ := testDispatcher(t)
+	exits := testExit(t)
+	start := time.Now()
+	go d.exitAfterTasks(start.Add(time.Hour))
+	select {
+	case <-exits:
+	case <-time.After(time.Second):
+		t.Fatal("master without tasks not exited")
+	}
+
+	d, _ = testDispatcher(t)
+	exits = testExit(t)
+	task := testSignalTask(t, d, true)
+	d.Configs[task.Name] = ProcessConfig{MustStart: true}
+	start = time.Now()
+	go d.exitAfterTasks(start.Add(300 * time.Millisecond))
+	var code int
+	select {
+	case code = <-exits:
+	case <-time.After(3 * time.Second):
+		t.Fatal("master not exited")
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("exited after %v, before deadline", elapsed)
+	}
+	if task.process() != nil {
+		t.Fatal("task not killed")
+	}
+	if code != 1 { // killed required service is failure
+		t.Fatal("exit code", code)
+	}
+}
@@ -0,0 +1,173 @@
+package dispatcher
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Averianov/cidispatcher/wrapper"
+)
+
+// TapRecord is message of bus seen by monitor; line of record file
+type TapRecord struct {
+	Time    time.Time       `json:"time"`
+	Channel string          `json:"channel"`
+	Message json.RawMessage `json:"message"` // envelope as published
+}
+
+// tapFilter select messages by patterns of channel, key and sender; empty pattern match all
+type tapFilter struct {
+	channel, key, sender string
+}
+
+// filter of monitor and replay from flags given in command line
+func (c *cli) filter() (f tapFilter) {
+	if c.set["ch"] {
+		f.channel = strings.ToUpper(c.channel)
+	}
+	if c.set["key"] {
+		f.key = strings.ToUpper(c.key)
+	}
+	f.sender = strings.ToUpper(c.from)
+	return
+}
+
+func (f tapFilter) match(channel string, msg *wrapper.RedisMessage) bool {
+	for _, pair := range [][2]string{{f.channel, channel}, {f.key, strings.ToUpper(msg.Key)}, {f.sender, strings.ToUpper(msg.Sender)}} {
+		if pair[0] == "" {
+			continue
+		}
+		if ok, _ := path.Match(pair[0], pair[1]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// printMessage show message as line of text or TapRecord in JSON
+func (c *cli) printMessage(at time.Time, channel string, msg *wrapper.RedisMessage, raw []byte) {
+	if c.output == OUTPUT_JSON {
+		line, _ := json.Marshal(TapRecord{Time: at, Channel: channel, Message: raw})
+		fmt.Fprintln(c.out, string(line))
+		return
+	}
+	value := fmt.Sprint(msg.Value)
+	if raw, err := json.Marshal(msg.Value); err == nil && msg.ContentType != wrapper.CT_PROTOBUF {
+		value = string(raw)
+	}
+	fmt.Fprintf(c.out, "%s  %-12s -> %-16s %-12s %s\n", at.Format("15:04:05.000"), msg.Sender, channel, msg.Key, value)
+}
+
+// monitor print every message of bus (and answers on requests) until SIGINT / SIGTERM
+func (c *cli) monitor() (code int) {
+	var file *os.File
+	if c.record != "" {
+		var err error
+		file, err = os.OpenFile(c.record, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return EXIT_FAILED
+		}
+		defer file.Close()
+	}
+
+	filter := c.filter()
+	var mutex sync.Mutex
+	lastID := map[string]string{}
+	c.wpr.SetOnMessage(func(msg *wrapper.RedisMessage) {
+		now := time.Now()
+		if !filter.match(msg.Channel, msg) {
+			return
+		}
+		envelope := *msg
+		if envelope.Data != nil { // value decoded by codec; Data is enough for replay
+			envelope.Value = nil
+		}
+		raw, err := json.Marshal(&envelope) // JSON envelope in record file also for codecs
+		if err != nil {
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if msg.ID != "" && lastID[msg.Channel] == msg.ID { // own channel delivered by subscription and pattern
+			return
+		}
+		lastID[msg.Channel] = msg.ID
+		c.printMessage(now, msg.Channel, msg, raw)
+		if file != nil {
+			line, _ := json.Marshal(TapRecord{Time: now, Channel: msg.Channel, Message: raw})
+			file.Write(append(line, '\n'))
+		}
+	})
+	pattern := filter.channel
+	if pattern == "" {
+		pattern = "*"
+	}
+	if err := c.wpr.Subscribe(pattern); err != nil {
+		fmt.Fprintf(os.Stderr, "monitor: %s\n", err.Error())
+		return EXIT_NO_BUS
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	<-sig
+	c.wpr.SetOnMessage(func(msg *wrapper.RedisMessage) {})
+	return EXIT_OK
+}
+
+// replay send messages of record file to their channels as sender with recorded pauses;
+// answers on requests are skipped
+func (c *cli) replay(name string) (code int) {
+	file, err := os.Open(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return EXIT_FAILED
+	}
+	defer file.Close()
+
+	filter := c.filter()
+	var last time.Time
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var rec TapRecord
+		msg := &wrapper.RedisMessage{}
+		err = json.Unmarshal(scanner.Bytes(), &rec)
+		if err == nil {
+			err = msg.UnmarshalBinary(rec.Message)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s:%d: %s\n", name, line, err.Error())
+			return EXIT_FAILED
+		}
+		if strings.HasPrefix(rec.Channel, wrapper.INBOX_PREFIX) || !filter.match(rec.Channel, msg) {
+			continue
+		}
+		if !last.IsZero() && c.speed > 0 && rec.Time.After(last) {
+			time.Sleep(time.Duration(float64(rec.Time.Sub(last)) / c.speed))
+		}
+		last = rec.Time
+
+		if err = c.wpr.Resend(rec.Channel, msg); err != nil {
+			fmt.Fprintf(os.Stderr, "%s:%d: %s\n", name, line, err.Error())
+			return EXIT_FAILED
+		}
+		c.printMessage(time.Now(), rec.Channel, msg, rec.Message)
+	}
+	if err = scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", name, err.Error())
+		return EXIT_FAILED
+	}
+	return
+}
@@ -0,0 +1,99 @@
+package dispatcher
+
+import (
+	"time"
+
+	sl "github.com/Averianov/cisystemlog"
+	"github.com/Averianov/ciutils"
+)
+
+const (
+	TYPE_SERVICE string = "service" // long-running task; relaunched while must be started
+	TYPE_ONESHOT string = "oneshot" // job; successful exit mean completed
+
+	DEFAULT_RETRY_DELAY time.Duration = 5 * time.Second
+)
+
+// RetryPolicy describe relaunching of failed oneshot task
+type RetryPolicy struct {
+	Attempts int           `json:"attempts"`  // count of retries after first failure
+	Delay    time.Duration `json:"delay"`     // delay before first retry; doubled for every next
+	MaxDelay time.Duration `json:"max_delay"` // limit of delay; 0 - without limit
+}
+
+// backoff return delay before retry number attempt (from 1)
+func (rp *RetryPolicy) backoff(attempt int) (delay time.Duration) {
+	delay = rp.Delay
+	if delay <= 0 {
+		delay = DEFAULT_RETRY_DELAY
+	}
+	for i := 1; i < attempt; i++ {
+		delay = delay * 2
+		if rp.MaxDelay > 0 && delay >= rp.MaxDelay {
+			return rp.MaxDelay
+		}
+	}
+	return
+}
+
+// Oneshot task finished after successful exit
+func (task *Task) Oneshot() bool {
+	return task.Type == TYPE_ONESHOT
+}
+
+// Satisfied task can be used as required main task
+func (task *Task) Satisfied() bool {
+	if task.Oneshot() {
+		return task.StCompleted
+	}
+	return task.StMustStart && task.StLaunched
+}
+
+// Finished preparing exit of oneshot task: completing or retry by policy
+func (task *Task) Finished(run RunResult) {
+	if !task.Oneshot() {
+		return
+	}
+
+	if run.ExitCode == 0 && !run.Killed {
+		task.Completed()
+		return
+	}
+
+	task.Lock()
+	task.Attempts++
+	if task.Retry != nil && task.Attempts <= task.Retry.Attempts {
+		task.RetryAt = ciutils.Now().Add(task.Retry.backoff(task.Attempts))
+		task.Unlock()
+		sl.L.Warning("[task] %s failed with code %d; retry No %d at %s",
+			task.Name, run.ExitCode, task.Attempts, task.RetryAt.Format(time.DateTime))
+		return
+	}
+	task.StMustStart = false
+	task.StFailed = true
+	task.Unlock()
+	sl.L.Alert("[task] %s failed with code %d; no more retries", task.Name, run.ExitCode)
+}
+
+// Completed mark oneshot task as successfully finished
+// (again for next run of scheduled job)
+func (task *Task) Completed() {
+	task.Lock()
+	already := task.StCompleted && !task.StMustStart
+	task.StMustStart = false
+	task.StCompleted = true
+	task.StFailed = false
+	task.Attempts = 0
+	task.RetryAt = time.Time{}
+	task.Unlock()
+	if already {
+		sl.L.Debug("[task] %s already completed", task.Name)
+		return
+	}
+	sl.L.Info("[task] %s completed", task.Name)
+}
+
+// WaitRetry task must not be launched before retry time
+func (task *Task) WaitRetry() bool {
+	return !task.RetryAt.IsZero() && ciutils.Now().Before(task.RetryAt)
+}
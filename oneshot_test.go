@@ -0,0 +1,72 @@
+package dispatcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	rp := &RetryPolicy{Delay: time.Second, MaxDelay: 3 * time.Second}
+	for attempt, want := range []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if attempt == 0 {
+			continue
+		}
+		if got := rp.backoff(attempt); got != want {
+			t.Errorf("attempt %d: got %v want %v", attempt, got, want)
+		}
+	}
+	if got := (&RetryPolicy{}).backoff(1); got != DEFAULT_RETRY_DELAY {
+		t.Errorf("default delay %v", got)
+	}
+}
+
+func TestOneshotRetry(t *testing.T) {
+	task := &Task{Name: "JOB", Type: TYPE_ONESHOT, StMustStart: true, Retry: &RetryPolicy{Attempts: 2, Delay: time.Hour}}
+	for attempt := 1; attempt <= 2; attempt++ {
+		task.Finished(RunResult{ExitCode: 1})
+		if task.Attempts != attempt || task.StFailed || !task.StMustStart || !task.WaitRetry() {
+			t.Fatalf("attempt %d: %+v", attempt, task)
+		}
+	}
+	task.Finished(RunResult{ExitCode: 1})
+	if !task.StFailed || task.StMustStart || task.Satisfied() {
+		t.Fatalf("not failed after retries: %+v", task)
+	}
+
+	task.Enable()
+	if task.StFailed || task.Attempts != 0 || task.WaitRetry() {
+		t.Fatalf("not reset by Enable: %+v", task)
+	}
+	task.Finished(RunResult{ExitCode: 0, Killed: true}) // killed job is not completed
+	if task.StCompleted || task.Attempts != 1 {
+		t.Fatalf("killed run completed: %+v", task)
+	}
+}
+
+// TestOneshotCompletedAgain next run of scheduled job stops after success like the first one
+func TestOneshotCompletedAgain(t *testing.T) {
+	task := &Task{Name: "JOB", Type: TYPE_ONESHOT, StMustStart: true}
+	for run := 1; run <= 2; run++ {
+		task.Finished(RunResult{ExitCode: 0})
+		if !task.StCompleted || task.StMustStart || !task.Satisfied() {
+			t.Fatalf("run %d not completed: %+v", run, task)
+		}
+		task.Enable()
+		if !task.StMustStart {
+			t.Fatalf("run %d not enabled", run)
+		}
+	}
+	task.Completed() // COMPLETED from wrapper and exit of process
+	task.Completed()
+	if task.StMustStart {
+		t.Fatal("must start after completed")
+	}
+}
+
+func TestServiceNotCompleted(t *testing.T) {
+	task := &Task{Name: "SVC", Type: TYPE_SERVICE, StMustStart: true}
+	task.Finished(RunResult{ExitCode: 0})
+	if task.StCompleted || !task.StMustStart {
+		t.Fatalf("service completed: %+v", task)
+	}
+}
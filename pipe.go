@@ -0,0 +1,46 @@
+package dispatcher
+
+import (
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/Averianov/cidispatcher/wrapper"
+)
+
+const PIPE_FD int = 3 // descriptor of pipe in task process: first of ExtraFiles
+
+// OpenPipe create socketpair for task with Pipe: child end is inherited by process as PIPE_FD, own end is
+// bridged to bus by connection opened on behalf of task, so process has no own connection to bus
+func (task *Task) OpenPipe() (child *os.File, bridge *wrapper.PipeTransport, err error) {
+	var network, addr string
+	network, addr, err = wrapper.BusAddr()
+	if err != nil {
+		return
+	}
+	var bus wrapper.Transport
+	bus, err = wrapper.DialTransport(task.Name, network, addr, taskEnv(task.Env, wrapper.CI_TOKEN))
+	if err != nil {
+		return
+	}
+
+	var fds [2]int
+	fds, err = syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		bus.Close()
+		return
+	}
+	child = os.NewFile(uintptr(fds[1]), task.Name+" pipe")
+	bridge = wrapper.BridgePipe(os.NewFile(uintptr(fds[0]), task.Name+" pipe"), bus)
+	return
+}
+
+// taskEnv return value of variable from env of task
+func taskEnv(env []string, key string) (val string) {
+	for _, e := range env {
+		if v, ok := strings.CutPrefix(e, key+"="); ok {
+			val = v
+		}
+	}
+	return
+}
@@ -0,0 +1,39 @@
+package dispatcher
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Averianov/cidispatcher/wrapper"
+)
+
+// TestPipeTask process with Pipe get socketpair as fd 3 instead of address of bus; master repeat its
+// subscriptions on bus on behalf of task and drop them when process exit
+func TestPipeTask(t *testing.T) {
+	d, addr := testDispatcher(t)
+	t.Setenv(wrapper.CI_REDIS_ADDR, addr) // address of bus for master, as set by CreateDispatcher
+	payload, err := os.ReadFile("/bin/sh")
+	if err != nil {
+		t.Skip("no /bin/sh")
+	}
+	token := wrapper.NewToken()
+	d.Register("PIPE", token)
+	task := &Task{Name: "PIPE", Type: TYPE_SERVICE, Wpr: d.Wpr, Replica: -1, ElfPayload: payload, Pipe: true,
+		Env: []string{wrapper.CI_REDIS_ADDR + "=" + addr, wrapper.CI_TOKEN + "=" + token}}
+	d.AddTask(task)
+	// frame of pipe: subscribe channel "PIPE"
+	err = task.LaunchInMemory([]string{"-c", `[ "$CIREDISADDR" = fd://3 ] || exit 1; printf 'S\004PIPE\000' >&3; while :; do sleep 0.05; done`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	numSub := func() int64 {
+		subs, _ := d.Wpr.Transport.NumSub(context.Background(), "PIPE")
+		return subs["PIPE"]
+	}
+	waitFor(t, 2*time.Second, func() bool { return numSub() == 1 })
+	task.process().Kill()
+	waitFor(t, 2*time.Second, func() bool { return numSub() == 0 })
+}
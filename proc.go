@@ -0,0 +1,89 @@
+package dispatcher
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const CLK_TCK float64 = 100 // clock ticks per second of /proc/<pid>/stat on Linux
+
+// Usage is resource usage of process read from /proc
+type Usage struct {
+	CPU        float64 `json:"cpu"`                   // user and system CPU time, seconds
+	CPUPercent float64 `json:"cpu_percent,omitempty"` // CPU usage between two last samples; 100 - one core
+	RSS        int64   `json:"rss"`                   // resident memory, bytes
+	RSSPeak    int64   `json:"rss_peak,omitempty"`    // peak of resident memory, bytes
+	Threads    int     `json:"threads"`
+	FDs        int     `json:"fds"`         // open file descriptors
+	ReadBytes  int64   `json:"read_bytes"`  // read from storage, bytes
+	WriteBytes int64   `json:"write_bytes"` // written to storage, bytes
+}
+
+// ReadUsage return resource usage of process by pid
+func ReadUsage(pid int) (usage Usage, err error) {
+	var raw []byte
+	raw, err = os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return
+	}
+	// name of process in brackets may contain spaces: fields counted after last ")"
+	stat := string(raw)
+	i := strings.LastIndex(stat, ")")
+	if i < 0 {
+		err = fmt.Errorf("wrong stat of pid %d", pid)
+		return
+	}
+	fields := strings.Fields(stat[i+1:]) // from field 3 (state)
+	if len(fields) < 22 {
+		err = fmt.Errorf("wrong stat of pid %d", pid)
+		return
+	}
+	utime, _ := strconv.ParseFloat(fields[11], 64)
+	stime, _ := strconv.ParseFloat(fields[12], 64)
+	usage.CPU = (utime + stime) / CLK_TCK
+	usage.Threads, _ = strconv.Atoi(fields[17])
+	pages, _ := strconv.ParseInt(fields[21], 10, 64)
+	usage.RSS = pages * int64(os.Getpagesize())
+
+	if fds, ferr := os.ReadDir(fmt.Sprintf("/proc/%d/fd", pid)); ferr == nil {
+		usage.FDs = len(fds)
+	}
+	// status and io may be not available (kernel without task io accounting); usage still valid
+	if status, ferr := readProcValues(pid, "status"); ferr == nil {
+		usage.RSSPeak = status["VmHWM"] * 1024 // in kB
+	}
+	if io, ferr := readProcValues(pid, "io"); ferr == nil {
+		usage.ReadBytes, usage.WriteBytes = io["read_bytes"], io["write_bytes"]
+	}
+	return
+}
+
+// readProcValues parse numeric "name: value" lines of /proc/<pid>/<file>
+func readProcValues(pid int, file string) (values map[string]int64, err error) {
+	var f *os.File
+	f, err = os.Open(fmt.Sprintf("/proc/%d/%s", pid, file))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	values = map[string]int64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		if n, perr := strconv.ParseInt(fields[0], 10, 64); perr == nil {
+			values[name] = n
+		}
+	}
+	err = scanner.Err()
+	return
+}
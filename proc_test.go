@@ -0,0 +1,69 @@
+package dispatcher
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// TestReadUsage usage of own process from /proc: growing CPU time, memory, threads and descriptors
+func TestReadUsage(t *testing.T) {
+	first, err := ReadUsage(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.RSS <= 0 || first.Threads <= 0 || first.FDs <= 0 {
+		t.Fatalf("%+v", first)
+	}
+	if first.RSSPeak > 0 && first.RSSPeak < first.RSS/2 { // peak from status in kB; sampled at other time
+		t.Fatalf("peak %d, rss %d", first.RSSPeak, first.RSS)
+	}
+
+	var files []*os.File
+	for range 10 {
+		f, err := os.Open(os.DevNull)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	for start := time.Now(); time.Since(start) < 100*time.Millisecond; { // busy for CPU time
+	}
+	second, err := ReadUsage(os.Getpid())
+	for _, f := range files {
+		f.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.CPU <= first.CPU {
+		t.Fatalf("cpu %v then %v", first.CPU, second.CPU)
+	}
+	if second.FDs <= first.FDs { // others may close own descriptors meanwhile
+		t.Fatalf("fds %d then %d with %d opened", first.FDs, second.FDs, len(files))
+	}
+
+	if _, err = ReadUsage(-1); err == nil {
+		t.Fatal("usage of not existing process")
+	}
+}
+
+// TestReadProcValues numeric "name: value" lines of status; units after value ignored
+func TestReadProcValues(t *testing.T) {
+	status, err := readProcValues(os.Getpid(), "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status["Pid"] != int64(os.Getpid()) || status["PPid"] != int64(os.Getppid()) {
+		t.Fatalf("pid %d, ppid %d", status["Pid"], status["PPid"])
+	}
+	if status["VmRSS"] <= 0 || status["Threads"] <= 0 { // "VmRSS:  1234 kB"
+		t.Fatalf("%v", status)
+	}
+	if _, ok := status["Name"]; ok { // not numeric
+		t.Fatal("name of process parsed as number")
+	}
+	if _, err = readProcValues(os.Getpid(), "no-such-file"); err == nil {
+		t.Fatal("values of missing file")
+	}
+}
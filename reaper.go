@@ -0,0 +1,191 @@
+//go:build linux
+
+package dispatcher
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	sl "github.com/Averianov/cisystemlog"
+)
+
+const PR_SET_CHILD_SUBREAPER uintptr = 36 // prctl option, Linux 3.4+
+
+// launching guard start of task processes: pid of just started process must be known
+// to reaper before it can wait processes which are not tasks
+var launching sync.RWMutex
+
+// procInfo is process from /proc/<pid>/stat
+type procInfo struct {
+	Pid   int
+	PPid  int
+	Pgid  int
+	State string // R, S, D, Z (zombie) ...
+}
+
+// Subreaper make master child subreaper: orphaned descendants of tasks are reparented
+// to master (instead of init) and reaped by Reaper
+func Subreaper() (err error) {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, PR_SET_CHILD_SUBREAPER, 1, 0)
+	if errno != 0 {
+		err = fmt.Errorf("set child subreaper: %s", errno.Error())
+	}
+	return
+}
+
+// readProc return process by pid from /proc
+func readProc(pid int) (proc procInfo, err error) {
+	var raw []byte
+	raw, err = os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return
+	}
+	stat := string(raw)
+	i := strings.LastIndex(stat, ")")
+	var fields []string
+	if i >= 0 {
+		fields = strings.Fields(stat[i+1:])
+	}
+	if len(fields) < 3 {
+		err = fmt.Errorf("wrong stat of pid %d", pid)
+		return
+	}
+	proc = procInfo{Pid: pid, State: fields[0]}
+	proc.PPid, _ = strconv.Atoi(fields[1])
+	proc.Pgid, _ = strconv.Atoi(fields[2])
+	return
+}
+
+// listProcs return all processes of /proc
+func listProcs() (procs []procInfo) {
+	entries, _ := os.ReadDir("/proc")
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if proc, err := readProc(pid); err == nil {
+			procs = append(procs, proc)
+		}
+	}
+	return
+}
+
+// Reaper reap orphans on SIGCHLD and on every check
+func (d *Dispatcher) Reaper() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGCHLD)
+	tick := time.NewTicker(d.CheckDureation)
+	defer tick.Stop()
+	for {
+		d.ReapOrphans()
+		select {
+		case <-sig:
+		case <-tick.C:
+		}
+	}
+}
+
+// ReapOrphans wait exited children of master which are not task processes and attribute living ones
+// to task: by process group of task or by descendants of task process seen before
+func (d *Dispatcher) ReapOrphans() {
+	launching.Lock()
+	defer launching.Unlock()
+
+	self := os.Getpid()
+	tasks := d.TaskList()
+	roots := map[int]*Task{}  // pid of task process
+	groups := map[int]*Task{} // process group of task
+	for _, task := range tasks {
+		task.Lock()
+		if task.Cmd != nil && task.Cmd.Process != nil {
+			roots[task.Cmd.Process.Pid] = task
+		}
+		if task.Pgid > 0 {
+			groups[task.Pgid] = task
+		}
+		task.Unlock()
+	}
+
+	procs := listProcs()
+	children := map[int][]int{}
+	for _, proc := range procs {
+		children[proc.PPid] = append(children[proc.PPid], proc.Pid)
+	}
+	// descendants of running task processes for orphans which left process group (setsid)
+	seen := map[int]*Task{}
+	for pid, task := range roots {
+		stack := append([]int{}, children[pid]...)
+		for len(stack) > 0 {
+			child := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			seen[child] = task
+			stack = append(stack, children[child]...)
+		}
+	}
+
+	orphans := map[*Task][]int{}
+	for _, proc := range procs {
+		if proc.PPid != self || roots[proc.Pid] != nil {
+			continue
+		}
+		task := groups[proc.Pgid]
+		if task == nil {
+			task = d.descendants[proc.Pid]
+		}
+		name := "unknown task"
+		if task != nil {
+			name = task.Name
+		}
+
+		if proc.State == "Z" {
+			var status syscall.WaitStatus
+			if pid, err := syscall.Wait4(proc.Pid, &status, syscall.WNOHANG, nil); err == nil && pid == proc.Pid {
+				sl.L.Info("[master] reaped orphan %d of %s with code %d", proc.Pid, name, status.ExitStatus())
+			}
+			continue
+		}
+		if task != nil {
+			orphans[task] = append(orphans[task], proc.Pid)
+			seen[proc.Pid] = task
+		}
+	}
+
+	for _, task := range tasks {
+		task.Lock()
+		for _, pid := range orphans[task] {
+			if !slices.Contains(task.Orphans, pid) {
+				sl.L.Warning("[master] task %s - orphan process %d reparented to master", task.Name, pid)
+			}
+		}
+		task.Orphans = orphans[task]
+		task.Unlock()
+	}
+	d.descendants = seen
+}
+
+// KillOrphans kill processes left by exited task process: rest of its process group and its orphans
+func (task *Task) KillOrphans() {
+	task.Lock()
+	pgid, orphans := task.Pgid, task.Orphans
+	task.Orphans = nil
+	task.Unlock()
+
+	if pgid > 0 {
+		if err := syscall.Kill(-pgid, syscall.SIGKILL); err == nil {
+			sl.L.Warning("[task] %s - killed rest of process group %d", task.Name, pgid)
+		}
+	}
+	for _, pid := range orphans { // children of master: pid not reused before they are reaped
+		if err := syscall.Kill(pid, syscall.SIGKILL); err == nil {
+			sl.L.Warning("[task] %s - killed orphan %d", task.Name, pid)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+//go:build linux
+
+package dispatcher
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestReapOrphans grandchild of task left by killed parent is reparented to master and attributed to task:
+// by process group, or by descendants seen before when it left the group; killed with task process
+func TestReapOrphans(t *testing.T) {
+	payload, err := os.ReadFile("/bin/sh")
+	if err != nil {
+		t.Skip("no /bin/sh")
+	}
+	if err = Subreaper(); err != nil {
+		t.Skip(err)
+	}
+	for _, setsid := range []bool{false, true} {
+		t.Run(fmt.Sprintf("setsid=%v", setsid), func(t *testing.T) {
+			d, _ := testDispatcher(t)
+			prefix := ""
+			if setsid {
+				path, err := exec.LookPath("setsid")
+				if err != nil {
+					t.Skip("no setsid")
+				}
+				prefix = path + " " // background job is not group leader: new session without fork
+			}
+			task := &Task{Name: "FORKER", Type: TYPE_SERVICE, Wpr: d.Wpr, Replica: -1, ElfPayload: payload}
+			d.AddTask(task)
+			script := prefix + `sh -c 'sleep 30 & echo $! > grand; echo $$ > parent; wait' & while :; do sleep 0.05; done`
+			if err := task.LaunchInMemory([]string{"-c", script}); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				if p := task.process(); p != nil {
+					p.Kill()
+				}
+			})
+
+			pid := func(file string) (pid int) {
+				raw, _ := os.ReadFile(file)
+				pid, _ = strconv.Atoi(strings.TrimSpace(string(raw)))
+				return
+			}
+			waitFor(t, 2*time.Second, func() bool { return pid("grand") > 0 && pid("parent") > 0 })
+			grand := pid("grand")
+			task.Lock()
+			pgid := task.Pgid
+			task.Unlock()
+			if proc, _ := readProc(grand); setsid == (proc.Pgid == pgid) {
+				t.Fatalf("group of grandchild %d, of task %d", proc.Pgid, pgid)
+			}
+			d.ReapOrphans() // descendants of task process seen while parent is alive
+
+			syscall.Kill(pid("parent"), syscall.SIGKILL)
+			waitFor(t, 2*time.Second, func() bool {
+				d.ReapOrphans()
+				return slices.Contains(task.Status().Orphans, grand)
+			})
+
+			task.process().Kill()
+			waitFor(t, 2*time.Second, func() bool { // orphan killed with task and reaped by master
+				d.ReapOrphans()
+				_, err := readProc(grand)
+				return err != nil
+			})
+		})
+	}
+}
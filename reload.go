@@ -0,0 +1,239 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Averianov/cidispatcher/wrapper"
+	sl "github.com/Averianov/cisystemlog"
+	"github.com/Averianov/ciutils"
+)
+
+const DEFAULT_RELOAD_TIMEOUT time.Duration = 5 * time.Second // wait answer of task on pushed config
+
+// ConfigLoader read process configs again on reload (SIGHUP of master or RELOAD from sender);
+// if nil - ProcessConfigs used as is
+var ConfigLoader func() (map[string]ProcessConfig, error)
+
+var reloading sync.Mutex // one reload at time
+
+// Reload read process configs and apply difference: add new tasks, remove disappeared,
+// push changed env to running tasks which support reload and restart others
+func (d *Dispatcher) Reload() (err error) {
+	reloading.Lock()
+	defer reloading.Unlock()
+
+	configs := ProcessConfigs
+	if ConfigLoader != nil {
+		configs, err = ConfigLoader()
+		if err != nil {
+			sl.L.Warning("[master] reload err: %s", err.Error())
+			return
+		}
+	}
+	sl.L.Info("[master] reload configs")
+
+	fresh := map[string]ProcessConfig{}
+	for _, pc := range configs {
+		pc, err = PrepareConfig(pc)
+		if err != nil {
+			sl.L.Warning("[master] reload err: %s", err.Error())
+			return
+		}
+		fresh[pc.Name] = pc
+	}
+
+	d.RLock()
+	current := make(map[string]ProcessConfig, len(d.Configs))
+	for name, pc := range d.Configs {
+		current[name] = pc
+	}
+	d.RUnlock()
+
+	for name, pc := range fresh {
+		err = nil
+		old, ok := current[name]
+		switch true {
+		case !ok:
+			if removed, stopping := d.reviveTasks(pc); stopping { // same names: reuse instead of replace
+				sl.L.Info("[master] reload: return %s which is still stopping", name)
+				d.UpdateTasks(removed, pc)
+				if pc.Schedule == nil && pc.MustStart { // disabled on remove
+					for _, task := range d.Members(name) {
+						task.Enable()
+					}
+				}
+				break
+			}
+			sl.L.Info("[master] reload: add %s", name)
+			err = d.AddTasks(pc)
+		case (old.Replicas < 1) != (pc.Replicas < 1): // task became replicas group or back
+			sl.L.Info("[master] reload: replace %s", name)
+			d.RemoveTasks(name)
+			err = d.AddTasks(pc)
+		default:
+			d.UpdateTasks(old, pc)
+		}
+		if err != nil {
+			sl.L.Warning("[master] reload err: %s", err.Error())
+		}
+	}
+	for name := range current {
+		if _, ok := fresh[name]; !ok {
+			sl.L.Info("[master] reload: remove %s", name)
+			d.RemoveTasks(name)
+		}
+	}
+	return nil
+}
+
+// RemoveTasks stop task (or all replicas of group) and delete it after stop; until then
+// config added again by reload returns it
+func (d *Dispatcher) RemoveTasks(name string) {
+	for _, task := range d.Members(name) {
+		task.Lock()
+		task.StRemoved = true
+		task.Unlock()
+		d.RecurciveStop(task)
+	}
+	name = strings.ToUpper(name)
+	d.Lock()
+	if pc, ok := d.Configs[name]; ok {
+		if d.removed == nil {
+			d.removed = map[string]ProcessConfig{}
+		}
+		d.removed[name] = pc
+	}
+	delete(d.Configs, name)
+	d.Unlock()
+}
+
+// reviveTasks return removed task (or replicas of group) which is still stopping back to dispatcher;
+// old is config it was removed with (replicas as many as returned); false if nothing to return
+func (d *Dispatcher) reviveTasks(pc ProcessConfig) (old ProcessConfig, ok bool) {
+	d.Lock()
+	defer d.Unlock()
+	old, ok = d.removed[pc.Name]
+	delete(d.removed, pc.Name)
+	if !ok || (old.Replicas < 1) != (pc.Replicas < 1) {
+		return old, false
+	}
+	revived := 0
+	for _, task := range d.Tasks { // under lock of dispatcher: CleanRemoved can not delete it meanwhile
+		task.Lock()
+		if task.StRemoved && (task.Group == pc.Name || task.Group == "" && task.Name == pc.Name) {
+			task.StRemoved = false
+			revived++
+		}
+		task.Unlock()
+	}
+	if revived == 0 {
+		return old, false
+	}
+	if old.Replicas >= 1 {
+		old.Replicas = revived
+	}
+	d.Configs[pc.Name] = old
+	return old, true
+}
+
+// UpdateTasks apply changed config to existing tasks
+func (d *Dispatcher) UpdateTasks(old, pc ProcessConfig) {
+	if reflect.DeepEqual(old, pc) {
+		return
+	}
+	sl.L.Info("[master] reload: update %s", pc.Name)
+	d.Lock()
+	d.Configs[pc.Name] = pc
+	d.Unlock()
+	if pc.Replicas >= 1 && pc.Replicas != old.Replicas {
+		err := d.Scale(pc.Name, pc.Replicas)
+		if err != nil {
+			sl.L.Warning("[master] reload err: %s", err.Error())
+		}
+	}
+
+	envChanged := !reflect.DeepEqual(old.Env, pc.Env)
+	tagsChanged := !slices.Equal(old.Tags, pc.Tags)              // channels of tags subscribed at start
+	watchdogChanged := old.WatchdogTimeout != pc.WatchdogTimeout // timeout read at start
+	pipeChanged := old.Pipe != pc.Pipe                           // connection to bus chosen at launch
+	for _, task := range d.Members(pc.Name) {
+		task.Lock()
+		task.Required = append([]string{}, pc.Required...)
+		task.Acl = pc.Acl
+		task.Retry = pc.Retry
+		task.Type = pc.Type
+		task.WatchdogTimeout, task.WatchdogDump = pc.WatchdogTimeout, pc.WatchdogDump
+		task.StopSequence = pc.StopSequence
+		task.Pipe = pc.Pipe
+		if !reflect.DeepEqual(old.Limits, pc.Limits) {
+			task.Limits = pc.Limits
+			task.LimitSince = nil
+		}
+		if !reflect.DeepEqual(old.Schedule, pc.Schedule) {
+			task.Schedule = pc.Schedule
+			task.NextRun = time.Time{}
+			if pc.Schedule != nil && pc.Schedule.Periodic() {
+				task.NextRun = pc.Schedule.Next(ciutils.Now())
+			}
+		}
+		if envChanged || tagsChanged || watchdogChanged {
+			task.Env = append(d.TaskEnv(pc, task.Name, task.Replica), credentials(task.Env)...)
+		}
+		running, reloadable := task.StLaunched, task.StReloadable
+		task.Unlock()
+
+		if pc.Schedule == nil && pc.MustStart != old.MustStart {
+			if pc.MustStart {
+				task.Enable()
+			} else {
+				d.RecurciveStop(task)
+			}
+		}
+
+		switch true {
+		case !running || !(envChanged || tagsChanged || watchdogChanged || pipeChanged):
+		case reloadable && !tagsChanged && !watchdogChanged && !pipeChanged:
+			go d.PushConfig(task, pc.Env)
+		default:
+			sl.L.Info("[master] reload: restart %s with new config", task.Name)
+			task.Restart()
+		}
+	}
+}
+
+// PushConfig send new env to task by RELOAD; task restarted if it not applied config
+func (d *Dispatcher) PushConfig(task *Task, env map[string]string) {
+	cfg := make(map[string]string, len(env))
+	for key, val := range env { // as env of process
+		cfg[strings.ToUpper(key)] = strings.ToUpper(val)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DEFAULT_RELOAD_TIMEOUT)
+	defer cancel()
+	reply, err := d.Wpr.Request(ctx, task.Name, wrapper.RELOAD, cfg)
+	if err == nil && reply.Value != wrapper.RELOAD_OK {
+		err = fmt.Errorf("%v", reply.Value)
+	}
+	if err != nil {
+		sl.L.Warning("[master] task %s - reload failed: %s; restart", task.Name, err.Error())
+		task.Restart()
+		return
+	}
+	sl.L.Info("[master] task %s - config reloaded", task.Name)
+}
+
+// credentials return env entries of task which kept on config change
+func credentials(env []string) (kept []string) {
+	for _, entry := range env {
+		if strings.HasPrefix(entry, wrapper.CI_TOKEN+"=") {
+			kept = append(kept, entry)
+		}
+	}
+	return
+}
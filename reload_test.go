@@ -0,0 +1,51 @@
+package dispatcher
+
+import (
+	"testing"
+
+	ftgc "github.com/Averianov/ftgc"
+)
+
+// TestReloadReturnsStopping remove config and add it again before removed replicas are deleted:
+// reload must return them instead of replacing tasks which are still stopping
+func TestReloadReturnsStopping(t *testing.T) {
+	d, _ := testDispatcher(t)
+	ftgc.ToGo["R"] = []byte{}
+	t.Cleanup(func() { delete(ftgc.ToGo, "R"); ConfigLoader = nil })
+	configs := map[string]ProcessConfig{"R": {Name: "r", MustStart: true, Replicas: 2}}
+	ConfigLoader = func() (map[string]ProcessConfig, error) { return configs, nil }
+
+	if err := d.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	first := d.Members("R")
+	if len(first) != 2 {
+		t.Fatal("added", len(first))
+	}
+
+	configs = map[string]ProcessConfig{}
+	d.Reload()
+	if len(d.Members("R")) != 0 || len(d.Tasks) != 2 {
+		t.Fatal("not removed", len(d.Tasks))
+	}
+
+	configs = map[string]ProcessConfig{"R": {Name: "r", MustStart: true, Replicas: 3}}
+	d.Reload()
+	members := d.Members("R")
+	if len(members) != 3 || len(d.Tasks) != 3 {
+		t.Fatal("members", len(members), "tasks", len(d.Tasks))
+	}
+	for i, task := range first {
+		if members[i] != task || task.StRemoved || !task.StMustStart {
+			t.Fatal("stopping replica not returned", task.Name)
+		}
+	}
+	if d.Configs["R"].Replicas != 3 {
+		t.Fatal("config", d.Configs["R"])
+	}
+
+	d.CleanRemoved()
+	if len(d.Tasks) != 3 {
+		t.Fatal("returned replica deleted", len(d.Tasks))
+	}
+}
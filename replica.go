@@ -0,0 +1,129 @@
+package dispatcher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Averianov/cidispatcher/wrapper"
+	sl "github.com/Averianov/cisystemlog"
+	"github.com/Averianov/ciutils"
+)
+
+// ReplicaName return name of replica task: WORKER1#0
+func ReplicaName(group string, index int) string {
+	return fmt.Sprintf("%s%s%d", group, wrapper.REPLICA_SEPARATOR, index)
+}
+
+// Task return task by name
+func (d *Dispatcher) Task(name string) (task *Task, ok bool) {
+	d.RLock()
+	defer d.RUnlock()
+	task, ok = d.Tasks[strings.ToUpper(name)]
+	return
+}
+
+// TaskList return snapshot of tasks for safe iteration
+func (d *Dispatcher) TaskList() (tasks []*Task) {
+	d.RLock()
+	defer d.RUnlock()
+	for _, task := range d.Tasks {
+		tasks = append(tasks, task)
+	}
+	return
+}
+
+// Members return task by name or all replicas of group by name
+func (d *Dispatcher) Members(name string) (tasks []*Task) {
+	name = strings.ToUpper(name)
+	if task, ok := d.Task(name); ok {
+		return []*Task{task}
+	}
+	for _, task := range d.TaskList() {
+		if task.Group == name && !task.StRemoved {
+			tasks = append(tasks, task)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Replica < tasks[j].Replica })
+	return
+}
+
+// Scale change count of replicas in group; removed replicas stopped before delete, new ones
+// take free indexes (not used by replicas which are still stopping)
+func (d *Dispatcher) Scale(group string, replicas int) (err error) {
+	group = strings.ToUpper(group)
+	d.RLock()
+	pc, ok := d.Configs[group]
+	d.RUnlock()
+	if _, single := d.Task(group); !ok || single { // task without replicas keep exact name
+		err = fmt.Errorf("task %s has no replicas", group)
+		return
+	}
+	if replicas < 0 {
+		err = fmt.Errorf("wrong count of replicas %d", replicas)
+		return
+	}
+
+	members := d.Members(group)
+	sl.L.Info("[master] scale %s from %d to %d replicas", group, len(members), replicas)
+	for i, added := 0, len(members); added < replicas; i++ {
+		if _, exists := d.Task(ReplicaName(group, i)); exists { // removed replica still stopping
+			continue
+		}
+		added++
+		task := d.NewTask(pc, i)
+		if pc.MustStart || d.anyMustStart(members) {
+			task.StMustStart = true
+		}
+		d.AddTask(task)
+	}
+	for i := replicas; i < len(members); i++ {
+		members[i].Lock()
+		members[i].StRemoved = true
+		members[i].Unlock()
+		d.RecurciveStop(members[i])
+	}
+
+	pc.Replicas = replicas
+	d.Lock()
+	d.Configs[group] = pc
+	d.Unlock()
+	return
+}
+
+// CleanRemoved delete removed tasks which already stopped
+func (d *Dispatcher) CleanRemoved() {
+	d.Lock()
+	defer d.Unlock()
+	for name, task := range d.Tasks {
+		if task.StRemoved && !task.StLaunched && !task.StInProgress && task.Cmd == nil {
+			sl.L.Info("[master] task %s - removed", name)
+			delete(d.Tasks, name)
+			go d.Unregister(name)
+		}
+	}
+}
+
+func (d *Dispatcher) anyMustStart(tasks []*Task) bool {
+	for _, task := range tasks {
+		if task.StMustStart {
+			return true
+		}
+	}
+	return false
+}
+
+// parseScale got value "WORKER1 3"
+func parseScale(value string) (group string, replicas int, err error) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		err = fmt.Errorf("wrong scale value \"%s\"; want \"NAME COUNT\"", value)
+		return
+	}
+	group = strings.ToUpper(fields[0])
+	replicas = ciutils.StrToInt(fields[1])
+	if fields[1] != ciutils.IntToStr(replicas) {
+		err = fmt.Errorf("wrong count of replicas \"%s\"", fields[1])
+	}
+	return
+}
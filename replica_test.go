@@ -0,0 +1,48 @@
+package dispatcher
+
+import (
+	"testing"
+)
+
+// TestScaleKeepsStopping scale down and up before removed replicas are deleted: new replicas
+// must not replace tasks which are still stopping
+func TestScaleKeepsStopping(t *testing.T) {
+	d, _ := testDispatcher(t)
+	pc := ProcessConfig{Name: "W", Type: TYPE_SERVICE, Replicas: 3}
+	d.Configs[pc.Name] = pc
+	for i := 0; i < pc.Replicas; i++ {
+		d.AddTask(d.NewTask(pc, i))
+	}
+
+	if err := d.Scale("w", 1); err != nil {
+		t.Fatal(err)
+	}
+	stopping := []*Task{d.Tasks[ReplicaName("W", 1)], d.Tasks[ReplicaName("W", 2)]}
+	for _, task := range stopping {
+		if task == nil || !task.StRemoved {
+			t.Fatal("replica not removed")
+		}
+	}
+
+	if err := d.Scale("w", 3); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Members("W")) != 3 || len(d.Tasks) != 5 {
+		t.Fatal("members", len(d.Members("W")), "tasks", len(d.Tasks))
+	}
+	for i, task := range stopping {
+		if d.Tasks[ReplicaName("W", i+1)] != task {
+			t.Fatal("stopping replica replaced", task.Name)
+		}
+	}
+	for _, name := range []string{ReplicaName("W", 3), ReplicaName("W", 4)} {
+		if task, ok := d.Task(name); !ok || task.StRemoved {
+			t.Fatal("not added", name)
+		}
+	}
+
+	d.CleanRemoved()
+	if len(d.Tasks) != 3 || len(d.Members("W")) != 3 {
+		t.Fatal("not cleaned", len(d.Tasks))
+	}
+}
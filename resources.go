@@ -0,0 +1,158 @@
+package dispatcher
+
+import (
+	"fmt"
+	"time"
+
+	sl "github.com/Averianov/cisystemlog"
+)
+
+const (
+	LIMIT_CPU     string = "cpu"         // CPU usage, percent of one core
+	LIMIT_RSS     string = "rss"         // resident memory, bytes
+	LIMIT_THREADS string = "threads"     // count of threads
+	LIMIT_FDS     string = "fds"         // open file descriptors
+	LIMIT_READ    string = "read_bytes"  // read from storage since start, bytes
+	LIMIT_WRITE   string = "write_bytes" // written to storage since start, bytes
+
+	LIMIT_ALERT   string = "alert"   // publish ResourceAlert (default)
+	LIMIT_RESTART string = "restart" // publish ResourceAlert and restart process
+
+	ALERT              string = "ALERT"      // key of message with ResourceAlert
+	ALERT_TOPIC_PREFIX string = "ci.alerts." // topic of alerts of task: ci.alerts.<NAME>
+)
+
+// Limit is soft threshold of resource usage of task process, as RSS above 500MB for 1 minute
+type Limit struct {
+	Metric string        `json:"metric"` // LIMIT_CPU, LIMIT_RSS, LIMIT_THREADS, LIMIT_FDS, LIMIT_READ or LIMIT_WRITE
+	Above  float64       `json:"above"`
+	For    time.Duration `json:"for"`    // threshold exceeded in all samples of this time; 0 - in one sample
+	Action string        `json:"action"` // LIMIT_ALERT (default) or LIMIT_RESTART
+}
+
+// ResourceAlert is published to ALERT_TOPIC_PREFIX+NAME when limit of task exceeded
+type ResourceAlert struct {
+	Task   string        `json:"task"`
+	Pid    int           `json:"pid"`
+	Metric string        `json:"metric"`
+	Value  float64       `json:"value"`
+	Above  float64       `json:"above"`
+	For    time.Duration `json:"for"`
+	Action string        `json:"action"`
+	Time   time.Time     `json:"time"`
+}
+
+// prepare validate limit and set default action
+func (limit *Limit) prepare() error {
+	switch limit.Metric {
+	case LIMIT_CPU, LIMIT_RSS, LIMIT_THREADS, LIMIT_FDS, LIMIT_READ, LIMIT_WRITE:
+	default:
+		return fmt.Errorf("unknown metric %s of limit", limit.Metric)
+	}
+	switch limit.Action {
+	case "":
+		limit.Action = LIMIT_ALERT
+	case LIMIT_ALERT, LIMIT_RESTART:
+	default:
+		return fmt.Errorf("unknown action %s of limit", limit.Action)
+	}
+	return nil
+}
+
+// value of limited metric in usage
+func (limit Limit) value(usage Usage) float64 {
+	switch limit.Metric {
+	case LIMIT_CPU:
+		return usage.CPUPercent
+	case LIMIT_RSS:
+		return float64(usage.RSS)
+	case LIMIT_THREADS:
+		return float64(usage.Threads)
+	case LIMIT_FDS:
+		return float64(usage.FDs)
+	case LIMIT_READ:
+		return float64(usage.ReadBytes)
+	case LIMIT_WRITE:
+		return float64(usage.WriteBytes)
+	}
+	return 0
+}
+
+// SampleUsage read resource usage of running processes of tasks from /proc;
+// CPU percent calculated between this and previous sample
+func (d *Dispatcher) SampleUsage() {
+	now := time.Now()
+	for _, task := range d.TaskList() {
+		task.Lock()
+		pid := 0
+		if task.StLaunched && task.Cmd != nil && task.Cmd.Process != nil {
+			pid = task.Cmd.Process.Pid
+		}
+		prev, prevAt := task.Usage, task.UsageAt
+		task.Unlock()
+		if pid == 0 {
+			continue
+		}
+
+		usage, err := ReadUsage(pid)
+		if err != nil {
+			sl.L.Debug("[master] task %s - read usage err: %s", task.Name, err.Error())
+			continue
+		}
+		if elapsed := now.Sub(prevAt).Seconds(); prev != nil && elapsed > 0 && usage.CPU >= prev.CPU {
+			usage.CPUPercent = (usage.CPU - prev.CPU) / elapsed * 100
+		}
+		task.Lock()
+		task.Usage, task.UsageAt = &usage, now
+		task.Unlock()
+	}
+}
+
+// CheckLimits compare sampled usage with limits of tasks; alert (and restart) when limit
+// exceeded for its time; while exceeded alert repeated after every For
+func (d *Dispatcher) CheckLimits() {
+	now := time.Now()
+	for _, task := range d.TaskList() {
+		var alerts []ResourceAlert
+		task.Lock()
+		if len(task.LimitSince) != len(task.Limits) {
+			task.LimitSince = make([]time.Time, len(task.Limits))
+		}
+		for i, limit := range task.Limits {
+			if task.Usage == nil || !task.StLaunched || task.Cmd == nil || task.Cmd.Process == nil {
+				task.LimitSince[i] = time.Time{}
+				continue
+			}
+			value := limit.value(*task.Usage)
+			if value <= limit.Above {
+				task.LimitSince[i] = time.Time{}
+				continue
+			}
+			if task.LimitSince[i].IsZero() {
+				task.LimitSince[i] = task.UsageAt
+			}
+			if task.UsageAt.Sub(task.LimitSince[i]) < limit.For {
+				continue
+			}
+			task.LimitSince[i] = time.Time{}
+			alerts = append(alerts, ResourceAlert{Task: task.Name, Pid: task.Cmd.Process.Pid, Metric: limit.Metric,
+				Value: value, Above: limit.Above, For: limit.For, Action: limit.Action, Time: now})
+		}
+		task.Unlock()
+
+		restart := false
+		for _, alert := range alerts {
+			sl.L.Alert("[master] task %s - %s %.0f above limit %.0f for %v; %s", task.Name, alert.Metric, alert.Value, alert.Above, alert.For, alert.Action)
+			d.Wpr.Publish(ALERT_TOPIC_PREFIX+task.Name, ALERT, alert)
+			restart = restart || alert.Action == LIMIT_RESTART
+			if alert.Action == LIMIT_RESTART {
+				task.Lock()
+				task.Failure = fmt.Sprintf("%s %.0f above limit %.0f", alert.Metric, alert.Value, alert.Above)
+				task.Unlock()
+			}
+		}
+		if restart {
+			task.Restart()
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package dispatcher
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Averianov/cidispatcher/wrapper"
+)
+
+// testAlerts subscribe wrapper to alerts of tasks; return channel of got alerts
+func testAlerts(t *testing.T, d *Dispatcher, addr string) chan ResourceAlert {
+	alerts := make(chan ResourceAlert, 10)
+	watcher := testWrapper(t, d, addr, "WATCHER")
+	watcher.SetOnMessage(func(msg *wrapper.RedisMessage) {
+		if msg.Key == ALERT {
+			if alert, err := wrapper.Decode[ResourceAlert](msg); err == nil {
+				alerts <- alert
+			}
+		}
+	})
+	if err := watcher.Subscribe(ALERT_TOPIC_PREFIX + "*"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	return alerts
+}
+
+// testLoopTask launch shell loop as running task; busy loop burn one core
+func testLoopTask(t *testing.T, d *Dispatcher, busy bool) *Task {
+	t.Helper()
+	payload, err := os.ReadFile("/bin/sh")
+	if err != nil {
+		t.Skip("no /bin/sh")
+	}
+	task := &Task{Name: "LOOP", Type: TYPE_SERVICE, Wpr: d.Wpr, Replica: -1, StMustStart: true, ElfPayload: payload}
+	d.AddTask(task)
+	script := "while :; do sleep 0.05; done"
+	if busy {
+		script = "while :; do :; done"
+	}
+	if err = task.LaunchInMemory([]string{"-c", script}); err != nil {
+		t.Fatal(err)
+	}
+	task.Started()
+	t.Cleanup(func() {
+		task.Disable()
+		if p := task.process(); p != nil {
+			p.Kill()
+		}
+	})
+	return task
+}
+
+// TestLimitCrossed soft limit alert only when threshold exceeded in all samples of For; alert repeated after next For
+func TestLimitCrossed(t *testing.T) {
+	d, addr := testDispatcher(t)
+	alerts := testAlerts(t, d, addr)
+	task := testLoopTask(t, d, false)
+	task.Limits = []Limit{{Metric: LIMIT_RSS, Above: 100, For: time.Minute, Action: LIMIT_ALERT}}
+
+	start := time.Now()
+	samples := []struct {
+		at    time.Duration
+		rss   int64
+		alert bool
+	}{
+		{0, 50, false},
+		{10 * time.Second, 200, false}, // exceeded since 10s
+		{40 * time.Second, 200, false},
+		{50 * time.Second, 50, false}, // back under threshold
+		{60 * time.Second, 200, false},
+		{110 * time.Second, 200, false},
+		{120 * time.Second, 200, true},  // exceeded for minute since 60s
+		{150 * time.Second, 200, false}, // exceeded again since 150s
+		{180 * time.Second, 200, false},
+		{210 * time.Second, 200, true}, // repeated after minute
+	}
+	for _, s := range samples {
+		task.Lock()
+		task.Usage, task.UsageAt = &Usage{RSS: s.rss}, start.Add(s.at)
+		task.Unlock()
+		d.CheckLimits()
+		select {
+		case alert := <-alerts:
+			if !s.alert {
+				t.Fatalf("alert at %v: %+v", s.at, alert)
+			}
+			if alert.Task != "LOOP" || alert.Metric != LIMIT_RSS || alert.Value != 200 || alert.Above != 100 || alert.Pid != task.process().Pid {
+				t.Fatalf("%+v", alert)
+			}
+		case <-time.After(200 * time.Millisecond):
+			if s.alert {
+				t.Fatalf("no alert at %v", s.at)
+			}
+		}
+	}
+}
+
+// TestLimitSampled CPU of busy process sampled from /proc cross limit; restart action record failure
+func TestLimitSampled(t *testing.T) {
+	d, addr := testDispatcher(t)
+	alerts := testAlerts(t, d, addr)
+	task := testLoopTask(t, d, true)
+	task.Limits = []Limit{{Metric: LIMIT_CPU, Above: 30, Action: LIMIT_RESTART}}
+
+	d.SampleUsage()
+	time.Sleep(300 * time.Millisecond)
+	d.SampleUsage()
+	task.Lock()
+	usage := *task.Usage
+	task.Unlock()
+	if usage.CPUPercent <= 30 {
+		t.Fatalf("busy process use %.0f%% of CPU", usage.CPUPercent)
+	}
+
+	d.CheckLimits()
+	select {
+	case alert := <-alerts:
+		if alert.Metric != LIMIT_CPU || alert.Action != LIMIT_RESTART {
+			t.Fatalf("%+v", alert)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no alert")
+	}
+	task.Lock()
+	defer task.Unlock()
+	if task.Failure == "" {
+		t.Fatal("failure of restart not recorded")
+	}
+}
@@ -0,0 +1,257 @@
+package dispatcher
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	sl "github.com/Averianov/cisystemlog"
+	"github.com/Averianov/ciutils"
+)
+
+const (
+	OVERLAP_FORBID string = "forbid" // skip the run while previous one still works
+	OVERLAP_QUEUE  string = "queue"  // start the run right after previous one finished
+
+	MAX_RUN_HISTORY int = 10
+)
+
+// Schedule describe when one-shot task must be launched by dispatcher
+type Schedule struct {
+	Cron       string        `json:"cron"`        // "min hour dom month dow" or @hourly, @daily, @weekly, @monthly, @yearly
+	Every      time.Duration `json:"every"`       // fixed interval between starts
+	AtStart    bool          `json:"at_start"`    // run once when dispatcher starts
+	MaxRuntime time.Duration `json:"max_runtime"` // kill run after this time; 0 - without limit
+	Overlap    string        `json:"overlap"`     // OVERLAP_FORBID (default) or OVERLAP_QUEUE
+
+	cron *cronExpr
+}
+
+// RunResult keep result of one task run
+type RunResult struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	ExitCode int       `json:"exit_code"`
+	Error    string    `json:"error,omitempty"`
+	Killed   bool      `json:"killed"`            // killed by MaxRuntime
+	Stopped  bool      `json:"stopped,omitempty"` // stopped by stop sequence of master
+}
+
+// Failed run: exit with error or kill not asked by stop of task
+func (run RunResult) Failed() bool {
+	return !run.Stopped && (run.Killed || run.ExitCode != 0)
+}
+
+// Prepare validate schedule and parse cron expression
+func (s *Schedule) Prepare() (err error) {
+	if s.Cron != "" {
+		s.cron, err = parseCron(s.Cron)
+		if err != nil {
+			return
+		}
+	}
+	if s.Cron == "" && s.Every <= 0 && !s.AtStart {
+		err = fmt.Errorf("%s", "empty schedule")
+		return
+	}
+	switch s.Overlap {
+	case "":
+		s.Overlap = OVERLAP_FORBID
+	case OVERLAP_FORBID, OVERLAP_QUEUE:
+	default:
+		err = fmt.Errorf("unknown overlap policy \"%s\"", s.Overlap)
+	}
+	return
+}
+
+// Periodic schedule has next runs after the start run
+func (s *Schedule) Periodic() bool {
+	return s.cron != nil || s.Every > 0
+}
+
+// Next return time of next run after t
+func (s *Schedule) Next(t time.Time) (next time.Time) {
+	switch true {
+	case s.cron != nil:
+		return s.cron.next(t)
+	case s.Every > 0:
+		return t.Add(s.Every)
+	}
+	return
+}
+
+// CheckSchedules launch scheduled tasks and kill runs which exceeded MaxRuntime
+func (d *Dispatcher) CheckSchedules() {
+	now := ciutils.Now()
+	for _, task := range d.TaskList() {
+		if task.Schedule == nil {
+			continue
+		}
+
+		if task.StLaunched || task.StInProgress {
+			if task.Schedule.MaxRuntime > 0 && !task.RunStarted.IsZero() && now.Sub(task.RunStarted) > task.Schedule.MaxRuntime {
+				sl.L.Warning("[master] task %s - exceeded max runtime %v; kill", task.Name, task.Schedule.MaxRuntime)
+				task.Lock()
+				task.RunKilled = true
+				task.Unlock()
+				if task.Cmd != nil && task.Cmd.Process != nil {
+					task.Kill(task.Cmd.Process)
+				}
+			}
+		}
+
+		if task.NextRun.IsZero() || now.Before(task.NextRun) {
+			if task.RunQueued && !task.StLaunched && !task.StInProgress && task.Cmd == nil {
+				sl.L.Info("[master] task %s - launch queued run", task.Name)
+				task.RunQueued = false
+				task.Enable()
+			}
+			continue
+		}
+
+		task.NextRun = task.Schedule.Next(now)
+		if task.StLaunched || task.StInProgress || task.Cmd != nil {
+			switch task.Schedule.Overlap {
+			case OVERLAP_QUEUE:
+				sl.L.Info("[master] task %s - previous run still in work; queue run", task.Name)
+				task.RunQueued = true
+			default:
+				sl.L.Info("[master] task %s - previous run still in work; skip run", task.Name)
+			}
+			continue
+		}
+		sl.L.Info("[master] task %s - scheduled run; next run at %s", task.Name, task.NextRun.Format(time.DateTime))
+		task.Enable()
+	}
+}
+
+// ### Cron expression ##########################################################
+
+type cronExpr struct {
+	minute, hour, dom, month, dow map[int]bool
+	anyDom, anyDow                bool
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+func parseCron(expr string) (c *cronExpr, err error) {
+	if spec, ok := cronDescriptors[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = spec
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		err = fmt.Errorf("cron \"%s\" must have 5 fields", expr)
+		return
+	}
+
+	c = &cronExpr{ // as classic cron, field starting with "*" (also "*/n") is not restriction for day
+		anyDom: strings.HasPrefix(fields[2], "*"),
+		anyDow: strings.HasPrefix(fields[4], "*"),
+	}
+	if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return
+	}
+	if c.dow[7] { // sunday may be 0 or 7
+		c.dow[0] = true
+	}
+	return
+}
+
+// parseCronField support "*", "n", "a-b", lists "a,b" and steps "*/n", "a-b/n", "a/n" (from a to max)
+func parseCronField(field string, min, max int) (values map[int]bool, err error) {
+	values = map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		i := strings.Index(part, "/")
+		if i >= 0 {
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				err = fmt.Errorf("wrong step in cron field \"%s\"", field)
+				return
+			}
+			part = part[:i]
+		}
+
+		from, to := min, max
+		switch true {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			from, err = strconv.Atoi(bounds[0])
+			if err == nil {
+				to, err = strconv.Atoi(bounds[1])
+			}
+		default:
+			from, err = strconv.Atoi(part)
+			to = from
+			if i >= 0 {
+				to = max
+			}
+		}
+		if err != nil || from < min || to > max || from > to {
+			err = fmt.Errorf("wrong cron field \"%s\"", field)
+			return
+		}
+
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+	return
+}
+
+// next return first matching minute after t
+func (c *cronExpr) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !c.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// matchDay as in classic cron: if both dom and dow restricted - match any of them
+func (c *cronExpr) matchDay(t time.Time) bool {
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,87 @@
+package dispatcher
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	for _, c := range []struct {
+		field    string
+		min, max int
+		want     []int
+	}{
+		{"*", 0, 5, []int{0, 1, 2, 3, 4, 5}},
+		{"3", 0, 59, []int{3}},
+		{"1-4", 0, 59, []int{1, 2, 3, 4}},
+		{"1,5,7", 0, 59, []int{1, 5, 7}},
+		{"*/15", 0, 59, []int{0, 15, 30, 45}},
+		{"10-20/5", 0, 59, []int{10, 15, 20}},
+		{"5/20", 0, 59, []int{5, 25, 45}}, // "a/n" is "a-max/n"
+		{"1-2,50/5", 0, 59, []int{1, 2, 50, 55}},
+		{"*/5", 1, 12, []int{1, 6, 11}},
+	} {
+		values, err := parseCronField(c.field, c.min, c.max)
+		if err != nil {
+			t.Errorf("%s: %v", c.field, err)
+			continue
+		}
+		var got []int
+		for v := range values {
+			got = append(got, v)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, c.want) {
+			t.Errorf("%s: got %v want %v", c.field, got, c.want)
+		}
+	}
+
+	for _, field := range []string{"", "60", "-1", "5-1", "*/0", "1/x", "a", "1-", "0-60/5"} {
+		if _, err := parseCronField(field, 0, 59); err == nil {
+			t.Errorf("%q: no error", field)
+		}
+	}
+	if _, err := parseCron("* * * *"); err == nil {
+		t.Error("4 fields: no error")
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	for _, c := range []struct {
+		expr, after, want string
+	}{
+		{"*/15 * * * *", "2026-03-10 10:07", "2026-03-10 10:15"},
+		{"0 * * * *", "2026-03-10 10:00", "2026-03-10 11:00"}, // strictly after
+		{"30 2 * * *", "2026-03-10 03:00", "2026-03-11 02:30"},
+		{"@hourly", "2026-03-10 23:59", "2026-03-11 00:00"},
+		{"0 0 1 * *", "2026-01-31 12:00", "2026-02-01 00:00"},     // month rollover
+		{"0 0 31 * *", "2026-04-01 00:00", "2026-05-31 00:00"},    // April has no 31
+		{"0 0 29 2 *", "2026-03-01 00:00", "2028-02-29 00:00"},    // leap day
+		{"@yearly", "2026-12-31 23:59", "2027-01-01 00:00"},       // year rollover
+		{"0 0 * * 0", "2026-03-10 00:00", "2026-03-15 00:00"},     // Tuesday to Sunday
+		{"0 0 * * 7", "2026-03-10 00:00", "2026-03-15 00:00"},     // Sunday as 7
+		{"0 0 13 * 5", "2026-03-10 00:00", "2026-03-13 00:00"},    // dom or dow: Friday 13th
+		{"0 0 15 * 1", "2026-03-10 00:00", "2026-03-15 00:00"},    // dom or dow: 15th before Monday
+		{"0 0 10 * 1", "2026-03-10 00:00", "2026-03-16 00:00"},    // dom or dow: Monday before April 10
+		{"0 0 */2 * 1", "2026-03-10 00:00", "2026-03-23 00:00"},   // "*/n" dom and dow: odd day on Monday
+		{"0 0 1-7 * */7", "2026-03-10 00:00", "2026-04-05 00:00"}, // first Sunday of month
+		{"0 12 * 2-3 *", "2026-03-31 12:00", "2027-02-01 12:00"},  // next allowed month
+	} {
+		cron, err := parseCron(c.expr)
+		if err != nil {
+			t.Errorf("%s: %v", c.expr, err)
+			continue
+		}
+		if got := cron.next(at(c.after)); !got.Equal(at(c.want)) {
+			t.Errorf("%s after %s: got %s want %s", c.expr, c.after, got.Format("2006-01-02 15:04 Mon"), c.want)
+		}
+	}
+}
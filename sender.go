@@ -1,41 +1,677 @@
 package dispatcher
 
 import (
-	//"fmt"
+	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/Averianov/cidispatcher/wrapper"
-	sl "github.com/Averianov/cisystemlog"
 )
 
+const (
+	EXIT_OK     int = 0
+	EXIT_FAILED int = 1 // command failed or expected state not reached in time
+	EXIT_USAGE  int = 2
+	EXIT_NO_BUS int = 3 // dispatcher not available or not answered in time
+
+	OUTPUT_TABLE string = "table"
+	OUTPUT_JSON  string = "json"
+	OUTPUT_DOT   string = "dot" // graph for Graphviz
+
+	DEFAULT_CLI_TIMEOUT time.Duration = 5 * time.Second
+	CLI_POLL            time.Duration = 500 * time.Millisecond
+)
+
+const cliUsage = `Usage: sender <command> [flags] [args]
+
+Commands:
+  status [NAME]            state of tasks
+  start NAME               start task (or replicas group) with required tasks
+  stop NAME                stop task and tasks which require it
+  restart NAME             restart task
+  reload                   read process configs again
+  shutdown                 stop all tasks and dispatcher
+  send -ch CH -key K -m M  send message; with -wait print answer
+  watch                    print changes of tasks state until interrupted
+  graph                    dependencies of tasks
+  metrics                  state and resources of tasks in Prometheus text format
+  top                      interactive dashboard of tasks and messages
+  logs NAME                last lines of log files of task
+  tail NAME                follow log files of task
+  monitor                  print messages of bus until interrupted; filters -ch -key -from, -record FILE
+  replay FILE              send recorded messages again as sender; filters -ch -key -from, -speed
+  queue OP NAME            job queue: stats, dead, requeue, purge; NAME "*" - all queues
+
+Flags:
+`
+
+// cli keep options of command line
+type cli struct {
+	wpr     *wrapper.Wrapper
+	out     io.Writer
+	output  string
+	timeout time.Duration
+	wait    bool
+	addr    string
+	level   int
+	lines   int
+	logDir  string
+	every   time.Duration
+	channel string
+	key     string
+	message string
+	id      string
+	from    string
+	record  string
+	speed   float64
+	set     map[string]bool // flags given in command line
+}
+
+// ManualSender run CLI with arguments of process; kept for compatibility
 func ManualSender() {
-	ch := flag.String("ch", wrapper.MASTER, "channel")
-	key := flag.String("key", wrapper.STATUS, "key")
-	msg := flag.String("m", wrapper.GETINFO, "message")
-	l := flag.Int("l", 3, "log level")
-	flag.Parse()
+	os.Exit(CLI(os.Args[1:]))
+}
+
+// CLI run command of sender and return exit code; result go to stdout, logs of wrapper to stderr
+func CLI(args []string) (code int) {
+	out := os.Stdout
+	os.Stdout = os.Stderr // logger print to os.Stdout; must not mix with output as "-o json"
+	defer func() { os.Stdout = out }()
+	return runCLI(args, out)
+}
 
-	wpr := wrapper.CreateWrapper(wrapper.SENDER, int32(*l), 0)
+// runCLI run command of sender with result written to out
+func runCLI(args []string, out io.Writer) (code int) {
+	c := &cli{out: out}
+	fs := flag.NewFlagSet("sender", flag.ContinueOnError)
+	fs.StringVar(&c.output, "o", OUTPUT_TABLE, "output: table, json; graph also dot")
+	fs.DurationVar(&c.timeout, "timeout", DEFAULT_CLI_TIMEOUT, "wait answer or state")
+	fs.BoolVar(&c.wait, "wait", false, "wait result: state of task, exit of dispatcher, answer of send")
+	fs.StringVar(&c.addr, "addr", "", "address of bus; by default from env or runtime directory")
+	fs.IntVar(&c.level, "l", 1, "log level")
+	fs.IntVar(&c.lines, "n", 20, "lines of logs")
+	fs.StringVar(&c.logDir, "logdir", wrapper.LOG_DIR, "directory of log files")
+	fs.DurationVar(&c.every, "interval", time.Second, "interval of watch")
+	fs.StringVar(&c.channel, "ch", wrapper.MASTER, "channel of send")
+	fs.StringVar(&c.key, "key", wrapper.STATUS, "key of send")
+	fs.StringVar(&c.message, "m", wrapper.GETINFO, "message of send")
+	fs.StringVar(&c.id, "id", "", "job of queue requeue; empty - all dead letters")
+	fs.StringVar(&c.from, "from", "", "monitor and replay: pattern of sender; -ch and -key given as patterns filter too")
+	fs.StringVar(&c.record, "record", "", "monitor: append messages to file for replay")
+	fs.Float64Var(&c.speed, "speed", 1, "replay: speed of recorded time; 0 - without pauses")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, cliUsage)
+		fs.PrintDefaults()
+	}
 
-	go func() {
-		_, sender, key, value, err := wpr.ReadGroup()
-		if err != nil {
-			sl.L.Warning("[%s]%s", wpr.Name, err.Error())
-			//panic(fmt.Sprintf("[sender] %s", err.Error()))
-			return
+	if len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "-help" { // flags of old sender
+		args = append([]string{"send"}, args...)
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		return EXIT_USAGE
+	}
+	command := args[0]
+	var positional []string
+	for rest := args[1:]; ; rest = rest[1:] { // flags allowed after arguments
+		if err := fs.Parse(rest); err != nil {
+			return EXIT_USAGE
+		}
+		rest = fs.Args()
+		if len(rest) == 0 {
+			break
+		}
+		positional = append(positional, rest[0])
+	}
+	c.set = map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { c.set[f.Name] = true })
+	if c.output != OUTPUT_TABLE && c.output != OUTPUT_JSON && !(c.output == OUTPUT_DOT && command == "graph") {
+		fmt.Fprintf(os.Stderr, "unknown output %s\n", c.output)
+		return EXIT_USAGE
+	}
+
+	name := ""
+	if len(positional) > 0 {
+		name = strings.ToUpper(positional[0])
+	}
+	switch command {
+	case "help", "-h", "-help":
+		fs.Usage()
+		return EXIT_OK
+	case "status", "reload", "shutdown", "send", "watch", "graph", "top", "monitor", "metrics":
+	case "start", "stop", "restart", "logs", "tail", "queue", "replay":
+		if name == "" || (command == "queue" && len(positional) < 2) {
+			fmt.Fprintf(os.Stderr, "command %s require argument\n", command)
+			return EXIT_USAGE
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", command)
+		fs.Usage()
+		return EXIT_USAGE
+	}
+	if command == "logs" || command == "tail" { // local files; bus not required
+		return c.logs(name, command == "tail")
+	}
+
+	if code = c.connect(); code != EXIT_OK {
+		return
+	}
+	defer c.wpr.Close()
+
+	switch command {
+	case "status":
+		return c.status(name)
+	case "start", "stop", "restart":
+		return c.control(strings.ToUpper(command), name)
+	case "reload":
+		_, code = c.command(wrapper.RELOAD, "")
+		return
+	case "shutdown":
+		return c.shutdown()
+	case "send":
+		return c.send()
+	case "watch":
+		return c.watch()
+	case "graph":
+		return c.graph()
+	case "top":
+		return c.top()
+	case "monitor":
+		return c.monitor()
+	case "metrics":
+		var status DispatcherStatus
+		if status, code = c.getStatus(); code == EXIT_OK {
+			status.Metrics(c.out)
+		}
+		return
+	case "replay":
+		return c.replay(positional[0])
+	case "queue":
+		return c.queue(positional[0], positional[1])
+	}
+	return
+}
+
+// connect to bus as sender
+func (c *cli) connect() (code int) {
+	if c.addr != "" {
+		os.Setenv(wrapper.CI_REDIS_ADDR, c.addr)
+	}
+	defer func() {
+		if r := recover(); r != nil { // wrapper panics on wrong config of bus
+			fmt.Fprintf(os.Stderr, "bus: %v\n", r)
+			code = EXIT_NO_BUS
 		}
-		sl.L.Info("\n[response from %s] %s-%v", sender, key, value)
 	}()
+	c.wpr = wrapper.CreateWrapper(wrapper.SENDER, int32(max(c.level, 1)), 0)
 
-	time.Sleep(1 * time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	if err := c.wpr.Transport.Ping(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "bus not available: %s\n", err.Error())
+		return EXIT_NO_BUS
+	}
+	return EXIT_OK
+}
 
-	err := wpr.SendToService(*ch, *key, *msg)
+// request send key with value to master and wait answer
+func (c *cli) request(key string, value any) (reply *wrapper.RedisMessage, code int) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	reply, err := c.wpr.Request(ctx, wrapper.MASTER, key, value)
 	if err != nil {
-		sl.L.Warning("[%s]%s", wpr.Name, err.Error())
-		//panic(fmt.Sprintf("[sender] %s", err.Error()))
+		fmt.Fprintf(os.Stderr, "dispatcher not answered: %s\n", err.Error())
+		return nil, EXIT_NO_BUS
+	}
+	return reply, EXIT_OK
+}
+
+// command send command to master; answer must be OK
+func (c *cli) command(key, value string) (reply *wrapper.RedisMessage, code int) {
+	reply, code = c.request(key, value)
+	if code != EXIT_OK {
 		return
 	}
+	if answer, _ := reply.Value.(string); answer != wrapper.OK {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", strings.ToLower(key), reply.Value)
+		return reply, EXIT_FAILED
+	}
+	return
+}
+
+// getStatus request state of tasks from master
+func (c *cli) getStatus() (status DispatcherStatus, code int) {
+	var reply *wrapper.RedisMessage
+	reply, code = c.request(wrapper.STATUS, wrapper.GETINFO)
+	if code != EXIT_OK {
+		return
+	}
+	status, err := wrapper.Decode[DispatcherStatus](reply)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wrong status: %s\n", err.Error())
+		return status, EXIT_FAILED
+	}
+	return
+}
+
+// members return status of task or replicas of group by name
+func (status DispatcherStatus) members(name string) (tasks []TaskStatus) {
+	for _, task := range status.Tasks {
+		if name == "" || task.Name == name || task.Group == name {
+			tasks = append(tasks, task)
+		}
+	}
+	return
+}
+
+func (c *cli) status(name string) (code int) {
+	var status DispatcherStatus
+	status, code = c.getStatus()
+	if code != EXIT_OK {
+		return
+	}
+	status.Tasks = status.members(name)
+	if name != "" && len(status.Tasks) == 0 {
+		fmt.Fprintf(os.Stderr, "unknown task %s\n", name)
+		return EXIT_FAILED
+	}
+	if c.output == OUTPUT_JSON {
+		return c.json(status)
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATE\tHEALTH\tREADY\tPID\tUPTIME\tRESTARTS\tLAST EXIT\tCPU\tRSS\tREQUIRED\tFAILURE")
+	for _, task := range status.Tasks {
+		pid, uptime, exit, cpu, rss := "-", "-", "-", "-", "-"
+		if task.Pid > 0 {
+			pid = fmt.Sprint(task.Pid)
+			uptime = (time.Duration(task.Uptime) * time.Second).String()
+		}
+		if task.LastExit != nil {
+			exit = fmt.Sprint(task.LastExit.ExitCode)
+		}
+		if task.Usage != nil {
+			cpu = fmt.Sprintf("%.1fs", task.Usage.CPU)
+			rss = fmt.Sprintf("%.1fM", float64(task.Usage.RSS)/(1<<20))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%v\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n", task.Name, task.State, task.Health, task.Ready,
+			pid, uptime, task.Restarts, exit, cpu, rss, strings.Join(task.Required, ","), task.Failure)
+	}
+	tw.Flush()
+	return
+}
 
-	time.Sleep(1 * time.Second)
+// control start, stop or restart task; with -wait check its state
+func (c *cli) control(key, name string) (code int) {
+	var before DispatcherStatus
+	if c.wait && key == wrapper.RESTART {
+		if before, code = c.getStatus(); code != EXIT_OK {
+			return
+		}
+	}
+	if _, code = c.command(key, name); code != EXIT_OK || !c.wait {
+		return
+	}
+
+	pids := map[string]int{}
+	for _, task := range before.members(name) {
+		pids[task.Name] = task.Pid
+	}
+	done := func(task TaskStatus) bool {
+		switch key {
+		case wrapper.STOP:
+			return task.State != STATE_RUNNING && task.State != STATE_STOPPING
+		case wrapper.RESTART:
+			return task.State == STATE_RUNNING && task.Pid != pids[task.Name] || task.State == STATE_COMPLETED
+		}
+		return task.State == STATE_RUNNING || task.State == STATE_COMPLETED
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	for {
+		status, scode := c.getStatus()
+		if scode != EXIT_OK {
+			return scode
+		}
+		tasks := status.members(name)
+		reached := len(tasks) > 0
+		for _, task := range tasks {
+			reached = reached && done(task)
+		}
+		if reached {
+			return EXIT_OK
+		}
+		if time.Now().After(deadline) {
+			fmt.Fprintf(os.Stderr, "%s %s: state not reached in %s\n", strings.ToLower(key), name, c.timeout)
+			return EXIT_FAILED
+		}
+		time.Sleep(CLI_POLL)
+	}
+}
+
+// shutdown stop dispatcher; with -wait check exit of master
+func (c *cli) shutdown() (code int) {
+	if _, code = c.command(wrapper.STATUS, wrapper.EXIT); code != EXIT_OK || !c.wait {
+		return
+	}
+	deadline := time.Now().Add(c.timeout)
+	for time.Now().Before(deadline) {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		subs, err := c.wpr.Transport.NumSub(ctx, wrapper.MASTER)
+		cancel()
+		if err != nil || subs[wrapper.MASTER] == 0 { // bus stopped with dispatcher
+			return EXIT_OK
+		}
+		time.Sleep(CLI_POLL)
+	}
+	fmt.Fprintf(os.Stderr, "shutdown: dispatcher still works after %s\n", c.timeout)
+	return EXIT_FAILED
+}
+
+// send message to channel; with -wait print answer
+func (c *cli) send() (code int) {
+	if !c.wait {
+		if err := c.wpr.SendToService(c.channel, strings.ToUpper(c.key), c.message); err != nil {
+			fmt.Fprintf(os.Stderr, "send: %s\n", err.Error())
+			return EXIT_NO_BUS
+		}
+		return EXIT_OK
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	reply, err := c.wpr.Request(ctx, c.channel, strings.ToUpper(c.key), c.message)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s not answered: %s\n", c.channel, err.Error())
+		return EXIT_NO_BUS
+	}
+	value, _ := wrapper.Decode[any](reply)
+	if c.output == OUTPUT_JSON {
+		return c.json(map[string]any{"sender": reply.Sender, "key": reply.Key, "value": value})
+	}
+	fmt.Fprintf(c.out, "%s\t%s\t%v\n", reply.Sender, reply.Key, value)
+	return
+}
+
+// watch print changes of tasks state until SIGINT / SIGTERM
+func (c *cli) watch() (code int) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	last := map[string]TaskStatus{}
+	for {
+		status, scode := c.getStatus()
+		if scode != EXIT_OK {
+			return scode
+		}
+		now := time.Now().Format(time.TimeOnly)
+		seen := map[string]bool{}
+		for _, task := range status.Tasks {
+			seen[task.Name] = true
+			prev, ok := last[task.Name]
+			if ok && prev.State == task.State && prev.Pid == task.Pid && prev.Ready == task.Ready && prev.Failure == task.Failure {
+				continue
+			}
+			last[task.Name] = task
+			if c.output == OUTPUT_JSON {
+				raw, _ := json.Marshal(task)
+				fmt.Fprintln(c.out, string(raw))
+				continue
+			}
+			from := "-"
+			if ok {
+				from = prev.State
+			}
+			fmt.Fprintf(c.out, "%s  %-16s %s -> %s  pid %d  ready %v  %s\n", now, task.Name, from, task.State, task.Pid, task.Ready, task.Failure)
+		}
+		for name := range last {
+			if !seen[name] {
+				delete(last, name)
+				fmt.Fprintf(c.out, "%s  %-16s removed\n", now, name)
+			}
+		}
+
+		select {
+		case <-sig:
+			return EXIT_OK
+		case <-time.After(c.every):
+		}
+	}
+}
+
+// graph print dependencies of tasks: tree from independent tasks, JSON or DOT
+func (c *cli) graph() (code int) {
+	var status DispatcherStatus
+	status, code = c.getStatus()
+	if code != EXIT_OK {
+		return
+	}
+	switch c.output {
+	case OUTPUT_JSON:
+		deps := map[string][]string{}
+		for _, task := range status.Tasks {
+			deps[task.Name] = task.Required
+		}
+		return c.json(deps)
+	case OUTPUT_DOT:
+		fmt.Fprintln(c.out, "digraph tasks {")
+		for _, task := range status.Tasks {
+			fmt.Fprintf(c.out, "  %q [label=\"%s\\n%s\"];\n", task.Name, task.Name, task.State)
+			for _, req := range task.Required {
+				fmt.Fprintf(c.out, "  %q -> %q;\n", task.Name, req)
+			}
+		}
+		fmt.Fprintln(c.out, "}")
+		return
+	}
+
+	status.tree(c.out)
+	return
+}
+
+// tree print dependencies from independent tasks to tasks which require them
+func (status DispatcherStatus) tree(w io.Writer) {
+	dependants := func(parent TaskStatus) (tasks []TaskStatus) {
+		for _, task := range status.Tasks {
+			if slices.Contains(task.Required, parent.Name) || (parent.Group != "" && slices.Contains(task.Required, parent.Group)) {
+				tasks = append(tasks, task)
+			}
+		}
+		return
+	}
+	var show func(task TaskStatus, prefix string, path []string)
+	show = func(task TaskStatus, prefix string, path []string) {
+		if slices.Contains(path, task.Name) {
+			fmt.Fprintf(w, "%s (cycle)\n", prefix+task.Name)
+			return
+		}
+		fmt.Fprintf(w, "%s%s [%s]\n", prefix, task.Name, task.State)
+		for _, child := range dependants(task) {
+			show(child, strings.Repeat("  ", len(path)+1)+"<- ", append(path, task.Name))
+		}
+	}
+	for _, task := range status.Tasks {
+		if len(task.Required) == 0 {
+			show(task, "", nil)
+		}
+	}
+}
+
+// logs print last lines of log files of task (or replicas); tail follow them until interrupted
+func (c *cli) logs(name string, follow bool) (code int) {
+	files, _ := filepath.Glob(filepath.Join(c.logDir, name+".log"))
+	replicas, _ := filepath.Glob(filepath.Join(c.logDir, name+wrapper.REPLICA_SEPARATOR+"*.log"))
+	files = append(files, replicas...)
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "no log files of %s in %s\n", name, c.logDir)
+		return EXIT_FAILED
+	}
+
+	prefix := func(file string) string {
+		if len(files) == 1 {
+			return ""
+		}
+		return strings.TrimSuffix(filepath.Base(file), ".log") + " | "
+	}
+	offsets := map[string]int64{}
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return EXIT_FAILED
+		}
+		offsets[file] = int64(len(raw))
+		lines := strings.Split(strings.TrimRight(string(raw), "\n"), "\n")
+		if len(lines) > c.lines {
+			lines = lines[len(lines)-c.lines:]
+		}
+		for _, line := range lines {
+			fmt.Fprintln(c.out, prefix(file)+line)
+		}
+	}
+	if !follow {
+		return
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	for {
+		select {
+		case <-sig:
+			return EXIT_OK
+		case <-time.After(CLI_POLL):
+		}
+		for _, file := range files {
+			offset, err := appended(file, offsets[file], c.out, prefix(file))
+			if err == nil {
+				offsets[file] = offset
+			}
+		}
+	}
+}
+
+// appended print lines added to file after offset; file rotated when it became shorter
+func appended(file string, offset int64, out io.Writer, prefix string) (int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return offset, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return offset, err
+	}
+	if info.Size() < offset {
+		offset = 0
+	}
+	if info.Size() == offset {
+		return offset, nil
+	}
+	raw := make([]byte, info.Size()-offset)
+	n, err := f.ReadAt(raw, offset)
+	if err != nil && err != io.EOF {
+		return offset, err
+	}
+	raw = raw[:n]
+	if i := strings.LastIndex(string(raw), "\n"); i >= 0 { // only whole lines
+		raw = raw[:i+1]
+	} else {
+		return offset, nil
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(raw), "\n"), "\n") {
+		fmt.Fprintln(out, prefix+line)
+	}
+	return offset + int64(len(raw)), nil
+}
+
+// queue inspect queue and manage its dead letters
+func (c *cli) queue(op, queue string) (code int) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	queues := []string{queue}
+	if queue == "*" {
+		var err error
+		if queues, err = c.wpr.Queues(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "queues: %s\n", err.Error())
+			return EXIT_NO_BUS
+		}
+	}
+
+	var result any
+	var err error
+	switch op {
+	case "stats":
+		all := []wrapper.QueueStats{}
+		for _, q := range queues {
+			var stats wrapper.QueueStats
+			if stats, err = c.wpr.QueueStats(ctx, q); err != nil {
+				break
+			}
+			all = append(all, stats)
+		}
+		if err == nil && c.output == OUTPUT_TABLE {
+			tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "QUEUE\tREADY\tDELAYED\tIN FLIGHT\tDEAD")
+			for _, st := range all {
+				fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n", st.Queue, st.Ready, st.Delayed, st.InFlight, st.Dead)
+			}
+			tw.Flush()
+			return
+		}
+		result = all
+	case "dead":
+		all := map[string][]wrapper.Job{}
+		for _, q := range queues {
+			if all[q], err = c.wpr.DeadJobs(ctx, q, 0); err != nil {
+				break
+			}
+		}
+		result = all
+	case "requeue":
+		all := map[string]int64{}
+		for _, q := range queues {
+			if all[q], err = c.wpr.Requeue(ctx, q, c.id); err != nil {
+				break
+			}
+		}
+		result = all
+	case "purge":
+		all := map[string]int64{}
+		for _, q := range queues {
+			if all[q], err = c.wpr.PurgeDead(ctx, q); err != nil {
+				break
+			}
+		}
+		result = all
+	default:
+		fmt.Fprintf(os.Stderr, "unknown operation %s on queue\n", op)
+		return EXIT_USAGE
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "queue %s: %s\n", op, err.Error())
+		return EXIT_NO_BUS
+	}
+	return c.json(result)
+}
+
+func (c *cli) json(value any) (code int) {
+	raw, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return EXIT_FAILED
+	}
+	fmt.Fprintln(c.out, string(raw))
+	return EXIT_OK
 }
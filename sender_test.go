@@ -0,0 +1,110 @@
+package dispatcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Averianov/cidispatcher/wrapper"
+)
+
+// TestCLIArgs commands and flags checked before connection to bus; unavailable bus has own exit code
+func TestCLIArgs(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv(wrapper.CI_RUNTIME_DIR, dir)
+	t.Setenv(wrapper.CI_REDIS_ADDR, "")
+	noBus := []string{"-addr", "127.0.0.1:1", "-timeout", "200ms"}
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{nil, EXIT_USAGE},
+		{[]string{"help"}, EXIT_OK},
+		{[]string{"unknown"}, EXIT_USAGE},
+		{[]string{"start"}, EXIT_USAGE},
+		{[]string{"queue", "stats"}, EXIT_USAGE},
+		{[]string{"status", "-o", "xml"}, EXIT_USAGE},
+		{[]string{"status", "-o", "dot"}, EXIT_USAGE}, // dot only for graph
+		{[]string{"status", "-unknown"}, EXIT_USAGE},
+		{[]string{"status", "-timeout", "never"}, EXIT_USAGE},
+		{append([]string{"status"}, noBus...), EXIT_NO_BUS},
+		{append([]string{"graph", "-o", "dot"}, noBus...), EXIT_NO_BUS},
+		{append([]string{"-ch", "worker", "-key", "K"}, noBus...), EXIT_NO_BUS}, // flags of old sender mean send
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if code := runCLI(tt.args, &out); code != tt.code {
+			t.Errorf("%v: code %d, want %d", tt.args, code, tt.code)
+		}
+		if out.Len() > 0 {
+			t.Errorf("%v: output %q", tt.args, out.String())
+		}
+	}
+}
+
+// TestCLILogs last lines of log files of task and its replicas; bus not required
+func TestCLILogs(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "WORKER.log"), []byte("a\nb\nc\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "WORKER#0.log"), []byte("d\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "WORKERS.log"), []byte("other\n"), 0644)
+
+	var out bytes.Buffer
+	if code := runCLI([]string{"logs", "worker", "-n", "2", "-logdir", dir}, &out); code != EXIT_OK {
+		t.Fatal("code", code)
+	}
+	if got := out.String(); got != "WORKER | b\nWORKER | c\nWORKER#0 | d\n" {
+		t.Fatalf("%q", got)
+	}
+	out.Reset()
+	if code := runCLI([]string{"logs", "worker#0", "-logdir", dir}, &out); code != EXIT_OK || out.String() != "d\n" {
+		t.Fatalf("code %d, %q", code, out.String())
+	}
+	if code := runCLI([]string{"logs", "nobody", "-logdir", dir}, &out); code != EXIT_FAILED {
+		t.Fatal("code", code)
+	}
+}
+
+// TestCLIStatus commands answered by master: table, json, metrics and errors of unknown task
+func TestCLIStatus(t *testing.T) {
+	d, addr := testDispatcher(t)
+	t.Setenv(wrapper.CI_REDIS_ADDR, addr)
+	d.Wpr.SetOnMessage(d.OnMessage)
+	d.AddTask(&Task{Name: "WORKER", Type: TYPE_SERVICE, Replica: -1, Wpr: d.Wpr})
+
+	var out bytes.Buffer
+	if code := runCLI([]string{"status"}, &out); code != EXIT_OK {
+		t.Fatal("code", code)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "NAME") || !strings.HasPrefix(lines[1], "WORKER ") {
+		t.Fatalf("%q", out.String())
+	}
+
+	out.Reset()
+	if code := runCLI([]string{"status", "worker", "-o", "json"}, &out); code != EXIT_OK {
+		t.Fatal("code", code)
+	}
+	var status DispatcherStatus
+	if err := json.Unmarshal(out.Bytes(), &status); err != nil { // only json in output
+		t.Fatal(err, out.String())
+	}
+	if len(status.Tasks) != 1 || status.Tasks[0].Name != "WORKER" {
+		t.Fatalf("%+v", status)
+	}
+
+	out.Reset()
+	if code := runCLI([]string{"metrics"}, &out); code != EXIT_OK || !strings.Contains(out.String(), `task="WORKER"`) {
+		t.Fatalf("code %d, %q", code, out.String())
+	}
+	if code := runCLI([]string{"status", "nobody"}, &out); code != EXIT_FAILED {
+		t.Fatal("status of unknown task", code)
+	}
+	if code := runCLI([]string{"start", "nobody"}, &out); code != EXIT_FAILED {
+		t.Fatal("start of unknown task", code)
+	}
+}
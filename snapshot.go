@@ -0,0 +1,135 @@
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Averianov/cidispatcher/wrapper"
+	sl "github.com/Averianov/cisystemlog"
+)
+
+const DEFAULT_SNAPSHOT_INTERVAL time.Duration = time.Minute
+
+// KVSnapshot is file where key-value store saved on shutdown and loaded on start; empty - store not persisted
+var KVSnapshot string
+
+// KVSnapshotInterval is period of saving of store while master works: after crash or SIGKILL of master
+// only changes of last period are lost; 0 - DEFAULT_SNAPSHOT_INTERVAL, negative - save only on shutdown
+var KVSnapshotInterval time.Duration
+
+var saving sync.Mutex // one save at time: periodic and on shutdown write same file
+
+// snapshotEntry keep value of store key; ExpiresAt in unix milliseconds, 0 - without expiration
+type snapshotEntry struct {
+	Value     string `json:"value"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+}
+
+// SaveSnapshot write key-value store to KVSnapshot
+func (d *Dispatcher) SaveSnapshot() (err error) {
+	if KVSnapshot == "" || d.Wpr == nil || d.Wpr.RClient == nil {
+		return
+	}
+	saving.Lock()
+	defer saving.Unlock()
+	ctx := context.Background()
+	client := d.Wpr.RClient
+	entries := map[string]snapshotEntry{}
+	iter := client.Scan(ctx, 0, wrapper.KV_PREFIX+"*", 0).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		value, gerr := client.Get(ctx, key).Result()
+		if gerr != nil { // expired or not string
+			continue
+		}
+		entry := snapshotEntry{Value: value}
+		if ttl, terr := client.PTTL(ctx, key).Result(); terr == nil && ttl > 0 {
+			entry.ExpiresAt = time.Now().Add(ttl).UnixMilli()
+		}
+		entries[key] = entry
+	}
+	err = iter.Err()
+	if err != nil {
+		return
+	}
+
+	var raw []byte
+	raw, err = json.Marshal(entries)
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(KVSnapshot), 0700)
+	if err != nil {
+		return
+	}
+	tmp := KVSnapshot + ".tmp"
+	err = os.WriteFile(tmp, raw, 0600)
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmp, KVSnapshot)
+	if err == nil {
+		sl.L.Info("[master] store saved: %d keys", len(entries))
+	}
+	return
+}
+
+// Snapshots save key-value store every KVSnapshotInterval
+func (d *Dispatcher) Snapshots() {
+	interval := KVSnapshotInterval
+	if interval == 0 {
+		interval = DEFAULT_SNAPSHOT_INTERVAL
+	}
+	if KVSnapshot == "" || interval < 0 {
+		return
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for range tick.C {
+		if err := d.SaveSnapshot(); err != nil {
+			sl.L.Warning("[master] save store err: %s", err.Error())
+		}
+	}
+}
+
+// LoadSnapshot restore key-value store from KVSnapshot; expired keys skipped
+func (d *Dispatcher) LoadSnapshot() (err error) {
+	if KVSnapshot == "" || d.Wpr == nil || d.Wpr.RClient == nil {
+		return
+	}
+	var raw []byte
+	raw, err = os.ReadFile(KVSnapshot)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	entries := map[string]snapshotEntry{}
+	err = json.Unmarshal(raw, &entries)
+	if err != nil {
+		return
+	}
+
+	ctx := context.Background()
+	now := time.Now().UnixMilli()
+	for key, entry := range entries {
+		var ttl time.Duration
+		if entry.ExpiresAt > 0 {
+			if entry.ExpiresAt <= now {
+				continue
+			}
+			ttl = time.Duration(entry.ExpiresAt-now) * time.Millisecond
+		}
+		err = d.Wpr.RClient.Set(ctx, key, entry.Value, ttl).Err()
+		if err != nil {
+			return
+		}
+	}
+	sl.L.Info("[master] store loaded: %d keys", len(entries))
+	return
+}
@@ -0,0 +1,228 @@
+package dispatcher
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Averianov/cidispatcher/wrapper"
+)
+
+const (
+	STATE_RUNNING   string = "running"
+	STATE_STARTING  string = "starting"
+	STATE_STOPPING  string = "stopping"
+	STATE_STOPPED   string = "stopped"
+	STATE_COMPLETED string = "completed" // oneshot task finished own job
+	STATE_FAILED    string = "failed"    // oneshot task failed all attempts
+
+	HEALTH_HEALTHY   string = "healthy"
+	HEALTH_DEGRADED  string = "degraded"  // running without satisfied required tasks
+	HEALTH_UNHEALTHY string = "unhealthy" // must work but not running or failed
+)
+
+// TaskStatus is state of task for tools
+type TaskStatus struct {
+	Name        string     `json:"name"`
+	Group       string     `json:"group,omitempty"`
+	Type        string     `json:"type"`
+	State       string     `json:"state"`
+	Health      string     `json:"health,omitempty"` // empty for task which must not work
+	MustStart   bool       `json:"must_start"`
+	Ready       bool       `json:"ready"` // reported READY by wrapper.Run
+	Pid         int        `json:"pid,omitempty"`
+	Uptime      float64    `json:"uptime,omitempty"` // seconds of current run
+	Restarts    int        `json:"restarts"`         // launches after first one
+	LastExit    *RunResult `json:"last_exit,omitempty"`
+	Required    []string   `json:"required"`
+	Dependants  []string   `json:"dependants,omitempty"` // tasks which require this task
+	Failure     string     `json:"failure,omitempty"`
+	Usage       *Usage     `json:"usage,omitempty"` // resources of running process
+	PayloadHash string     `json:"payload_hash,omitempty"`
+	LastPing    *time.Time `json:"last_ping,omitempty"` // last ping of watchdog
+	Orphans     []int      `json:"orphans,omitempty"`   // descendants reparented to master
+}
+
+// DispatcherStatus is state of dispatcher and all its tasks
+type DispatcherStatus struct {
+	Time   time.Time    `json:"time"`
+	Pid    int          `json:"pid"`
+	Uptime float64      `json:"uptime"` // seconds
+	Tasks  []TaskStatus `json:"tasks"`
+}
+
+// Status return state of task without relations to other tasks
+func (task *Task) Status() (st TaskStatus) {
+	task.Lock()
+	defer task.Unlock()
+	st = TaskStatus{
+		Name:        task.Name,
+		Group:       task.Group,
+		Type:        task.Type,
+		MustStart:   task.StMustStart,
+		Ready:       task.StReady,
+		Required:    append([]string{}, task.Required...),
+		Failure:     task.Failure,
+		PayloadHash: task.PayloadHash,
+	}
+	if len(task.Orphans) > 0 {
+		st.Orphans = append([]int{}, task.Orphans...)
+	}
+	if !task.LastPing.IsZero() {
+		ping := task.LastPing
+		st.LastPing = &ping
+	}
+	if task.Usage != nil {
+		usage := *task.Usage
+		st.Usage = &usage
+	}
+	if task.Launches > 1 {
+		st.Restarts = task.Launches - 1
+	}
+	if len(task.Runs) > 0 {
+		last := task.Runs[len(task.Runs)-1]
+		st.LastExit = &last
+	}
+	if task.Cmd != nil && task.Cmd.Process != nil {
+		st.Pid = task.Cmd.Process.Pid
+		if !task.RunStarted.IsZero() {
+			st.Uptime = time.Since(task.RunStarted).Round(time.Second).Seconds()
+		}
+	}
+
+	switch true {
+	case task.StCompleted && !task.StLaunched:
+		st.State = STATE_COMPLETED
+	case task.StFailed && !task.StLaunched:
+		st.State = STATE_FAILED
+	case task.StLaunched && task.StInProgress && !task.StMustStart:
+		st.State = STATE_STOPPING
+	case task.StLaunched:
+		st.State = STATE_RUNNING
+	case task.StInProgress && task.StMustStart:
+		st.State = STATE_STARTING
+	case task.StInProgress:
+		st.State = STATE_STOPPING
+	default:
+		st.State = STATE_STOPPED
+	}
+	return
+}
+
+// Status return state of dispatcher and all tasks sorted by name; sender is not included
+func (d *Dispatcher) Status() (status DispatcherStatus) {
+	status = DispatcherStatus{Time: time.Now(), Pid: os.Getpid(), Tasks: []TaskStatus{}}
+	if !d.Started.IsZero() {
+		status.Uptime = time.Since(d.Started).Round(time.Second).Seconds()
+	}
+
+	tasks := d.TaskList()
+	for _, task := range tasks {
+		if task.Name == wrapper.SENDER {
+			continue
+		}
+		st := task.Status()
+		for _, other := range tasks {
+			if slices.Contains(other.Required, task.Name) || (task.Group != "" && slices.Contains(other.Required, task.Group)) {
+				st.Dependants = append(st.Dependants, other.Name)
+			}
+		}
+		sort.Strings(st.Dependants)
+		if st.Usage == nil && st.Pid > 0 && st.State == STATE_RUNNING { // not sampled yet
+			if usage, err := ReadUsage(st.Pid); err == nil {
+				st.Usage = &usage
+			}
+		}
+		st.Health = d.health(task, st)
+		status.Tasks = append(status.Tasks, st)
+	}
+	sort.Slice(status.Tasks, func(i, j int) bool { return status.Tasks[i].Name < status.Tasks[j].Name })
+	return
+}
+
+// health of task by its state and required tasks
+func (d *Dispatcher) health(task *Task, st TaskStatus) string {
+	switch true {
+	case st.State == STATE_FAILED:
+		return HEALTH_UNHEALTHY
+	case st.State == STATE_RUNNING && !d.satisfied(task):
+		return HEALTH_DEGRADED
+	case st.State == STATE_RUNNING, st.State == STATE_COMPLETED:
+		return HEALTH_HEALTHY
+	case st.MustStart:
+		return HEALTH_UNHEALTHY
+	}
+	return ""
+}
+
+// String format status as table for logs
+func (status DispatcherStatus) String() string {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATE\tHEALTH\tMUST\tPID\tUPTIME\tRESTARTS")
+	for _, task := range status.Tasks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%v\t%d\t%s\t%d\n", task.Name, task.State, task.Health, task.MustStart,
+			task.Pid, time.Duration(task.Uptime)*time.Second, task.Restarts)
+	}
+	tw.Flush()
+	return b.String()
+}
+
+// Metrics write status in Prometheus text format, as for textfile collector of node exporter
+func (status DispatcherStatus) Metrics(w io.Writer) {
+	fmt.Fprintf(w, "# TYPE cidispatcher_uptime_seconds gauge\ncidispatcher_uptime_seconds %g\n", status.Uptime)
+	metrics := []struct {
+		name, kind string
+		value      func(task TaskStatus) (float64, bool)
+	}{
+		{"cidispatcher_task_up", "gauge", func(task TaskStatus) (float64, bool) {
+			if task.State == STATE_RUNNING {
+				return 1, true
+			}
+			return 0, true
+		}},
+		{"cidispatcher_task_restarts_total", "counter", func(task TaskStatus) (float64, bool) { return float64(task.Restarts), true }},
+		{"cidispatcher_task_cpu_seconds_total", "counter", func(task TaskStatus) (float64, bool) {
+			return usageValue(task, func(u *Usage) float64 { return u.CPU })
+		}},
+		{"cidispatcher_task_cpu_percent", "gauge", func(task TaskStatus) (float64, bool) {
+			return usageValue(task, func(u *Usage) float64 { return u.CPUPercent })
+		}},
+		{"cidispatcher_task_rss_bytes", "gauge", func(task TaskStatus) (float64, bool) {
+			return usageValue(task, func(u *Usage) float64 { return float64(u.RSS) })
+		}},
+		{"cidispatcher_task_threads", "gauge", func(task TaskStatus) (float64, bool) {
+			return usageValue(task, func(u *Usage) float64 { return float64(u.Threads) })
+		}},
+		{"cidispatcher_task_fds", "gauge", func(task TaskStatus) (float64, bool) {
+			return usageValue(task, func(u *Usage) float64 { return float64(u.FDs) })
+		}},
+		{"cidispatcher_task_read_bytes_total", "counter", func(task TaskStatus) (float64, bool) {
+			return usageValue(task, func(u *Usage) float64 { return float64(u.ReadBytes) })
+		}},
+		{"cidispatcher_task_write_bytes_total", "counter", func(task TaskStatus) (float64, bool) {
+			return usageValue(task, func(u *Usage) float64 { return float64(u.WriteBytes) })
+		}},
+	}
+	for _, metric := range metrics {
+		fmt.Fprintf(w, "# TYPE %s %s\n", metric.name, metric.kind)
+		for _, task := range status.Tasks {
+			if value, ok := metric.value(task); ok {
+				fmt.Fprintf(w, "%s{task=%q} %g\n", metric.name, task.Name, value)
+			}
+		}
+	}
+}
+
+// usageValue return value of usage for running task
+func usageValue(task TaskStatus, fn func(u *Usage) float64) (float64, bool) {
+	if task.Usage == nil {
+		return 0, false
+	}
+	return fn(task.Usage), true
+}
@@ -0,0 +1,108 @@
+package dispatcher
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestTaskState state of task by its flags
+func TestTaskState(t *testing.T) {
+	tests := []struct {
+		name string
+		task *Task
+		want string
+	}{
+		{"stopped", &Task{}, STATE_STOPPED},
+		{"starting", &Task{StMustStart: true, StInProgress: true}, STATE_STARTING},
+		{"running", &Task{StMustStart: true, StLaunched: true}, STATE_RUNNING},
+		{"running not required", &Task{StLaunched: true}, STATE_RUNNING},
+		{"stopping launched", &Task{StLaunched: true, StInProgress: true}, STATE_STOPPING},
+		{"stopping", &Task{StInProgress: true}, STATE_STOPPING},
+		{"completed", &Task{Type: TYPE_ONESHOT, StMustStart: true, StCompleted: true}, STATE_COMPLETED},
+		{"completed relaunched", &Task{Type: TYPE_ONESHOT, StMustStart: true, StCompleted: true, StLaunched: true}, STATE_RUNNING},
+		{"failed", &Task{Type: TYPE_ONESHOT, StMustStart: true, StFailed: true}, STATE_FAILED},
+		{"failed retried", &Task{Type: TYPE_ONESHOT, StMustStart: true, StFailed: true, StLaunched: true}, STATE_RUNNING},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.task.Status().State; got != tt.want {
+				t.Errorf("state %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHealth health of task by state and state of required tasks, also required by group of replicas
+func TestHealth(t *testing.T) {
+	d := &Dispatcher{Tasks: map[string]*Task{}}
+	for _, task := range []*Task{
+		{Name: "DB", StMustStart: true, StLaunched: true},
+		{Name: "CACHE", StMustStart: true},
+		{Name: "WORKER#0", Group: "WORKER", Replica: 0, StMustStart: true},
+		{Name: "WORKER#1", Group: "WORKER", Replica: 1, StMustStart: true, StLaunched: true},
+		{Name: "MIGRATE", Type: TYPE_ONESHOT, StMustStart: true, StCompleted: true},
+	} {
+		d.AddTask(task)
+	}
+
+	tests := []struct {
+		name string
+		task *Task
+		want string
+	}{
+		{"running", &Task{StMustStart: true, StLaunched: true, Required: []string{"DB", "MIGRATE"}}, HEALTH_HEALTHY},
+		{"required by group", &Task{StMustStart: true, StLaunched: true, Required: []string{"WORKER"}}, HEALTH_HEALTHY},
+		{"required not running", &Task{StMustStart: true, StLaunched: true, Required: []string{"DB", "CACHE"}}, HEALTH_DEGRADED},
+		{"required missing", &Task{StMustStart: true, StLaunched: true, Required: []string{"QUEUE"}}, HEALTH_DEGRADED},
+		{"completed", &Task{Type: TYPE_ONESHOT, StMustStart: true, StCompleted: true}, HEALTH_HEALTHY},
+		{"failed", &Task{Type: TYPE_ONESHOT, StMustStart: true, StFailed: true}, HEALTH_UNHEALTHY},
+		{"starting", &Task{StMustStart: true, StInProgress: true}, HEALTH_UNHEALTHY},
+		{"must start", &Task{StMustStart: true}, HEALTH_UNHEALTHY},
+		{"stopping", &Task{StLaunched: true, StInProgress: true}, ""},
+		{"stopped", &Task{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.health(tt.task, tt.task.Status()); got != tt.want {
+				t.Errorf("health %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMetrics Prometheus text of status; usage only for sampled tasks
+func TestMetrics(t *testing.T) {
+	status := DispatcherStatus{Uptime: 3600, Tasks: []TaskStatus{
+		{Name: "API", State: STATE_RUNNING, Restarts: 2, Usage: &Usage{CPU: 12.5, CPUPercent: 3.25, RSS: 52428800,
+			Threads: 8, FDs: 16, ReadBytes: 4096, WriteBytes: 1e9}},
+		{Name: "WORKER#0", State: STATE_STOPPED},
+	}}
+	const golden = `# TYPE cidispatcher_uptime_seconds gauge
+cidispatcher_uptime_seconds 3600
+# TYPE cidispatcher_task_up gauge
+cidispatcher_task_up{task="API"} 1
+cidispatcher_task_up{task="WORKER#0"} 0
+# TYPE cidispatcher_task_restarts_total counter
+cidispatcher_task_restarts_total{task="API"} 2
+cidispatcher_task_restarts_total{task="WORKER#0"} 0
+# TYPE cidispatcher_task_cpu_seconds_total counter
+cidispatcher_task_cpu_seconds_total{task="API"} 12.5
+# TYPE cidispatcher_task_cpu_percent gauge
+cidispatcher_task_cpu_percent{task="API"} 3.25
+# TYPE cidispatcher_task_rss_bytes gauge
+cidispatcher_task_rss_bytes{task="API"} 5.24288e+07
+# TYPE cidispatcher_task_threads gauge
+cidispatcher_task_threads{task="API"} 8
+# TYPE cidispatcher_task_fds gauge
+cidispatcher_task_fds{task="API"} 16
+# TYPE cidispatcher_task_read_bytes_total counter
+cidispatcher_task_read_bytes_total{task="API"} 4096
+# TYPE cidispatcher_task_write_bytes_total counter
+cidispatcher_task_write_bytes_total{task="API"} 1e+09
+`
+	var b bytes.Buffer
+	status.Metrics(&b)
+	if b.String() != golden {
+		t.Errorf("metrics:\n%s\nwant:\n%s", b.String(), golden)
+	}
+}
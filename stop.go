@@ -0,0 +1,141 @@
+package dispatcher
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Averianov/cidispatcher/wrapper"
+	sl "github.com/Averianov/cisystemlog"
+)
+
+const STOP_MESSAGE string = "STOP" // step of stop sequence: cooperative message STOP to service (handled by wrapper.Run)
+
+// StopStep is step of stopping of task process: message or signal after time since start of stopping
+type StopStep struct {
+	Signal string        `json:"signal"` // STOP_MESSAGE or name of signal: SIGTERM, SIGINT, SIGUSR1, SIGUSR2, SIGHUP, SIGQUIT, SIGKILL
+	After  time.Duration `json:"after"`
+}
+
+// DefaultStopSequence is used for task without own StopSequence
+var DefaultStopSequence = []StopStep{
+	{Signal: STOP_MESSAGE},
+	{Signal: "SIGTERM", After: 5 * time.Second},
+	{Signal: "SIGKILL", After: 15 * time.Second},
+}
+
+var stopSignals = map[string]syscall.Signal{
+	"SIGTERM": syscall.SIGTERM,
+	"SIGINT":  syscall.SIGINT,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+}
+
+// prepareStopSequence validate steps, bring signals to uppercase and sort steps by time
+func prepareStopSequence(steps []StopStep) ([]StopStep, error) {
+	prepared := make([]StopStep, len(steps))
+	for i, step := range steps {
+		step.Signal = strings.ToUpper(step.Signal)
+		if !strings.HasPrefix(step.Signal, "SIG") && step.Signal != STOP_MESSAGE {
+			step.Signal = "SIG" + step.Signal
+		}
+		if _, ok := stopSignals[step.Signal]; !ok && step.Signal != STOP_MESSAGE {
+			return nil, fmt.Errorf("unknown signal %s of stop sequence", step.Signal)
+		}
+		if step.After < 0 {
+			return nil, fmt.Errorf("negative time of stop step %s", step.Signal)
+		}
+		prepared[i] = step
+	}
+	sort.SliceStable(prepared, func(i, j int) bool { return prepared[i].After < prepared[j].After })
+	return prepared, nil
+}
+
+// signal send signal to process group of task, so children of process got it too;
+// to process itself if it was not launched in own group
+func (task *Task) signal(process *os.Process, sig syscall.Signal) (err error) {
+	task.Lock()
+	pgid := task.Pgid
+	task.Unlock()
+	if pgid > 0 && pgid == process.Pid {
+		if err = syscall.Kill(-pgid, sig); err == nil {
+			return
+		}
+	}
+	return process.Signal(sig)
+}
+
+// withinGrace shorten steps proportionally to end in grace; SIGKILL added at grace if sequence does not end with it
+func withinGrace(steps []StopStep, grace time.Duration) (short []StopStep) {
+	last := steps[len(steps)-1].After
+	for _, step := range steps {
+		if last > grace {
+			step.After = time.Duration(float64(step.After) * float64(grace) / float64(last))
+		}
+		short = append(short, step)
+	}
+	if short[len(short)-1].Signal != "SIGKILL" {
+		short = append(short, StopStep{Signal: "SIGKILL", After: grace})
+	}
+	return
+}
+
+// stopSteps return own stop sequence of task or DefaultStopSequence
+func (task *Task) stopSteps() []StopStep {
+	task.Lock()
+	defer task.Unlock()
+	if len(task.StopSequence) == 0 {
+		return DefaultStopSequence
+	}
+	return task.StopSequence
+}
+
+// stopSequence run steps of stopping until process exit; one sequence at time
+func (task *Task) stopSequence(process *os.Process, steps []StopStep) {
+	task.Lock()
+	if task.stopping {
+		task.Unlock()
+		return
+	}
+	task.stopping = true
+	exited := task.exited
+	task.Unlock()
+
+	go func() {
+		defer func() {
+			task.Lock()
+			task.stopping = false
+			task.Unlock()
+		}()
+		start := time.Now()
+		for _, step := range steps {
+			select {
+			case <-exited:
+				return
+			case <-time.After(time.Until(start.Add(step.After))):
+			}
+
+			var err error
+			if step.Signal == STOP_MESSAGE {
+				sl.L.Info("[task] try cooperative stop %s by pid %d", task.Name, process.Pid)
+				err = task.Wpr.SendToService(task.Name, wrapper.STOP, task.Name)
+			} else {
+				sl.L.Info("[task] try stop %s by pid %d with %s", task.Name, process.Pid, step.Signal)
+				err = task.signal(process, stopSignals[step.Signal])
+			}
+			if err != nil {
+				sl.L.Warning("[task] %s err: %s ", task.Name, err.Error())
+			}
+		}
+		select { // let the last step work before next sequence
+		case <-exited:
+		case <-time.After(time.Second):
+		}
+	}()
+}
@@ -0,0 +1,73 @@
+package dispatcher
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrepareStopSequence(t *testing.T) {
+	steps, err := prepareStopSequence([]StopStep{{Signal: "kill", After: 2 * time.Second}, {Signal: "sigterm", After: time.Second}, {Signal: "STOP"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []StopStep{{Signal: STOP_MESSAGE}, {Signal: "SIGTERM", After: time.Second}, {Signal: "SIGKILL", After: 2 * time.Second}}
+	for i := range want {
+		if steps[i] != want[i] {
+			t.Fatalf("got %v want %v", steps, want)
+		}
+	}
+	for _, wrong := range [][]StopStep{{{Signal: "SIGFOO"}}, {{Signal: "SIGTERM", After: -time.Second}}} {
+		if _, err := prepareStopSequence(wrong); err == nil {
+			t.Errorf("no error for %v", wrong)
+		}
+	}
+}
+
+// TestStopSequenceKillsGroup process ignoring SIGTERM is killed by next step together with its children
+func TestStopSequenceKillsGroup(t *testing.T) {
+	d, _ := testDispatcher(t)
+	payload, err := os.ReadFile("/bin/sh")
+	if err != nil {
+		t.Skip("no /bin/sh")
+	}
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	task := &Task{Name: "SH", Type: TYPE_SERVICE, Wpr: d.Wpr, Replica: -1, StMustStart: true, ElfPayload: payload,
+		StopSequence: []StopStep{{Signal: "SIGTERM"}, {Signal: "SIGKILL", After: 500 * time.Millisecond}}}
+	d.AddTask(task)
+	if err = task.LaunchInMemory([]string{"-c", "trap '' TERM; sleep 1000 & echo $! > " + pidFile + "; wait"}); err != nil {
+		t.Fatal(err)
+	}
+	task.Started()
+	var child int
+	waitFor(t, 2*time.Second, func() bool {
+		raw, _ := os.ReadFile(pidFile)
+		child, _ = strconv.Atoi(strings.TrimSpace(string(raw)))
+		return child > 0
+	})
+
+	start := time.Now()
+	task.Disable()
+	task.Stop()
+	task.Stop() // one sequence at time
+	waitFor(t, 3*time.Second, func() bool { return task.process() == nil })
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("stopped after %v; SIGTERM must be ignored and SIGKILL sent at 500ms", elapsed)
+	}
+	task.Lock()
+	run, pgid := task.Runs[len(task.Runs)-1], task.Pgid
+	task.Unlock()
+	if !run.Stopped || run.Failed() {
+		t.Fatalf("run %+v", run)
+	}
+	if pgid != 0 { // killed group must not be killed again by next run
+		t.Fatal("pgid kept", pgid)
+	}
+	waitFor(t, 2*time.Second, func() bool {
+		proc, err := readProc(child)
+		return err != nil || proc.State == "Z"
+	})
+}
@@ -2,25 +2,29 @@ package dispatcher
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"github.com/Averianov/cidispatcher/wrapper"
 	sl "github.com/Averianov/cisystemlog"
+	"github.com/Averianov/ciutils"
 )
 
 const SYS_MEMFD_CREATE = 319 // Only for Linux x86_64
-const KILLING_ATTEMPT int = 3
 
 type Task struct {
 	sync.Mutex
-	Ctx          context.Context
+	Ctx context.Context
 	// Cancel       context.CancelFunc
 	Name         string
 	ElfPayload   []byte
@@ -29,9 +33,46 @@ type Task struct {
 	StLaunched   bool
 	Required     []string
 	Cmd          *exec.Cmd
-	Reminder     int
 	Wpr          *wrapper.Wrapper
 	Env          []string
+	Type         string
+	Group        string // name of replicas group; empty for task without replicas
+	Replica      int    // index of replica in group; -1 for task without replicas
+	StRemoved    bool   // replica removed by scaling; deleted after stop
+	Acl          []Permission
+	StCompleted  bool
+	StFailed     bool
+	Retry        *RetryPolicy
+	Attempts     int
+	RetryAt      time.Time
+	Schedule     *Schedule
+	NextRun      time.Time
+	RunQueued    bool
+	RunStarted   time.Time
+	RunKilled    bool
+	Runs         []RunResult
+	StReady      bool        // service reported READY by wrapper.Run
+	StReloadable bool        // service apply new config without restart (declared by wrapper.Run)
+	Failure      string      // last failure reason reported by service
+	Launches     int         // count of launched processes
+	PayloadHash  string      // sha256 of ElfPayload
+	Usage        *Usage      // last sample of resources of running process
+	UsageAt      time.Time   // time of last sample
+	Limits       []Limit     // soft thresholds of resources
+	LimitSince   []time.Time // by Limits: first sample with exceeded threshold
+
+	WatchdogTimeout time.Duration // restart process without ping for this time; 0 - watchdog off
+	WatchdogDump    bool          // take goroutine dump by SIGQUIT before restart of hanging process
+	LastPing        time.Time     // last ping of watchdog
+	StHung          bool          // missed deadline of watchdog; restarting
+	Stderr          *tailBuffer   // tail of stderr of process for dump; nil without WatchdogDump
+
+	StopSequence []StopStep    // steps of stopping; nil - DefaultStopSequence
+	Pgid         int           // process group of launched process; its children are in the same group
+	exited       chan struct{} // closed when launched process exit
+	stopping     bool          // stop sequence in progress
+	Orphans      []int         // living descendants of task reparented to master
+	Pipe         bool          // process connected to bus by inherited socketpair bridged by master
 }
 
 func (task *Task) LaunchInMemory(args []string) (err error) {
@@ -81,15 +122,38 @@ func (task *Task) LaunchInMemory(args []string) (err error) {
 	//task.Cmd.ExtraFiles = []*os.File{file}
 	task.Cmd.Stdout = os.Stdout
 	task.Cmd.Stderr = os.Stderr
+	task.Stderr = nil
+	if task.WatchdogDump {
+		task.Stderr = newTailBuffer(WATCHDOG_DUMP_SIZE)
+		task.Cmd.Stderr = io.MultiWriter(os.Stderr, task.Stderr)
+	}
 	task.Cmd.Stdin = os.Stdin
 	task.Cmd.Env = append(task.Cmd.Env, task.Env...)
+	var bridge *wrapper.PipeTransport
+	if task.Pipe {
+		var child *os.File
+		child, bridge, err = task.OpenPipe()
+		if err != nil {
+			sl.L.Warning("[task] %s pipe err: %s ", task.Name, err.Error())
+			return
+		}
+		defer child.Close() // inherited by process
+		task.Cmd.ExtraFiles = []*os.File{child}
+		task.Cmd.Env = append(task.Cmd.Env, fmt.Sprintf("%s=%s%d", wrapper.CI_REDIS_ADDR, wrapper.PIPE_PREFIX, PIPE_FD))
+	}
+	task.Cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true} // own group: stop signals reach children of process
 
 	if len(task.ElfPayload) < 4 || string(task.ElfPayload[:4]) != "\x7fELF" {
 		sl.L.Warning("[task] payload is not a valid ELF (magic bytes missing)")
 	}
+	launching.RLock()
+	defer launching.RUnlock()
 	err = task.Cmd.Start()
 	if err != nil {
 		sl.L.Warning("[task] %s err: %s ", task.Name, err.Error())
+		if bridge != nil {
+			bridge.Close()
+		}
 		err = syscall.Close(int(fd))
 		if err != nil {
 			sl.L.Warning("[task] %s err: %s ", task.Name, err.Error())
@@ -97,23 +161,68 @@ func (task *Task) LaunchInMemory(args []string) (err error) {
 		return
 	}
 
+	exited := make(chan struct{})
+	task.Lock() // before wait: exited process must not see pgid of previous run
+	task.StInProgress = true
+	task.RunStarted = ciutils.Now()
+	task.RunKilled = false
+	task.Launches++
+	task.Pgid = task.Cmd.Process.Pid
+	task.exited = exited
+	task.Unlock()
+
 	go func() {
+		defer close(exited)
 		err := task.Cmd.Wait() // Auto "get" process, when die
 		if err != nil {
 			sl.L.Warning("[task] %s process finished with error: %s", task.Name, err)
 		} else {
 			sl.L.Info("[task] %s process finished successfully", task.Name)
 		}
+		if bridge != nil {
+			bridge.Close()
+		}
+		task.RecordRun(err)
+		task.KillOrphans()
+		task.Lock()
+		task.Pgid = 0 // group is killed; its id may be reused
 		task.Cmd = nil
+		task.Unlock()
 		task.Stopped()
+		task.ReleaseLocks()
 	}()
 
+	sl.L.Debug("[task] %s got pid %d", task.Name, task.Cmd.Process.Pid)
+	return
+}
+
+// RecordRun save result of finished process
+func (task *Task) RecordRun(err error) {
 	task.Lock()
-	task.StInProgress = true
+	stopped := task.stopping
 	task.Unlock()
+	run := RunResult{
+		Started:  task.RunStarted,
+		Finished: ciutils.Now(),
+		ExitCode: -1,
+		Killed:   task.RunKilled,
+		Stopped:  stopped,
+	}
+	if task.Cmd != nil && task.Cmd.ProcessState != nil {
+		run.ExitCode = task.Cmd.ProcessState.ExitCode()
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
 
-	sl.L.Debug("[task] %s got pid %d", task.Name, task.Cmd.Process.Pid)
-	return
+	task.Lock()
+	task.Runs = append(task.Runs, run)
+	if len(task.Runs) > MAX_RUN_HISTORY {
+		task.Runs = task.Runs[len(task.Runs)-MAX_RUN_HISTORY:]
+	}
+	task.Unlock()
+	sl.L.Info("[task] %s run finished with code %d in %v", task.Name, run.ExitCode, run.Finished.Sub(run.Started))
+	task.Finished(run)
 }
 
 // Check task as runned
@@ -142,44 +251,32 @@ func (task *Task) Check() (launched *os.Process, err error) {
 	return
 }
 
-// Stop signal to task to default stopping proccess
+// Stop start stop sequence of task process (DefaultStopSequence if task has no own);
+// steps go by real time until process exit, repeated calls while sequence works do nothing
 func (task *Task) Stop() (err error) {
+	return task.StopBy(task.stopSteps())
+}
+
+// StopBy stop task process like Stop by given steps instead of its stop sequence
+func (task *Task) StopBy(steps []StopStep) (err error) {
 	var process *os.Process
 	process, err = task.Check()
 	if process == nil && err != nil {
 		sl.L.Debug("[task] %s err: %s ", task.Name, err.Error())
 		return
 	}
-
-	switch task.Reminder {
-	// case 0:
-	// 	sl.L.Info("[task] try cooperative stop %s by pid %d; reminder No %d", task.Name, task.Cmd.Process.Pid, task.Reminder)
-	// 	err = process.Signal(syscall.SIGUSR1)
-	// 	if err != nil {
-	// 		sl.L.Warning("[task] %s err: %s ", task.Name, err.Error())
-	// 	}
-	case 1:
-		sl.L.Info("[task] try stop %s by pid %d; reminder No %d", task.Name, task.Cmd.Process.Pid, task.Reminder)
-		err = process.Signal(syscall.SIGTERM)
-		if err != nil {
-			sl.L.Warning("[task] %s err: %s ", task.Name, err.Error())
-		}
-	case KILLING_ATTEMPT:
-		sl.L.Info("[task] try kill %s by pid %d; reminder No %d", task.Name, task.Cmd.Process.Pid, task.Reminder)
-		task.Kill(process)
-		if err != nil {
-			sl.L.Warning("[task] %s err: %s ", task.Name, err.Error())
-		}
-	}
-
-	task.Reminder++
-	sl.L.Info("[task] %s reminder No %d before killed", task.Name, task.Reminder)
+	task.stopSequence(process, steps)
 	return
 }
 
+// Restart stop running process by stop sequence; task which must be started is launched again with current env
+func (task *Task) Restart() (err error) {
+	return task.Stop()
+}
+
 // Kill task by pid
 func (task *Task) Kill(process *os.Process) (err error) {
-	err = process.Signal(syscall.SIGKILL)
+	err = task.signal(process, syscall.SIGKILL)
 	if err != nil {
 		sl.L.Warning("[task] %s err: %s ", task.Name, err.Error())
 		if strings.Contains(err.Error(), "os: process already finished") {
@@ -215,6 +312,9 @@ func (task *Task) Enable() {
 
 	task.Lock()
 	task.StMustStart = true
+	task.StFailed = false
+	task.Attempts = 0
+	task.RetryAt = time.Time{}
 	task.Unlock()
 
 	sl.L.Info("[task] %s - enabled", task.Name)
@@ -239,6 +339,15 @@ func (task *Task) Disable() (err error) {
 	return
 }
 
+// PayloadHash return sha256 of payload in hex; empty for task without payload
+func PayloadHash(payload []byte) string {
+	if payload == nil {
+		return ""
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
 // Started mark task as started
 func (task *Task) Started() {
 	if task.StLaunched {
@@ -249,14 +358,13 @@ func (task *Task) Started() {
 	task.Lock()
 	task.StInProgress = false
 	task.StLaunched = true
-	task.Reminder = 0
 	task.Unlock()
 	sl.L.Info("[task] %s started", task.Name)
 }
 
 // Stopped mark task as stopped
 func (task *Task) Stopped() {
-	if !task.StLaunched {
+	if !task.StLaunched && !task.StInProgress {
 		sl.L.Debug("[task] %s already stopped", task.Name)
 		return
 	}
@@ -264,11 +372,46 @@ func (task *Task) Stopped() {
 	task.Lock()
 	task.StInProgress = false
 	task.StLaunched = false
-	task.Reminder = 0
+	task.StReady = false
+	task.StReloadable = false
+	task.Usage = nil
+	task.LimitSince = nil
+	task.StHung = false
 	task.Unlock()
 	sl.L.Info("[task] %s stopped", task.Name)
 }
 
+// ReleaseLocks free distributed locks held by dead process of task
+func (task *Task) ReleaseLocks() {
+	if task.Wpr == nil || task.Wpr.RClient == nil {
+		return
+	}
+	released, err := wrapper.ReleaseLocks(context.Background(), task.Wpr.RClient, task.Name)
+	if err != nil {
+		sl.L.Warning("[task] %s release locks err: %s", task.Name, err.Error())
+		return
+	}
+	if released > 0 {
+		sl.L.Info("[task] %s - released %d locks", task.Name, released)
+	}
+}
+
+// Ready mark service as ready to work
+func (task *Task) Ready() {
+	task.Lock()
+	task.StReady = true
+	task.Unlock()
+	sl.L.Info("[task] %s ready", task.Name)
+}
+
+// Fail keep failure reason reported by service
+func (task *Task) Fail(reason string) {
+	task.Lock()
+	task.Failure = reason
+	task.Unlock()
+	sl.L.Alert("[task] %s failure: %s", task.Name, reason)
+}
+
 // func runForWindows() {
 // 	var childBinary []byte
 // 	tempFile := filepath.Join(os.TempDir(), "internal_module.exe")
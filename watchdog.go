@@ -0,0 +1,124 @@
+package dispatcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Averianov/cidispatcher/wrapper"
+	sl "github.com/Averianov/cisystemlog"
+)
+
+const (
+	WATCHDOG_DUMP_WAIT time.Duration = 3 * time.Second // wait exit of process after SIGQUIT (Go runtime print goroutines and exit)
+	WATCHDOG_DUMP_SIZE int           = 1 << 20         // tail of stderr of process kept for dump
+)
+
+// tailBuffer keep last bytes written to it; stderr of task is copied here for goroutine dump
+type tailBuffer struct {
+	sync.Mutex
+	buf  []byte
+	size int
+}
+
+func newTailBuffer(size int) *tailBuffer {
+	return &tailBuffer{size: size}
+}
+
+func (t *tailBuffer) Write(p []byte) (n int, err error) {
+	t.Lock()
+	defer t.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.size {
+		t.buf = append([]byte{}, t.buf[len(t.buf)-t.size:]...)
+	}
+	return len(p), nil
+}
+
+// Bytes return copy of kept bytes
+func (t *tailBuffer) Bytes() []byte {
+	t.Lock()
+	defer t.Unlock()
+	return append([]byte{}, t.buf...)
+}
+
+func (t *tailBuffer) Reset() {
+	t.Lock()
+	t.buf = nil
+	t.Unlock()
+}
+
+// Heartbeat remember ping of watchdog from task
+func (task *Task) Heartbeat() {
+	task.Lock()
+	task.LastPing = time.Now()
+	task.Unlock()
+	sl.L.Debug("[task] %s ping", task.Name)
+}
+
+// CheckWatchdogs find running tasks which missed deadline of watchdog and restart them;
+// deadline is not less than two checks of dispatcher
+func (d *Dispatcher) CheckWatchdogs() {
+	now := time.Now()
+	for _, task := range d.TaskList() {
+		task.Lock()
+		timeout := max(task.WatchdogTimeout, 2*d.CheckDureation)
+		last := task.LastPing
+		if last.Before(task.RunStarted) { // pings of previous run
+			last = task.RunStarted
+		}
+		hung := task.WatchdogTimeout > 0 && !task.StHung && task.StMustStart && task.StLaunched && !task.StInProgress &&
+			task.Cmd != nil && task.Cmd.Process != nil && now.Sub(last) > timeout
+		var process *os.Process
+		if hung {
+			task.StHung = true
+			task.RunKilled = true
+			task.Failure = fmt.Sprintf("watchdog: no ping for %v", now.Sub(last).Round(time.Second))
+			process = task.Cmd.Process
+		}
+		task.Unlock()
+
+		if hung {
+			sl.L.Alert("[master] task %s - hangs: no ping since %s; restart", task.Name, last.Format(time.DateTime))
+			go d.restartHung(task, process)
+		}
+	}
+}
+
+// restartHung take goroutine dump of hanging process (if enabled) and kill it; task relaunched by checker
+func (d *Dispatcher) restartHung(task *Task, process *os.Process) {
+	task.Lock()
+	dump, stderr := task.WatchdogDump, task.Stderr
+	task.Unlock()
+
+	exited := func() bool {
+		task.Lock()
+		defer task.Unlock()
+		return task.Cmd == nil || task.Cmd.Process == nil || task.Cmd.Process.Pid != process.Pid
+	}
+	if dump && stderr != nil {
+		stderr.Reset()
+		if err := process.Signal(syscall.SIGQUIT); err != nil {
+			sl.L.Warning("[master] task %s - dump err: %s", task.Name, err.Error())
+		}
+		for deadline := time.Now().Add(WATCHDOG_DUMP_WAIT); !exited() && time.Now().Before(deadline); {
+			time.Sleep(100 * time.Millisecond)
+		}
+		file := filepath.Join(wrapper.LOG_DIR, fmt.Sprintf("%s.hang-%s.log", task.Name, time.Now().Format("20060102-150405")))
+		err := os.MkdirAll(wrapper.LOG_DIR, 0750)
+		if err == nil {
+			err = os.WriteFile(file, stderr.Bytes(), 0600)
+		}
+		if err != nil {
+			sl.L.Warning("[master] task %s - save dump err: %s", task.Name, err.Error())
+		} else {
+			sl.L.Alert("[master] task %s - goroutine dump saved to %s", task.Name, file)
+		}
+	}
+	if !exited() {
+		task.Kill(process)
+	}
+}
@@ -0,0 +1,90 @@
+package dispatcher
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Averianov/cidispatcher/wrapper"
+)
+
+// testHungTask launch shell loop as service with watchdog; on SIGQUIT it prints dump to stderr and exits
+func testHungTask(t *testing.T, d *Dispatcher, dump bool) *Task {
+	t.Helper()
+	payload, err := os.ReadFile("/bin/sh")
+	if err != nil {
+		t.Skip("no /bin/sh")
+	}
+	task := &Task{Name: "LOOP", Type: TYPE_SERVICE, Wpr: d.Wpr, Replica: -1, StMustStart: true, ElfPayload: payload,
+		WatchdogTimeout: 300 * time.Millisecond, WatchdogDump: dump}
+	d.AddTask(task)
+	if err = task.LaunchInMemory([]string{"-c", "trap 'echo goroutine 1 [chan receive] >&2; exit 2' QUIT; while :; do sleep 0.05; done"}); err != nil {
+		t.Fatal(err)
+	}
+	task.Started()
+	return task
+}
+
+// TestWatchdogRestart task without pings is killed after timeout and left to checker for launch; pings keep it
+func TestWatchdogRestart(t *testing.T) {
+	d, _ := testDispatcher(t)
+	d.CheckDureation = 50 * time.Millisecond
+	task := testHungTask(t, d, false)
+	process := task.process()
+
+	for deadline := time.Now().Add(500 * time.Millisecond); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		task.Heartbeat()
+		d.CheckWatchdogs()
+	}
+	if task.process() != process {
+		t.Fatal("task with pings restarted")
+	}
+
+	start := time.Now()
+	waitFor(t, 2*time.Second, func() bool {
+		d.CheckWatchdogs()
+		return task.process() == nil
+	})
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Fatalf("killed after %v, before timeout", elapsed)
+	}
+	task.Lock()
+	defer task.Unlock()
+	if !strings.HasPrefix(task.Failure, "watchdog: no ping") || !task.RunKilled {
+		t.Fatalf("failure %q, killed %v", task.Failure, task.RunKilled)
+	}
+	if !task.StMustStart || task.StLaunched || task.StHung { // launched again by checker
+		t.Fatalf("must start %v, launched %v, hung %v", task.StMustStart, task.StLaunched, task.StHung)
+	}
+}
+
+// TestWatchdogDump hanging task get SIGQUIT and tail of its stderr is saved to log directory
+func TestWatchdogDump(t *testing.T) {
+	d, _ := testDispatcher(t)
+	d.CheckDureation = 50 * time.Millisecond
+	task := testHungTask(t, d, true)
+
+	waitFor(t, 5*time.Second, func() bool {
+		d.CheckWatchdogs()
+		return task.process() == nil
+	})
+	var dump []byte
+	waitFor(t, 2*time.Second, func() bool {
+		files, _ := filepath.Glob(filepath.Join(wrapper.LOG_DIR, "LOOP.hang-*.log"))
+		if len(files) == 0 {
+			return false
+		}
+		dump, _ = os.ReadFile(files[0])
+		return len(dump) > 0
+	})
+	if !strings.Contains(string(dump), "goroutine 1 [chan receive]") {
+		t.Fatalf("dump %q", dump)
+	}
+	task.Lock()
+	defer task.Unlock()
+	if run := task.Runs[len(task.Runs)-1]; run.ExitCode != 2 { // exited by itself after dump, not killed
+		t.Fatalf("run %+v", run)
+	}
+}
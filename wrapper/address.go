@@ -0,0 +1,66 @@
+package wrapper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	CI_REDIS_ADDR string = "CIREDISADDR" // env with bus address: "unix:///path/bus.sock", "host:port", "redis://..." URL or "fd://3" pipe
+
+	UNIX_PREFIX     string = "unix://"
+	PIPE_PREFIX     string = "fd://" // direct pipe: descriptor inherited from parent (socketpair)
+	BUS_SOCKET_NAME string = "bus.sock"
+	BUS_ADDR_NAME   string = "bus.addr" // address of bus written by dispatcher for tools started by hand
+)
+
+// BusSocket return path to unix socket of bus in runtime directory
+func BusSocket() string {
+	path, err := filepath.Abs(filepath.Join(RuntimeDir(), BUS_SOCKET_NAME))
+	if err != nil {
+		return filepath.Join(RuntimeDir(), BUS_SOCKET_NAME)
+	}
+	return path
+}
+
+// BusAddrFile return path to file with address of bus in runtime directory
+func BusAddrFile() string {
+	return filepath.Join(RuntimeDir(), BUS_ADDR_NAME)
+}
+
+// BusAddr detect network and address of bus from env CIREDISADDR or CIREDISPORT,
+// else from address file or unix socket in runtime directory
+func BusAddr() (network, addr string, err error) {
+	if val, ok := os.LookupEnv(CI_REDIS_ADDR); ok && val != "" {
+		network, addr = parseBusAddr(val)
+		return
+	}
+	if port, ok := os.LookupEnv(CI_REDIS_PORT); ok && port != "" {
+		return "tcp", "localhost:" + port, nil
+	}
+	if raw, rerr := os.ReadFile(BusAddrFile()); rerr == nil && len(strings.TrimSpace(string(raw))) > 0 {
+		network, addr = parseBusAddr(strings.TrimSpace(string(raw)))
+		return
+	}
+	if _, err = os.Stat(BusSocket()); err == nil {
+		return "unix", BusSocket(), nil
+	}
+	err = fmt.Errorf("The environment %s or %s must be set", CI_REDIS_ADDR, CI_REDIS_PORT)
+	return
+}
+
+// parseBusAddr split address of bus on network and address
+func parseBusAddr(val string) (network, addr string) {
+	if strings.HasPrefix(val, UNIX_PREFIX) {
+		return "unix", strings.TrimPrefix(val, UNIX_PREFIX)
+	}
+	if isURL(val) {
+		return "url", val
+	}
+	if strings.HasPrefix(val, PIPE_PREFIX) {
+		return "fd", strings.TrimPrefix(val, PIPE_PREFIX)
+	}
+	return "tcp", val
+}
@@ -0,0 +1,155 @@
+package wrapper
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	CI_TOKEN       string = "CITOKEN"      // env with credentials of service generated by dispatcher
+	CI_RUNTIME_DIR string = "CIRUNTIMEDIR" // env with private directory of dispatcher runtime files
+
+	DEFAULT_RUNTIME_DIR string = "./run"
+	TOKEN_FILE_SUFFIX   string = ".token"
+)
+
+// Keystore return token of service for verify its messages; set by dispatcher in master
+var Keystore func(name string) (token string, ok bool)
+
+// NewToken return random credentials of service
+func NewToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RuntimeDir return private directory of dispatcher runtime files
+func RuntimeDir() string {
+	if dir, ok := os.LookupEnv(CI_RUNTIME_DIR); ok && dir != "" {
+		return dir
+	}
+	return DEFAULT_RUNTIME_DIR
+}
+
+// TokenFile return path to file with token of service which started not by dispatcher (as SENDER)
+func TokenFile(name string) string {
+	return filepath.Join(RuntimeDir(), strings.ToUpper(name)+TOKEN_FILE_SUFFIX)
+}
+
+// LoadToken got token of service from env or from token file
+func LoadToken(name string) (token string) {
+	if token, ok := os.LookupEnv(CI_TOKEN); ok {
+		return token
+	}
+	raw, err := os.ReadFile(TokenFile(name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(raw))
+}
+
+// authUser return username for bus; without token - default user
+func authUser(name, token string) string {
+	if token == "" {
+		return ""
+	}
+	return name
+}
+
+// signingBytes return canonical form of message for HMAC
+func (m *RedisMessage) signingBytes() []byte {
+	var b bytes.Buffer
+	for _, field := range []string{m.Sender, m.Key, m.ID, m.Version,
+		strconv.FormatInt(m.CreatedAt, 10), strconv.FormatInt(m.ExpiresAt, 10), m.ReplyTo, m.ContentType} {
+		b.WriteString(field)
+		b.WriteByte('\n')
+	}
+	names := make([]string, 0, len(m.Headers))
+	for name := range m.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString(name + "=" + m.Headers[name])
+		b.WriteByte('\n')
+	}
+	if m.Data != nil {
+		b.Write(m.Data)
+	} else {
+		b.Write(m.raw)
+	}
+	return b.Bytes()
+}
+
+// Sign message by token
+func (m *RedisMessage) Sign(token string) {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write(m.signingBytes())
+	m.Signature = hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify signature of message by token
+func (m *RedisMessage) Verify(token string) (err error) {
+	if m.Signature == "" {
+		err = fmt.Errorf("message %s from %s not signed", m.ID, m.Sender)
+		return
+	}
+	sig, err := hex.DecodeString(m.Signature)
+	if err != nil {
+		err = fmt.Errorf("message %s from %s has wrong signature", m.ID, m.Sender)
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write(m.signingBytes())
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		err = fmt.Errorf("message %s from %s has wrong signature", m.ID, m.Sender)
+	}
+	return
+}
+
+// signFor sign message to channel; master sign by token of recipient, so recipient can verify it
+func (wpr *Wrapper) signFor(channel string, msg *RedisMessage) {
+	if wpr.Token == "" {
+		return
+	}
+	token := wpr.Token
+	if wpr.Name == MASTER && Keystore != nil {
+		if recipient, ok := Keystore(channel); ok {
+			token = recipient
+		}
+	}
+	msg.Sign(token)
+}
+
+// verify signature of received message: master verify all messages by token of sender;
+// services verify direct messages from master by own token (messages of other services
+// are verified by the bus, which knows authenticated user of every connection)
+func (wpr *Wrapper) verify(channel string, msg *RedisMessage) (err error) {
+	if wpr.Token == "" {
+		return
+	}
+	switch true {
+	case wpr.Name == MASTER:
+		if Keystore == nil {
+			return
+		}
+		token, ok := Keystore(msg.Sender)
+		if !ok {
+			err = fmt.Errorf("message %s from unknown sender %s", msg.ID, msg.Sender)
+			return
+		}
+		err = msg.Verify(token)
+	case msg.Sender == MASTER && channel == wpr.Name:
+		err = msg.Verify(wpr.Token)
+	}
+	return
+}
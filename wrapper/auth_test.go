@@ -0,0 +1,67 @@
+package wrapper
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestSignVerify signature survive envelope on the bus and break on any change of signed fields
+func TestSignVerify(t *testing.T) {
+	token := NewToken()
+	for _, codec := range []Codec{JSONCodec{}, MsgpackCodec{}} {
+		wpr := &Wrapper{Name: "A", Codec: codec}
+		msg, err := wpr.NewMessage("KEY", map[string]any{"n": 1}, WithHeader("trace", "1"), WithReplyTo("B"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Sign(token)
+		payload, err := msg.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := RedisMessage{}
+		if err = got.UnmarshalBinary(payload); err != nil {
+			t.Fatal(err)
+		}
+		if err = got.Verify(token); err != nil {
+			t.Errorf("%T: %v", codec, err)
+		}
+		if err = got.Verify(NewToken()); err == nil {
+			t.Errorf("%T: verified by other token", codec)
+		}
+
+		tampered := map[string]func(m *RedisMessage){
+			"sender":   func(m *RedisMessage) { m.Sender = MASTER },
+			"key":      func(m *RedisMessage) { m.Key = STATUS },
+			"header":   func(m *RedisMessage) { m.Headers["trace"] = "2" },
+			"reply":    func(m *RedisMessage) { m.ReplyTo = "C" },
+			"expires":  func(m *RedisMessage) { m.ExpiresAt = 1 },
+			"unsigned": func(m *RedisMessage) { m.Signature = "" },
+		}
+		for name, change := range tampered {
+			m := RedisMessage{}
+			m.UnmarshalBinary(payload)
+			change(&m)
+			if err = m.Verify(token); err == nil {
+				t.Errorf("%T: verified with changed %s", codec, name)
+			}
+		}
+
+		// value changed in payload on the way
+		changed := bytes.Replace(payload, []byte{0xa1, 'n', 1}, []byte{0xa1, 'n', 2}, 1) // msgpack of {"n":1}
+		if codec.ContentType() == CT_JSON {
+			changed = bytes.Replace(payload, []byte(`{"n":1}`), []byte(`{"n":2}`), 1)
+		}
+		if bytes.Equal(changed, payload) {
+			t.Fatalf("%T: value not found in payload", codec)
+		}
+		m := RedisMessage{}
+		if err = m.UnmarshalBinary(changed); err != nil {
+			t.Fatal(err)
+		}
+		if err = m.Verify(token); err == nil {
+			t.Errorf("%T: verified with changed value", codec)
+		}
+	}
+}
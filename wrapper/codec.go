@@ -0,0 +1,141 @@
+package wrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	CODEC string = "CICODEC" // env with content type of default codec for sending
+
+	CT_JSON     string = "json"
+	CT_MSGPACK  string = "msgpack"
+	CT_PROTOBUF string = "protobuf"
+)
+
+// Codec encode values of messages; content type of codec saved in envelope
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	codecsMutex sync.RWMutex
+	codecs      = map[string]Codec{
+		CT_JSON:     JSONCodec{},
+		CT_MSGPACK:  MsgpackCodec{},
+		CT_PROTOBUF: ProtobufCodec{},
+	}
+)
+
+// RegisterCodec add or replace codec by its content type
+func RegisterCodec(c Codec) {
+	codecsMutex.Lock()
+	codecs[c.ContentType()] = c
+	codecsMutex.Unlock()
+}
+
+// CodecByType return registered codec; empty content type is JSON
+func CodecByType(contentType string) (c Codec, err error) {
+	if contentType == "" {
+		contentType = CT_JSON
+	}
+	codecsMutex.RLock()
+	c, ok := codecs[contentType]
+	codecsMutex.RUnlock()
+	if !ok {
+		err = fmt.Errorf("unknown codec \"%s\"", contentType)
+	}
+	return
+}
+
+// Decode value of message into type T
+func Decode[T any](msg *RedisMessage) (value T, err error) {
+	if msg == nil {
+		err = fmt.Errorf("%s", "empty message")
+		return
+	}
+
+	if msg.Data == nil { // value encoded in JSON envelope
+		raw := msg.raw
+		if raw == nil {
+			raw, err = json.Marshal(msg.Value)
+			if err != nil {
+				return
+			}
+		}
+		err = json.Unmarshal(raw, &value)
+		return
+	}
+
+	var c Codec
+	c, err = CodecByType(msg.ContentType)
+	if err != nil {
+		return
+	}
+	err = c.Unmarshal(msg.Data, &value)
+	return
+}
+
+// ### JSON ##################################################################
+
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string                { return CT_JSON }
+func (JSONCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// ### MessagePack ###########################################################
+
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) ContentType() string                { return CT_MSGPACK }
+func (MsgpackCodec) Marshal(v any) ([]byte, error)      { return msgpack.Marshal(v) }
+func (MsgpackCodec) Unmarshal(data []byte, v any) error { return msgpack.Unmarshal(data, v) }
+
+// ### Protobuf ##############################################################
+
+// ProtobufCodec work only with values implementing proto.Message
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) ContentType() string { return CT_PROTOBUF }
+
+func (ProtobufCodec) Marshal(v any) (data []byte, err error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		err = fmt.Errorf("%T is not proto.Message", v)
+		return
+	}
+	return proto.Marshal(m)
+}
+
+// Unmarshal got proto.Message or pointer to nil proto.Message (as from Decode[*pb.Msg])
+func (ProtobufCodec) Unmarshal(data []byte, v any) (err error) {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Pointer {
+		err = fmt.Errorf("%T is not proto.Message", v)
+		return
+	}
+	target := reflect.New(rv.Elem().Type().Elem())
+	m, ok := target.Interface().(proto.Message)
+	if !ok {
+		err = fmt.Errorf("%T is not proto.Message", v)
+		return
+	}
+	err = proto.Unmarshal(data, m)
+	if err != nil {
+		return
+	}
+	rv.Elem().Set(target)
+	return
+}
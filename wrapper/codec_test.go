@@ -0,0 +1,43 @@
+package wrapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// TestBinaryEnvelope value of non-JSON codec follows header as is; JSON envelope still accepted
+func TestBinaryEnvelope(t *testing.T) {
+	value := map[string]any{"blob": bytes.Repeat([]byte{0xff}, 3000)}
+	data, err := MsgpackCodec{}.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &RedisMessage{Sender: "A", Key: "KEY", ContentType: CT_MSGPACK, Data: data, Version: PROTOCOL_VERSION}
+
+	raw, err := msg.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw[0] != BINARY_ENVELOPE || !bytes.HasSuffix(raw, data) || len(raw) > len(data)+200 {
+		t.Fatalf("binary envelope of %d bytes for %d bytes of value", len(raw), len(data))
+	}
+	asJSON, _ := json.Marshal(msg)
+	for _, envelope := range [][]byte{raw, asJSON} {
+		got := &RedisMessage{}
+		if err = got.UnmarshalBinary(envelope); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := Decode[map[string][]byte](got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Sender != "A" || got.Key != "KEY" || !bytes.Equal(decoded["blob"], value["blob"].([]byte)) {
+			t.Fatalf("%+v", got)
+		}
+	}
+
+	if err = (&RedisMessage{}).UnmarshalBinary(raw[:10]); err == nil {
+		t.Fatal("cut envelope accepted")
+	}
+}
@@ -0,0 +1,176 @@
+package wrapper
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	sl "github.com/Averianov/cisystemlog"
+)
+
+const (
+	CONN_CONNECTED    string = "CONNECTED"
+	CONN_DISCONNECTED string = "DISCONNECTED"
+
+	DROP_OLDEST string = "drop-oldest" // full outbox drop oldest message for new one
+	DROP_NEWEST string = "drop-newest" // full outbox reject new message
+
+	DEFAULT_BACKOFF_MIN   time.Duration = 500 * time.Millisecond
+	DEFAULT_BACKOFF_MAX   time.Duration = 30 * time.Second
+	DEFAULT_OUTBOX_SIZE   int           = 1000
+	DEFAULT_OUTBOX_POLICY string        = DROP_OLDEST
+)
+
+// connection keep state of bus connection, backoff of reconnects and messages waiting connection
+type connection struct {
+	sync.Mutex
+	state    string
+	attempt  int
+	retryAt  time.Time
+	outbox   []outMessage
+	patterns map[string]bool // topics subscribed by Subscribe; restored after reconnect
+}
+
+type outMessage struct {
+	channel string
+	msg     *RedisMessage
+}
+
+// Backoff return capped exponential delay with jitter for attempt from 0
+func Backoff(attempt int, min, max time.Duration) time.Duration {
+	d := max
+	if attempt < 32 && min<<attempt < max && min<<attempt > 0 {
+		d = min << attempt
+	}
+	return d/2 + rand.N(d/2+1) // jitter spread reconnects of many services
+}
+
+// Connected bus is available
+func (wpr *Wrapper) Connected() bool {
+	wpr.conn.Lock()
+	defer wpr.conn.Unlock()
+	return wpr.conn.state == CONN_CONNECTED
+}
+
+// setState change state of connection and call OnConnState
+func (wpr *Wrapper) setState(state string, err error) {
+	wpr.conn.Lock()
+	changed := wpr.conn.state != state
+	wpr.conn.state = state
+	if state == CONN_CONNECTED {
+		wpr.conn.attempt = 0
+	}
+	wpr.conn.Unlock()
+	if !changed {
+		return
+	}
+	if err != nil {
+		sl.L.Warning("[%s] bus %s: %s", wpr.Name, state, err.Error())
+	} else {
+		sl.L.Info("[%s] bus %s", wpr.Name, state)
+	}
+	if _, onConnState := wpr.handlers(); onConnState != nil {
+		onConnState(state, err)
+	}
+}
+
+// disconnected mark connection lost and plan next reconnect
+func (wpr *Wrapper) disconnected(err error) {
+	wpr.conn.Lock()
+	wpr.conn.retryAt = time.Now().Add(Backoff(wpr.conn.attempt, wpr.BackoffMin, wpr.BackoffMax))
+	wpr.conn.attempt++
+	wpr.conn.Unlock()
+	wpr.setState(CONN_DISCONNECTED, err)
+}
+
+// reconnect restore connection, subscriptions and membership in groups, then send messages of outbox;
+// return JUST_WAIT before planned time of attempt
+func (wpr *Wrapper) reconnect(ctx context.Context) (err error) {
+	wpr.conn.Lock()
+	wait := time.Until(wpr.conn.retryAt)
+	patterns := make([]string, 0, len(wpr.conn.patterns))
+	for p := range wpr.conn.patterns {
+		patterns = append(patterns, p)
+	}
+	wpr.conn.Unlock()
+	if wait > 0 {
+		return fmt.Errorf("%s", JUST_WAIT)
+	}
+
+	err = wpr.Transport.Reconnect(ctx)
+	if err == nil {
+		err = wpr.Transport.Subscribe(ctx, wpr.channels()...)
+	}
+	if err == nil && len(patterns) > 0 {
+		err = wpr.Transport.PSubscribe(ctx, patterns...)
+	}
+	if err != nil {
+		wpr.disconnected(err)
+		return
+	}
+	if rt, ok := wpr.Transport.(*RedisTransport); ok {
+		wpr.mutex.Lock()
+		wpr.PubSub = rt.pubsub()
+		wpr.mutex.Unlock()
+	}
+	wpr.setState(CONN_CONNECTED, nil)
+
+	wpr.JoinGroups(ctx) // bus may be restarted with empty storage
+	if wpr.Name != MASTER && wpr.Name != SENDER {
+		wpr.SendToService(MASTER, STATUS, LAUNCHED) // master may be restarted and not know service
+	}
+	wpr.flush(ctx)
+	return
+}
+
+// enqueue keep message in outbox until connection restored
+func (wpr *Wrapper) enqueue(channel string, msg *RedisMessage) (err error) {
+	wpr.conn.Lock()
+	defer wpr.conn.Unlock()
+	if len(wpr.conn.outbox) >= wpr.OutboxSize {
+		if wpr.OutboxPolicy == DROP_NEWEST || wpr.OutboxSize < 1 {
+			err = fmt.Errorf("outbox is full; message %s to %s dropped", msg.Key, channel)
+			return
+		}
+		dropped := wpr.conn.outbox[0]
+		wpr.conn.outbox = wpr.conn.outbox[1:]
+		sl.L.Warning("[%s] outbox is full; message %s to %s dropped", wpr.Name, dropped.msg.Key, dropped.channel)
+	}
+	wpr.conn.outbox = append(wpr.conn.outbox, outMessage{channel: channel, msg: msg})
+	return
+}
+
+// flush send messages of outbox in order of enqueue
+func (wpr *Wrapper) flush(ctx context.Context) {
+	for {
+		wpr.conn.Lock()
+		if len(wpr.conn.outbox) == 0 || wpr.conn.state != CONN_CONNECTED {
+			wpr.conn.Unlock()
+			return
+		}
+		out := wpr.conn.outbox[0]
+		wpr.conn.outbox = wpr.conn.outbox[1:]
+		wpr.conn.Unlock()
+
+		if out.msg.Expired() {
+			continue
+		}
+		_, err := wpr.publish(ctx, out.channel, out.msg)
+		if err != nil {
+			wpr.conn.Lock()
+			wpr.conn.outbox = append([]outMessage{out}, wpr.conn.outbox...)
+			wpr.conn.Unlock()
+			wpr.disconnected(err)
+			return
+		}
+	}
+}
+
+// Outbox return count of messages waiting connection
+func (wpr *Wrapper) Outbox() int {
+	wpr.conn.Lock()
+	defer wpr.conn.Unlock()
+	return len(wpr.conn.outbox)
+}
@@ -0,0 +1,174 @@
+package wrapper
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// PROTOCOL_VERSION of envelope: major changed on incompatible changes;
+	// message without version is legacy envelope {s, k, v} and treated as 1.0; 1.2 - binary envelope
+	PROTOCOL_VERSION string = "1.2"
+	PROTOCOL_MAJOR   int    = 1
+
+	BINARY_ENVELOPE byte = 0x01 // first byte of envelope: uvarint length and JSON header, then value encoded by codec
+
+	HEADER_IN_REPLY_TO string = "in-reply-to" // id of request message in reply
+)
+
+// MessageOption change envelope of sending message
+type MessageOption func(msg *RedisMessage)
+
+// WithTTL message dropped by receiver after ttl
+func WithTTL(ttl time.Duration) MessageOption {
+	return func(msg *RedisMessage) {
+		msg.ExpiresAt = time.Now().Add(ttl).UnixMilli()
+	}
+}
+
+// WithReplyTo channel for answer on message
+func WithReplyTo(channel string) MessageOption {
+	return func(msg *RedisMessage) {
+		msg.ReplyTo = channel
+	}
+}
+
+// WithHeader add header to message
+func WithHeader(name, value string) MessageOption {
+	return func(msg *RedisMessage) {
+		if msg.Headers == nil {
+			msg.Headers = map[string]string{}
+		}
+		msg.Headers[name] = value
+	}
+}
+
+// NewMessageID return random id of message
+func NewMessageID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Created return time of message creation; zero for legacy message
+func (m *RedisMessage) Created() time.Time {
+	if m.CreatedAt == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(m.CreatedAt)
+}
+
+// Expired message must be dropped
+func (m *RedisMessage) Expired() bool {
+	return m.ExpiresAt > 0 && time.Now().UnixMilli() > m.ExpiresAt
+}
+
+// Major return major protocol version of message
+func (m *RedisMessage) Major() (major int, err error) {
+	if m.Version == "" {
+		return 1, nil
+	}
+	major, err = strconv.Atoi(strings.SplitN(m.Version, ".", 2)[0])
+	if err != nil {
+		err = fmt.Errorf("wrong protocol version \"%s\"", m.Version)
+	}
+	return
+}
+
+// Header return header of message
+func (m *RedisMessage) Header(name string) string {
+	return m.Headers[name]
+}
+
+// Reply send answer to ReplyTo channel of message (or to its sender)
+func (wpr *Wrapper) Reply(msg *RedisMessage, key string, value any, opts ...MessageOption) (err error) {
+	channel := msg.ReplyTo
+	if channel == "" {
+		channel = msg.Sender
+	}
+	opts = append(opts, WithHeader(HEADER_IN_REPLY_TO, msg.ID))
+	return wpr.SendToService(channel, key, value, opts...)
+}
+
+// Request send message to channel and wait answer sent by Reply; without deadline in ctx wait DEFAULT_REQUEST_TIMEOUT
+func (wpr *Wrapper) Request(ctx context.Context, channel, key string, value any, opts ...MessageOption) (reply *RedisMessage, err error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DEFAULT_REQUEST_TIMEOUT)
+		defer cancel()
+	}
+	channel = strings.ToUpper(channel)
+	inbox := INBOX_PREFIX + strings.ToUpper(NewMessageID()) // Reply send to channel in uppercase
+
+	var msg *RedisMessage
+	msg, err = wpr.NewMessage(key, value, append(opts, WithReplyTo(inbox))...)
+	if err != nil {
+		return
+	}
+	wpr.signFor(channel, msg)
+	var payload, answer []byte
+	payload, err = msg.MarshalBinary()
+	if err != nil {
+		return
+	}
+	answer, err = wpr.Transport.Request(ctx, channel, inbox, payload)
+	if err != nil {
+		err = fmt.Errorf("request %s to %s: %s", key, channel, err.Error())
+		return
+	}
+
+	reply = &RedisMessage{Channel: inbox}
+	err = reply.UnmarshalBinary(answer)
+	if err == nil {
+		err = reply.checkEnvelope()
+	}
+	if err != nil {
+		reply = nil
+	}
+	return
+}
+
+// checkEnvelope reject expired messages and messages of unknown major version
+func (m *RedisMessage) checkEnvelope() (err error) {
+	var major int
+	major, err = m.Major()
+	if err != nil {
+		return
+	}
+	if major != PROTOCOL_MAJOR {
+		err = fmt.Errorf("unsupported protocol version %s of message %s from %s", m.Version, m.ID, m.Sender)
+		return
+	}
+	if m.Expired() {
+		err = fmt.Errorf("message %s from %s expired at %s", m.ID, m.Sender, time.UnixMilli(m.ExpiresAt).Format(time.DateTime))
+	}
+	return
+}
+
+// Resend publish copy of message (as recorded by monitor) to channel on behalf of this wrapper:
+// id, time and signature are new; key, value, codec data and headers are kept
+func (wpr *Wrapper) Resend(channel string, orig *RedisMessage) (err error) {
+	channel = strings.ToUpper(channel)
+	msg := &RedisMessage{
+		Sender:      wpr.Name,
+		Key:         orig.Key,
+		ContentType: orig.ContentType,
+		Data:        orig.Data,
+		ID:          NewMessageID(),
+		Version:     PROTOCOL_VERSION,
+		CreatedAt:   time.Now().UnixMilli(),
+		Headers:     orig.Headers,
+	}
+	if orig.raw != nil { // keep exactly recorded bytes of value
+		msg.raw = orig.raw
+		msg.Value = orig.raw
+	}
+	wpr.signFor(channel, msg)
+	_, err = wpr.send(context.Background(), channel, msg)
+	return
+}
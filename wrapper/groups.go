@@ -0,0 +1,309 @@
+package wrapper
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	sl "github.com/Averianov/cisystemlog"
+)
+
+const (
+	TAGS string = "TAGS" // env with comma separated tags of service
+
+	BROADCAST        string = "BROADCAST" // channel listened by all services
+	TAG_PREFIX       string = "TAG."      // channel of tag group: TAG.<TAG>
+	GROUP_KEY_PREFIX string = "ci:group:" // redis set with members of group
+	LOAD_KEY         string = "ci:load"   // redis hash with count of messages in processing by service
+
+	BALANCE_ROUND_ROBIN  string = "round-robin"
+	BALANCE_LEAST_LOADED string = "least-loaded"
+)
+
+// RadioKatTopic receive messages from topics subscribed by Subscribe; if nil - messages go to RadioKat
+var RadioKatTopic func(topic, sender, key string, value any)
+
+// TagChannel return channel name of tag group
+func TagChannel(tag string) string {
+	return TAG_PREFIX + strings.ToUpper(tag)
+}
+
+// groups return all groups of service: tags and replicas group
+func (wpr *Wrapper) groups() (groups []string) {
+	groups = append(groups, wpr.Tags...)
+	if wpr.Group != "" {
+		groups = append(groups, wpr.Group)
+	}
+	return
+}
+
+// channels return all channels which service must listen
+func (wpr *Wrapper) channels() (channels []string) {
+	channels = append(channels, wpr.Name)
+	if wpr.Name == MASTER || wpr.Name == SENDER {
+		return
+	}
+	channels = append(channels, BROADCAST)
+	for _, tag := range wpr.Tags {
+		channels = append(channels, TagChannel(tag))
+	}
+	return
+}
+
+// JoinGroups register service as member of own groups for anycast
+func (wpr *Wrapper) JoinGroups(ctx context.Context) (err error) {
+	if wpr.RClient == nil { // groups kept only in Redis
+		return
+	}
+	for _, group := range wpr.groups() {
+		err = wpr.RClient.SAdd(ctx, GROUP_KEY_PREFIX+group, wpr.Name).Err()
+		if err != nil {
+			sl.L.Warning("[%s] join group %s err: %s", wpr.Name, group, err.Error())
+			return
+		}
+	}
+	return
+}
+
+// LeaveGroups remove service from own groups
+func (wpr *Wrapper) LeaveGroups(ctx context.Context) (err error) {
+	if wpr.RClient == nil {
+		return
+	}
+	for _, group := range wpr.groups() {
+		err = wpr.RClient.SRem(ctx, GROUP_KEY_PREFIX+group, wpr.Name).Err()
+		if err != nil {
+			sl.L.Warning("[%s] leave group %s err: %s", wpr.Name, group, err.Error())
+		}
+	}
+	wpr.RClient.HDel(ctx, LOAD_KEY, wpr.Name)
+	return
+}
+
+// Broadcast send message to all services
+func (wpr *Wrapper) Broadcast(key string, value any, opts ...MessageOption) (err error) {
+	return wpr.Publish(BROADCAST, key, value, opts...)
+}
+
+// SendToTag send message to all services with tag
+func (wpr *Wrapper) SendToTag(tag, key string, value any, opts ...MessageOption) (err error) {
+	return wpr.Publish(TagChannel(tag), key, value, opts...)
+}
+
+// SendToAny send message to one alive member of group (tag or replicas group)
+func (wpr *Wrapper) SendToAny(group, key string, value any, balance string, opts ...MessageOption) (err error) {
+	var member string
+	member, err = wpr.PickMember(context.Background(), group, balance)
+	if err != nil {
+		sl.L.Warning("[%s] %s", wpr.Name, err.Error())
+		return
+	}
+	return wpr.SendToService(member, key, value, opts...)
+}
+
+// PickMember choose alive member of group by balance mode
+func (wpr *Wrapper) PickMember(ctx context.Context, group, balance string) (member string, err error) {
+	group = strings.ToUpper(group)
+	if wpr.RClient == nil {
+		err = fmt.Errorf("groups not supported by transport %T", wpr.Transport)
+		return
+	}
+	var members []string
+	members, err = wpr.RClient.SMembers(ctx, GROUP_KEY_PREFIX+group).Result()
+	if err != nil {
+		return
+	}
+
+	var alive []string
+	if len(members) > 0 {
+		var subs map[string]int64
+		subs, err = wpr.Transport.NumSub(ctx, members...)
+		if err != nil {
+			return
+		}
+		for _, m := range members {
+			if subs[m] > 0 {
+				alive = append(alive, m)
+			}
+		}
+	}
+	if len(alive) == 0 {
+		err = fmt.Errorf("no alive members in group %s", group)
+		return
+	}
+	sort.Strings(alive)
+
+	switch balance {
+	case BALANCE_LEAST_LOADED:
+		var loads []any
+		loads, err = wpr.RClient.HMGet(ctx, LOAD_KEY, alive...).Result()
+		if err != nil {
+			return
+		}
+		min := int64(-1)
+		for i, l := range loads {
+			var load int64
+			if str, ok := l.(string); ok {
+				fmt.Sscan(str, &load)
+			}
+			if min < 0 || load < min {
+				min, member = load, alive[i]
+			}
+		}
+	default:
+		wpr.rrMutex.Lock()
+		member = alive[wpr.rrIndex[GROUP_KEY_PREFIX+group]%len(alive)]
+		wpr.rrIndex[GROUP_KEY_PREFIX+group]++
+		wpr.rrMutex.Unlock()
+	}
+	return
+}
+
+// Publish send message to channel or topic as is (without changing case)
+func (wpr *Wrapper) Publish(topic, key string, value any, opts ...MessageOption) (err error) {
+	sl.L.Debug("[%s] Publish to %s: %s-%v", wpr.Name, topic, key, value)
+	var msg *RedisMessage
+	msg, err = wpr.NewMessage(key, value, opts...)
+	if err == nil {
+		wpr.signFor(topic, msg)
+		_, err = wpr.send(context.Background(), topic, msg)
+	}
+	if err != nil {
+		sl.L.Warning("[%s] %s", wpr.Name, err.Error())
+	}
+	return
+}
+
+// Subscribe listen topics by patterns with wildcards, as "events.*"
+func (wpr *Wrapper) Subscribe(patterns ...string) (err error) {
+	wpr.conn.Lock()
+	for _, p := range patterns {
+		wpr.conn.patterns[p] = true
+	}
+	wpr.conn.Unlock()
+	if !wpr.Connected() { // subscribed on reconnect
+		return
+	}
+	err = wpr.Transport.PSubscribe(context.Background(), patterns...)
+	if err != nil {
+		sl.L.Warning("[%s] %s", wpr.Name, err.Error())
+	}
+	return
+}
+
+// Unsubscribe stop listening topics by patterns
+func (wpr *Wrapper) Unsubscribe(patterns ...string) (err error) {
+	wpr.conn.Lock()
+	for _, p := range patterns {
+		delete(wpr.conn.patterns, p)
+	}
+	wpr.conn.Unlock()
+	if !wpr.Connected() {
+		return
+	}
+	err = wpr.Transport.PUnsubscribe(context.Background(), patterns...)
+	if err != nil {
+		sl.L.Warning("[%s] %s", wpr.Name, err.Error())
+	}
+	return
+}
+
+// MatchPattern match channel by pattern of PSUBSCRIBE as Redis does: "*" - any chars (also "." and "/"),
+// "?" - one char, "[abc]", "[^a]" and "[a-z]" - class of chars, "\" escape next char
+func MatchPattern(pattern, channel string) bool {
+	p, c := 0, 0
+	star, starC := -1, 0 // last "*" of pattern and position in channel where it started
+	for c < len(channel) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				star, starC = p, c
+				p++
+				continue
+			case '?':
+				p++
+				c++
+				continue
+			case '[':
+				if end, ok := matchClass(pattern[p:], channel[c]); end > 0 {
+					if ok {
+						p += end
+						c++
+						continue
+					}
+				} else if channel[c] == '[' { // not closed class is char
+					p++
+					c++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) && pattern[p+1] == channel[c] {
+					p += 2
+					c++
+					continue
+				}
+			default:
+				if pattern[p] == channel[c] {
+					p++
+					c++
+					continue
+				}
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		starC++ // "*" take one char more
+		p, c = star+1, starC
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass match char by class "[...]" at start of pattern; end is length of class, 0 if it is not closed
+func matchClass(pattern string, ch byte) (end int, ok bool) {
+	i := 1
+	not := i < len(pattern) && pattern[i] == '^'
+	if not {
+		i++
+	}
+	for ; i < len(pattern) && pattern[i] != ']'; i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			ok = ok || pattern[i] == ch
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			lo, hi := pattern[i], pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			ok = ok || (lo <= ch && ch <= hi)
+			i += 2
+		default:
+			ok = ok || pattern[i] == ch
+		}
+	}
+	if i >= len(pattern) {
+		return 0, false
+	}
+	return i + 1, ok != not
+}
+
+// trackLoad change count of messages in processing for least-loaded balancing
+func (wpr *Wrapper) trackLoad(delta int64) {
+	if len(wpr.groups()) == 0 || wpr.RClient == nil {
+		return
+	}
+	wpr.RClient.HIncrBy(context.Background(), LOAD_KEY, wpr.Name, delta)
+}
+
+func RunRadioKatTopic(topic, sender, key string, value any) {
+	if RadioKatTopic != nil {
+		RadioKatTopic(topic, sender, key, value)
+		return
+	}
+	RunRadioKat(sender, key, value)
+}
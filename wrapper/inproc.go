@@ -0,0 +1,196 @@
+package wrapper
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const INPROC_BUFFER int = 1024 // messages waiting in connection; on overflow new messages dropped
+
+// InprocBus is bus inside one process for goroutine tasks and tests; every wrapper got own connection
+type InprocBus struct {
+	sync.RWMutex
+	conns map[*InprocConn]struct{}
+}
+
+// InprocConn is connection of one wrapper to InprocBus
+type InprocConn struct {
+	bus      *InprocBus
+	mutex    sync.RWMutex
+	channels map[string]bool
+	patterns map[string]bool
+	queue    chan inprocMessage
+	closed   chan struct{}
+	once     sync.Once
+}
+
+type inprocMessage struct {
+	channel string
+	pattern string // matched pattern of subscription; empty for channel subscribed directly
+	payload []byte
+}
+
+func NewInprocBus() *InprocBus {
+	return &InprocBus{conns: map[*InprocConn]struct{}{}}
+}
+
+// Connect return new connection to bus
+func (b *InprocBus) Connect() *InprocConn {
+	c := &InprocConn{
+		bus:      b,
+		channels: map[string]bool{},
+		patterns: map[string]bool{},
+		queue:    make(chan inprocMessage, INPROC_BUFFER),
+		closed:   make(chan struct{}),
+	}
+	b.Lock()
+	b.conns[c] = struct{}{}
+	b.Unlock()
+	return c
+}
+
+// listen connection subscribed to channel directly or by pattern; pattern empty for direct subscription
+func (c *InprocConn) listen(channel string) (pattern string, ok bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return subscribed(c.channels, c.patterns, channel)
+}
+
+func (c *InprocConn) Publish(ctx context.Context, channel string, payload []byte) (receivers int64, err error) {
+	c.bus.RLock()
+	defer c.bus.RUnlock()
+	for conn := range c.bus.conns {
+		pattern, ok := conn.listen(channel)
+		if !ok {
+			continue
+		}
+		select {
+		case conn.queue <- inprocMessage{channel: channel, pattern: pattern, payload: payload}:
+			receivers++
+		default: // slow receiver; as Redis drop output of client which not read
+		}
+	}
+	return
+}
+
+func (c *InprocConn) Subscribe(ctx context.Context, channels ...string) error {
+	c.mutex.Lock()
+	for _, ch := range channels {
+		c.channels[ch] = true
+	}
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *InprocConn) PSubscribe(ctx context.Context, patterns ...string) error {
+	c.mutex.Lock()
+	for _, p := range patterns {
+		c.patterns[p] = true
+	}
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *InprocConn) PUnsubscribe(ctx context.Context, patterns ...string) error {
+	c.mutex.Lock()
+	for _, p := range patterns {
+		delete(c.patterns, p)
+	}
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *InprocConn) Receive(ctx context.Context) (channel, pattern string, payload []byte, err error) {
+	select {
+	case msg := <-c.queue:
+		return msg.channel, msg.pattern, msg.payload, nil
+	case <-c.closed:
+		err = fmt.Errorf("%s", "connection closed")
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+func (c *InprocConn) Request(ctx context.Context, channel, inbox string, payload []byte) (reply []byte, err error) {
+	answer := c.bus.Connect()
+	defer answer.Close()
+	answer.Subscribe(ctx, inbox)
+
+	var receivers int64
+	receivers, err = c.Publish(ctx, channel, payload)
+	if err != nil {
+		return
+	}
+	if receivers == 0 {
+		err = fmt.Errorf("nobody listen channel %s", channel)
+		return
+	}
+	_, _, reply, err = answer.Receive(ctx)
+	return
+}
+
+func (c *InprocConn) Channels(ctx context.Context, pattern string) (channels []string, err error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+	found := map[string]bool{}
+	c.bus.RLock()
+	for conn := range c.bus.conns {
+		conn.mutex.RLock()
+		for ch := range conn.channels {
+			if MatchPattern(pattern, ch) {
+				found[ch] = true
+			}
+		}
+		conn.mutex.RUnlock()
+	}
+	c.bus.RUnlock()
+	for ch := range found {
+		channels = append(channels, ch)
+	}
+	sort.Strings(channels)
+	return
+}
+
+func (c *InprocConn) NumSub(ctx context.Context, channels ...string) (subs map[string]int64, err error) {
+	subs = make(map[string]int64, len(channels))
+	c.bus.RLock()
+	for conn := range c.bus.conns {
+		conn.mutex.RLock()
+		for _, ch := range channels {
+			if conn.channels[ch] {
+				subs[ch]++
+			}
+		}
+		conn.mutex.RUnlock()
+	}
+	c.bus.RUnlock()
+	return
+}
+
+func (c *InprocConn) Ping(ctx context.Context) error {
+	select {
+	case <-c.closed:
+		return fmt.Errorf("%s", "connection closed")
+	default:
+		return nil
+	}
+}
+
+// Reconnect of in-process connection possible only while it not closed
+func (c *InprocConn) Reconnect(ctx context.Context) error {
+	return c.Ping(ctx)
+}
+
+func (c *InprocConn) Close() error {
+	c.once.Do(func() {
+		c.bus.Lock()
+		delete(c.bus.conns, c)
+		c.bus.Unlock()
+		close(c.closed)
+	})
+	return nil
+}
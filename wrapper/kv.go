@@ -0,0 +1,237 @@
+package wrapper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	sl "github.com/Averianov/cisystemlog"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	STORE_PREFIX    string = "ci:"    // all keys of dispatcher and wrapper in redis
+	KV_PREFIX       string = "ci:kv:" // redis key of value: ci:kv:<NAMESPACE>:<key>
+	KV_GLOBAL       string = "GLOBAL" // namespace shared by all services
+	KV_TOPIC_PREFIX string = "KV."    // topic of changes: KV.<NAMESPACE>.<key>
+	KV_CHANGED      string = "KV_CHANGED"
+)
+
+// scripts of wrapper; bus run for services only these scripts
+var scripts = map[string]bool{}
+
+// registerScript add lua script to known scripts
+func registerScript(src string) *redis.Script {
+	script := redis.NewScript(src)
+	scripts[script.Hash()] = true
+	return script
+}
+
+// KnownScript is script of wrapper by its sha1
+func KnownScript(sha string) bool {
+	return scripts[strings.ToLower(sha)]
+}
+
+// casScript set value only if current value equal expected; empty expected mean absent key
+var casScript = registerScript(`
+local cur = redis.call('GET', KEYS[1])
+if (ARGV[1] == '' and cur) or (ARGV[1] ~= '' and cur ~= ARGV[1]) then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+else
+	redis.call('SET', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// KVChange is notification of Watch
+type KVChange struct {
+	Namespace string `json:"ns"`
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Deleted   bool   `json:"deleted"`
+}
+
+// KV is namespace of shared key-value store; service may change only own and global namespaces
+type KV struct {
+	wpr       *Wrapper
+	Namespace string
+}
+
+// KV return own namespace of service
+func (wpr *Wrapper) KV() *KV {
+	return wpr.Namespace(wpr.Name)
+}
+
+// GlobalKV return namespace shared by all services
+func (wpr *Wrapper) GlobalKV() *KV {
+	return wpr.Namespace(KV_GLOBAL)
+}
+
+// Namespace return namespace of store by name, as namespace of other service for reading
+func (wpr *Wrapper) Namespace(ns string) *KV {
+	return &KV{wpr: wpr, Namespace: strings.ToUpper(ns)}
+}
+
+// KVKey return redis key of value
+func KVKey(ns, key string) string {
+	return KV_PREFIX + strings.ToUpper(ns) + ":" + key
+}
+
+// KVTopic return topic of changes of key; key may be glob pattern
+func KVTopic(ns, key string) string {
+	return KV_TOPIC_PREFIX + strings.ToUpper(ns) + "." + key
+}
+
+func (kv *KV) client() (client *redis.Client, err error) {
+	if kv.wpr.RClient == nil {
+		err = fmt.Errorf("key-value store not supported by transport %T", kv.wpr.Transport)
+	}
+	return kv.wpr.RClient, err
+}
+
+// Get return value of key; ok is false for absent key
+func (kv *KV) Get(ctx context.Context, key string) (value string, ok bool, err error) {
+	var client *redis.Client
+	client, err = kv.client()
+	if err != nil {
+		return
+	}
+	value, err = client.Get(ctx, KVKey(kv.Namespace, key)).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	return value, err == nil, err
+}
+
+// Set value of key; ttl 0 - without expiration
+func (kv *KV) Set(ctx context.Context, key, value string, ttl time.Duration) (err error) {
+	var client *redis.Client
+	client, err = kv.client()
+	if err != nil {
+		return
+	}
+	err = client.Set(ctx, KVKey(kv.Namespace, key), value, ttl).Err()
+	if err == nil {
+		kv.notify(key, value, false)
+	}
+	return
+}
+
+// Delete key
+func (kv *KV) Delete(ctx context.Context, key string) (err error) {
+	var client *redis.Client
+	client, err = kv.client()
+	if err != nil {
+		return
+	}
+	err = client.Del(ctx, KVKey(kv.Namespace, key)).Err()
+	if err == nil {
+		kv.notify(key, "", true)
+	}
+	return
+}
+
+// CompareAndSwap set new value only if current value is old; empty old mean key must be absent
+func (kv *KV) CompareAndSwap(ctx context.Context, key, old, new string, ttl time.Duration) (swapped bool, err error) {
+	var client *redis.Client
+	client, err = kv.client()
+	if err != nil {
+		return
+	}
+	var res int64
+	res, err = casScript.Run(ctx, client, []string{KVKey(kv.Namespace, key)}, old, new, ttl.Milliseconds()).Int64()
+	swapped = err == nil && res == 1
+	if swapped {
+		kv.notify(key, new, false)
+	}
+	return
+}
+
+// Incr add delta to counter and return new value
+func (kv *KV) Incr(ctx context.Context, key string, delta int64) (value int64, err error) {
+	var client *redis.Client
+	client, err = kv.client()
+	if err != nil {
+		return
+	}
+	value, err = client.IncrBy(ctx, KVKey(kv.Namespace, key), delta).Result()
+	if err == nil {
+		kv.notify(key, fmt.Sprint(value), false)
+	}
+	return
+}
+
+// Keys return keys of namespace by glob pattern
+func (kv *KV) Keys(ctx context.Context, pattern string) (keys []string, err error) {
+	var client *redis.Client
+	client, err = kv.client()
+	if err != nil {
+		return
+	}
+	prefix := KVKey(kv.Namespace, "")
+	iter := client.Scan(ctx, 0, prefix+pattern, 0).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, strings.TrimPrefix(iter.Val(), prefix))
+	}
+	err = iter.Err()
+	return
+}
+
+// Watch call fn on changes of keys by glob pattern made through KV API; expiration by ttl not notified.
+// Callbacks of several Watch with same pattern are called in order of Watch
+func (kv *KV) Watch(pattern string, fn func(change KVChange)) (err error) {
+	topic := KVTopic(kv.Namespace, pattern)
+	kv.wpr.mutex.Lock()
+	if kv.wpr.watchers == nil {
+		kv.wpr.watchers = map[string][]func(change KVChange){}
+	}
+	kv.wpr.watchers[topic] = append(kv.wpr.watchers[topic], fn)
+	kv.wpr.mutex.Unlock()
+	return kv.wpr.Subscribe(topic)
+}
+
+// Unwatch stop notifications of all Watch with same pattern
+func (kv *KV) Unwatch(pattern string) (err error) {
+	topic := KVTopic(kv.Namespace, pattern)
+	kv.wpr.mutex.Lock()
+	delete(kv.wpr.watchers, topic)
+	kv.wpr.mutex.Unlock()
+	return kv.wpr.Unsubscribe(topic)
+}
+
+func (kv *KV) notify(key, value string, deleted bool) {
+	change := KVChange{Namespace: kv.Namespace, Key: key, Value: value, Deleted: deleted}
+	if err := kv.wpr.Publish(KVTopic(kv.Namespace, key), KV_CHANGED, change); err != nil {
+		sl.L.Warning("[%s] notify change of %s err: %s", kv.wpr.Name, key, err.Error())
+	}
+}
+
+// watched pass change of store to watchers of topic; return false if nobody watch it
+// (message handled as ordinary one, as by monitor of sender)
+func (wpr *Wrapper) watched(channel string, msg *RedisMessage) bool {
+	if msg.Key != KV_CHANGED || !strings.HasPrefix(channel, KV_TOPIC_PREFIX) {
+		return false
+	}
+	change, err := Decode[KVChange](msg)
+	if err != nil {
+		sl.L.Warning("[%s] wrong change of store: %s", wpr.Name, err.Error())
+		return true
+	}
+
+	wpr.mutex.RLock()
+	var fns []func(change KVChange)
+	for topic, callbacks := range wpr.watchers {
+		if MatchPattern(topic, channel) {
+			fns = append(fns, callbacks...)
+		}
+	}
+	wpr.mutex.RUnlock()
+	for _, fn := range fns {
+		fn(change)
+	}
+	return len(fns) > 0
+}
@@ -0,0 +1,104 @@
+package wrapper
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// TestKVWatchSeveral every Watch of same pattern get change
+func TestKVWatchSeveral(t *testing.T) {
+	t.Chdir(t.TempDir())
+	mr := miniredis.RunT(t)
+	a := testWrapper(t, mr, "A")
+	b := testWrapper(t, mr, "B")
+
+	var first, second atomic.Int64
+	flags := b.GlobalKV()
+	if err := flags.Watch("feature.*", func(c KVChange) { first.Add(1) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := flags.Watch("feature.*", func(c KVChange) { second.Add(1) }); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // subscription in listener
+
+	if err := a.GlobalKV().Set(context.Background(), "feature.x", "on", 0); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return first.Load() == 1 && second.Load() == 1 })
+
+	flags.Unwatch("feature.*")
+	time.Sleep(100 * time.Millisecond)
+	a.GlobalKV().Set(context.Background(), "feature.x", "off", 0)
+	time.Sleep(200 * time.Millisecond)
+	if first.Load() != 1 || second.Load() != 1 {
+		t.Fatal("called after Unwatch", first.Load(), second.Load())
+	}
+}
+
+// TestKVWatchNested pattern of Watch match nested keys as glob of Redis
+func TestKVWatchNested(t *testing.T) {
+	t.Chdir(t.TempDir())
+	mr := miniredis.RunT(t)
+	a := testWrapper(t, mr, "A")
+	b := testWrapper(t, mr, "B")
+
+	got := make(chan string, 10)
+	if err := b.GlobalKV().Watch("cfg/*", func(c KVChange) { got <- c.Key }); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // subscription in listener
+
+	for _, key := range []string{"cfg/a/b", "other/a"} {
+		if err := a.GlobalKV().Set(context.Background(), key, "1", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case key := <-got:
+		if key != "cfg/a/b" {
+			t.Fatal("got change of", key)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("change of nested key not watched")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(got) != 0 {
+		t.Fatal("got change of", <-got)
+	}
+}
+
+// TestMatchPattern glob of PSUBSCRIBE as in Redis
+func TestMatchPattern(t *testing.T) {
+	for _, c := range []struct {
+		pattern, channel string
+		match            bool
+	}{
+		{"cfg/*", "cfg/a/b", true},
+		{"KV.A.*", "KV.A.x.y", true},
+		{"*", "", true},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXbYbZ", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[c-a]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"h[llo", "h[llo", true},
+		{"events.*", "events", false},
+	} {
+		if got := MatchPattern(c.pattern, c.channel); got != c.match {
+			t.Errorf("%s %s: %v", c.pattern, c.channel, got)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package wrapper
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	sl "github.com/Averianov/cisystemlog"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	LOCK_PREFIX   string = "ci:lock:"  // redis key of lock with value "<OWNER>:<FENCE>"
+	FENCE_PREFIX  string = "ci:fence:" // counter of fencing tokens of lock
+	OWNERS_PREFIX string = "ci:locks:" // set of locks held by service; released by dispatcher on its death
+
+	LOCK_RETRY_MIN time.Duration = 50 * time.Millisecond
+	LOCK_RETRY_MAX time.Duration = time.Second
+)
+
+var (
+	lockAcquire = registerScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+local fence = redis.call('INCR', KEYS[2])
+redis.call('SET', KEYS[1], ARGV[1] .. ':' .. fence, 'PX', ARGV[2])
+redis.call('SADD', KEYS[3], ARGV[3])
+return fence
+`)
+	lockRenew = registerScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+	lockRelease = registerScript(`
+local res = 0
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	res = redis.call('DEL', KEYS[1])
+end
+redis.call('SREM', KEYS[2], ARGV[2])
+return res
+`)
+	lockReleaseAll = registerScript(`
+local released = 0
+for _, name in ipairs(redis.call('SMEMBERS', KEYS[1])) do
+	local key = ARGV[2] .. name
+	local value = redis.call('GET', key)
+	if value and string.sub(value, 1, #ARGV[1] + 1) == ARGV[1] .. ':' then
+		released = released + redis.call('DEL', key)
+	end
+end
+redis.call('DEL', KEYS[1])
+return released
+`)
+)
+
+// Lock is distributed lock held by service; renewed automatically until Unlock
+type Lock struct {
+	wpr   *Wrapper
+	Name  string
+	Fence int64 // fencing token: grows with every acquire of lock; pass it to protected resource
+	value string
+	ttl   time.Duration
+
+	stop     chan struct{}
+	lost     chan struct{}
+	stopOnce sync.Once
+	lostOnce sync.Once
+}
+
+// Lock wait distributed lock by name until acquired or ctx done; ttl is lifetime of lock without renewal
+func (wpr *Wrapper) Lock(ctx context.Context, name string, ttl time.Duration) (lock *Lock, err error) {
+	for attempt := 0; ; attempt++ {
+		var ok bool
+		lock, ok, err = wpr.TryLock(ctx, name, ttl)
+		if err != nil || ok {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			err = fmt.Errorf("lock %s: %s", name, ctx.Err().Error())
+			return
+		case <-time.After(Backoff(attempt, LOCK_RETRY_MIN, LOCK_RETRY_MAX)):
+		}
+	}
+}
+
+// TryLock acquire distributed lock by name once; ok is false when lock held by other
+func (wpr *Wrapper) TryLock(ctx context.Context, name string, ttl time.Duration) (lock *Lock, ok bool, err error) {
+	if wpr.RClient == nil {
+		err = fmt.Errorf("locks not supported by transport %T", wpr.Transport)
+		return
+	}
+	if ttl < 3*time.Millisecond {
+		err = fmt.Errorf("lock %s: too small ttl %s", name, ttl)
+		return
+	}
+	var fence int64
+	fence, err = lockAcquire.Run(ctx, wpr.RClient,
+		[]string{LOCK_PREFIX + name, FENCE_PREFIX + name, OWNERS_PREFIX + wpr.Name},
+		wpr.Name, ttl.Milliseconds(), name).Int64()
+	if err != nil || fence == 0 {
+		return
+	}
+
+	lock = &Lock{
+		wpr:   wpr,
+		Name:  name,
+		Fence: fence,
+		value: fmt.Sprintf("%s:%d", wpr.Name, fence),
+		ttl:   ttl,
+		stop:  make(chan struct{}),
+		lost:  make(chan struct{}),
+	}
+	go lock.renew()
+	sl.L.Debug("[%s] lock %s acquired with fence %d", wpr.Name, name, fence)
+	return lock, true, nil
+}
+
+// renew extend lock every third of ttl; lock lost when it expired or taken by other
+func (l *Lock) renew() {
+	tick := time.NewTicker(l.ttl / 3)
+	defer tick.Stop()
+	deadline := time.Now().Add(l.ttl)
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-tick.C:
+			ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3)
+			res, err := lockRenew.Run(ctx, l.wpr.RClient, []string{LOCK_PREFIX + l.Name}, l.value, l.ttl.Milliseconds()).Int64()
+			cancel()
+			switch true {
+			case err == nil && res == 1:
+				deadline = time.Now().Add(l.ttl)
+			case err == nil || time.Now().After(deadline): // taken by other or may be expired while bus not available
+				sl.L.Warning("[%s] lock %s lost", l.wpr.Name, l.Name)
+				l.lostOnce.Do(func() { close(l.lost) })
+				return
+			}
+		}
+	}
+}
+
+// Lost closed when lock can not be renewed; work protected by lock must be stopped
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Unlock stop renewal and release lock
+func (l *Lock) Unlock(ctx context.Context) (err error) {
+	l.stopOnce.Do(func() { close(l.stop) })
+	err = lockRelease.Run(ctx, l.wpr.RClient, []string{LOCK_PREFIX + l.Name, OWNERS_PREFIX + l.wpr.Name}, l.value, l.Name).Err()
+	sl.L.Debug("[%s] lock %s released", l.wpr.Name, l.Name)
+	return
+}
+
+// LockHolder return service which hold lock by name; empty when lock free
+func (wpr *Wrapper) LockHolder(ctx context.Context, name string) (owner string, err error) {
+	if wpr.RClient == nil {
+		err = fmt.Errorf("locks not supported by transport %T", wpr.Transport)
+		return
+	}
+	var value string
+	value, err = wpr.RClient.Get(ctx, LOCK_PREFIX+name).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if i := strings.LastIndex(value, ":"); i > 0 {
+		owner = value[:i]
+	}
+	return
+}
+
+// ReleaseLocks of dead service; called by dispatcher
+func ReleaseLocks(ctx context.Context, client *redis.Client, owner string) (released int64, err error) {
+	return lockReleaseAll.Run(ctx, client, []string{OWNERS_PREFIX + owner}, owner, LOCK_PREFIX).Int64()
+}
+
+// ### Leader election #######################################################
+
+// LeaderElection choose one leader among services which run election with same name
+type LeaderElection struct {
+	wpr  *Wrapper
+	Name string
+	TTL  time.Duration
+
+	OnElected func(ctx context.Context) // service became leader; ctx canceled when leadership lost
+	OnRevoked func()                    // service lost leadership
+	OnLeader  func(leader string)       // leader changed; empty - no leader
+
+	mutex  sync.RWMutex
+	leader string
+	lock   *Lock
+}
+
+// LeaderElection prepare election by name; started by Run
+func (wpr *Wrapper) LeaderElection(name string, ttl time.Duration) *LeaderElection {
+	return &LeaderElection{wpr: wpr, Name: name, TTL: ttl}
+}
+
+// IsLeader service is leader now
+func (le *LeaderElection) IsLeader() bool {
+	le.mutex.RLock()
+	defer le.mutex.RUnlock()
+	return le.lock != nil
+}
+
+// Leader return last known leader
+func (le *LeaderElection) Leader() string {
+	le.mutex.RLock()
+	defer le.mutex.RUnlock()
+	return le.leader
+}
+
+// Run take part in election until ctx done; leadership released on return
+func (le *LeaderElection) Run(ctx context.Context) (err error) {
+	name := "leader:" + le.Name
+	for {
+		lock, ok, lerr := le.wpr.TryLock(ctx, name, le.TTL)
+		if lerr != nil {
+			sl.L.Warning("[%s] election %s err: %s", le.wpr.Name, le.Name, lerr.Error())
+		}
+		if ok {
+			le.elected(ctx, lock)
+		} else if leader, herr := le.wpr.LockHolder(ctx, name); herr == nil {
+			le.observe(leader)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(le.TTL/2 + rand.N(le.TTL/4+1)):
+		}
+	}
+}
+
+// elected hold leadership until lock lost or ctx done
+func (le *LeaderElection) elected(ctx context.Context, lock *Lock) {
+	le.mutex.Lock()
+	le.lock = lock
+	le.mutex.Unlock()
+	le.observe(le.wpr.Name)
+	sl.L.Info("[%s] elected leader of %s with fence %d", le.wpr.Name, le.Name, lock.Fence)
+
+	lctx, cancel := context.WithCancel(ctx)
+	if le.OnElected != nil {
+		go le.OnElected(lctx)
+	}
+	select {
+	case <-ctx.Done():
+	case <-lock.Lost():
+	}
+	cancel()
+
+	le.mutex.Lock()
+	le.lock = nil
+	le.mutex.Unlock()
+	uctx, ucancel := context.WithTimeout(context.Background(), le.TTL)
+	lock.Unlock(uctx)
+	ucancel()
+	sl.L.Info("[%s] revoked leadership of %s", le.wpr.Name, le.Name)
+	if le.OnRevoked != nil {
+		le.OnRevoked()
+	}
+	le.observe("")
+}
+
+func (le *LeaderElection) observe(leader string) {
+	le.mutex.Lock()
+	changed := le.leader != leader
+	le.leader = leader
+	le.mutex.Unlock()
+	if changed && le.OnLeader != nil {
+		le.OnLeader(leader)
+	}
+}
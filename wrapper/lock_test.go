@@ -0,0 +1,101 @@
+package wrapper
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestLockFencingAndRelease(t *testing.T) {
+	t.Chdir(t.TempDir())
+	mr := miniredis.RunT(t)
+	a := testWrapper(t, mr, "A")
+	b := testWrapper(t, mr, "B")
+	ctx := context.Background()
+
+	la, err := a.Lock(ctx, "x", 300*time.Millisecond)
+	if err != nil || la.Fence != 1 {
+		t.Fatal(la, err)
+	}
+	if _, ok, err := b.TryLock(ctx, "x", time.Second); ok || err != nil {
+		t.Fatal("lock taken twice", err)
+	}
+	time.Sleep(time.Second) // renewal of holder keeps lock longer than ttl
+	if holder, _ := b.LockHolder(ctx, "x"); holder != "A" {
+		t.Fatal("holder", holder)
+	}
+
+	got := make(chan *Lock, 1)
+	go func() {
+		lb, err := b.Lock(ctx, "x", time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+		got <- lb
+	}()
+	released, err := ReleaseLocks(ctx, a.RClient, "A") // as dispatcher on death of A
+	if err != nil || released != 1 {
+		t.Fatal(released, err)
+	}
+	select {
+	case <-la.Lost():
+	case <-time.After(2 * time.Second):
+		t.Fatal("lock of dead service not lost")
+	}
+	var lb *Lock
+	select {
+	case lb = <-got:
+	case <-time.After(3 * time.Second):
+		t.Fatal("waiting service not got lock")
+	}
+	if lb.Fence != 2 {
+		t.Fatal("fence", lb.Fence)
+	}
+	if err := la.Unlock(ctx); err != nil { // stale unlock keeps lock of other holder
+		t.Fatal(err)
+	}
+	if holder, _ := a.LockHolder(ctx, "x"); holder != "B" {
+		t.Fatal("holder after stale unlock", holder)
+	}
+	lb.Unlock(ctx)
+	if holder, _ := a.LockHolder(ctx, "x"); holder != "" {
+		t.Fatal("holder after unlock", holder)
+	}
+}
+
+func TestLeaderElection(t *testing.T) {
+	t.Chdir(t.TempDir())
+	mr := miniredis.RunT(t)
+	a := testWrapper(t, mr, "A")
+	b := testWrapper(t, mr, "B")
+	ctx := context.Background()
+
+	revoked := make(chan struct{})
+	ea := a.LeaderElection("job", 300*time.Millisecond)
+	ea.OnRevoked = func() { close(revoked) }
+	eb := b.LeaderElection("job", 300*time.Millisecond)
+
+	actx, cancel := context.WithCancel(ctx)
+	go ea.Run(actx)
+	waitFor(t, 2*time.Second, ea.IsLeader)
+	bctx, bcancel := context.WithCancel(ctx)
+	defer bcancel()
+	go eb.Run(bctx)
+	waitFor(t, 2*time.Second, func() bool { return eb.Leader() == "A" })
+	if eb.IsLeader() {
+		t.Fatal("two leaders")
+	}
+
+	cancel()
+	select {
+	case <-revoked:
+	case <-time.After(2 * time.Second):
+		t.Fatal("not revoked")
+	}
+	waitFor(t, 3*time.Second, eb.IsLeader)
+	if ea.IsLeader() {
+		t.Fatal("two leaders")
+	}
+}
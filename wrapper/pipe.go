@@ -0,0 +1,490 @@
+package wrapper
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sl "github.com/Averianov/cisystemlog"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	PIPE_BUFFER    int = 1024     // messages waiting in pipe connection; on overflow new messages dropped
+	PIPE_MAX_FRAME int = 64 << 20 // longest channel or payload of frame
+
+	PIPE_PEER_WAIT time.Duration = time.Second // wait of first frame of parent for pipe inherited by descriptor
+
+	// operations of frame: message or change of subscriptions of sender
+	PIPE_MESSAGE      byte = 'M'
+	PIPE_SUBSCRIBE    byte = 'S'
+	PIPE_UNSUBSCRIBE  byte = 'X'
+	PIPE_PSUBSCRIBE   byte = 'P'
+	PIPE_PUNSUBSCRIBE byte = 'U'
+)
+
+// PipeTransport is direct connection of two services without broker: socketpair, pair of pipes or
+// net.Conn. Messages go only to other end and only to channels it subscribed; groups, store,
+// locks and queues are not supported
+type PipeTransport struct {
+	rwc      io.ReadWriteCloser
+	writing  sync.Mutex // one frame at time
+	mutex    sync.RWMutex
+	channels map[string]bool // own subscriptions
+	patterns map[string]bool
+	peerCh   map[string]bool // subscriptions of other end
+	peerPt   map[string]bool
+	inboxes  map[string]chan []byte // answers awaited by Request
+	queue    chan inprocMessage
+	closed   chan struct{}
+	once     sync.Once
+	heard    chan struct{} // closed on first frame of other end
+	heardOne sync.Once
+	onPeer   func(op byte, name string) // change of subscriptions of other end, as for bridge to bus
+}
+
+// NewPipeTransport start reading of frames from rwc; rwc closed with transport
+func NewPipeTransport(rwc io.ReadWriteCloser) *PipeTransport {
+	t := newPipeTransport(rwc)
+	go t.read()
+	return t
+}
+
+func newPipeTransport(rwc io.ReadWriteCloser) *PipeTransport {
+	return &PipeTransport{
+		rwc:      rwc,
+		channels: map[string]bool{},
+		patterns: map[string]bool{},
+		peerCh:   map[string]bool{},
+		peerPt:   map[string]bool{},
+		inboxes:  map[string]chan []byte{},
+		queue:    make(chan inprocMessage, PIPE_BUFFER),
+		closed:   make(chan struct{}),
+		heard:    make(chan struct{}),
+	}
+}
+
+// PipePair return two connected ends of pipe inside one process, as for goroutine tasks and tests
+func PipePair() (a, b *PipeTransport) {
+	ca, cb := net.Pipe()
+	return NewPipeTransport(ca), NewPipeTransport(cb)
+}
+
+// write send frame: operation, length and channel, length and payload
+func (t *PipeTransport) write(op byte, channel string, payload []byte) (err error) {
+	frame := make([]byte, 0, 1+2*binary.MaxVarintLen64+len(channel)+len(payload))
+	frame = append(frame, op)
+	frame = binary.AppendUvarint(frame, uint64(len(channel)))
+	frame = append(frame, channel...)
+	frame = binary.AppendUvarint(frame, uint64(len(payload)))
+	frame = append(frame, payload...)
+
+	t.writing.Lock()
+	defer t.writing.Unlock()
+	if err = t.Ping(context.Background()); err != nil {
+		return
+	}
+	_, err = t.rwc.Write(frame)
+	if err != nil {
+		t.Close()
+	}
+	return
+}
+
+// readFrame read one frame written by write
+func readFrame(r *bufio.Reader) (op byte, channel string, payload []byte, err error) {
+	op, err = r.ReadByte()
+	if err != nil {
+		return
+	}
+	var raw [2][]byte
+	for i := range raw {
+		var size uint64
+		size, err = binary.ReadUvarint(r)
+		if err != nil {
+			return
+		}
+		if size > uint64(PIPE_MAX_FRAME) {
+			err = fmt.Errorf("too long frame of pipe: %d bytes", size)
+			return
+		}
+		raw[i] = make([]byte, size)
+		if _, err = io.ReadFull(r, raw[i]); err != nil {
+			return
+		}
+	}
+	return op, string(raw[0]), raw[1], nil
+}
+
+// read pass messages of other end to Receive or Request and follow its subscriptions; close transport on error
+func (t *PipeTransport) read() {
+	defer t.Close()
+	r := bufio.NewReader(t.rwc)
+	for {
+		op, channel, payload, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		t.heardOne.Do(func() { close(t.heard) })
+		t.mutex.Lock()
+		switch op {
+		case PIPE_SUBSCRIBE:
+			t.peerCh[channel] = true
+		case PIPE_UNSUBSCRIBE:
+			delete(t.peerCh, channel)
+		case PIPE_PSUBSCRIBE:
+			t.peerPt[channel] = true
+		case PIPE_PUNSUBSCRIBE:
+			delete(t.peerPt, channel)
+		case PIPE_MESSAGE:
+			if inbox, ok := t.inboxes[channel]; ok {
+				select {
+				case inbox <- payload:
+				default: // answer already got
+				}
+			} else if pattern, ok := subscribed(t.channels, t.patterns, channel); ok {
+				select {
+				case t.queue <- inprocMessage{channel: channel, pattern: pattern, payload: payload}:
+				default: // slow receiver; as Redis drop output of client which not read
+				}
+			}
+		}
+		t.mutex.Unlock()
+		if op != PIPE_MESSAGE && t.onPeer != nil {
+			t.onPeer(op, channel)
+		}
+	}
+}
+
+// waitPeer wait first frame of other end: its subscriptions must be known before first Publish
+func (t *PipeTransport) waitPeer(timeout time.Duration) {
+	select {
+	case <-t.heard:
+	case <-t.closed:
+	case <-time.After(timeout):
+	}
+}
+
+// subscribed channel directly (pattern empty) or by pattern
+func subscribed(channels, patterns map[string]bool, channel string) (pattern string, ok bool) {
+	if channels[channel] {
+		return "", true
+	}
+	for pattern = range patterns {
+		if ok = MatchPattern(pattern, channel); ok {
+			return
+		}
+	}
+	return "", false
+}
+
+func (t *PipeTransport) Publish(ctx context.Context, channel string, payload []byte) (receivers int64, err error) {
+	t.mutex.RLock()
+	_, peer := subscribed(t.peerCh, t.peerPt, channel)
+	t.mutex.RUnlock()
+	if !peer {
+		return
+	}
+	err = t.write(PIPE_MESSAGE, channel, payload)
+	if err != nil {
+		return
+	}
+	return 1, nil
+}
+
+// subscribe change own subscriptions and report them to other end
+func (t *PipeTransport) subscribe(op byte, names ...string) (err error) {
+	t.mutex.Lock()
+	for _, name := range names {
+		switch op {
+		case PIPE_SUBSCRIBE:
+			t.channels[name] = true
+		case PIPE_UNSUBSCRIBE:
+			delete(t.channels, name)
+		case PIPE_PSUBSCRIBE:
+			t.patterns[name] = true
+		case PIPE_PUNSUBSCRIBE:
+			delete(t.patterns, name)
+		}
+	}
+	t.mutex.Unlock()
+	for _, name := range names {
+		if err = t.write(op, name, nil); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (t *PipeTransport) Subscribe(ctx context.Context, channels ...string) error {
+	return t.subscribe(PIPE_SUBSCRIBE, channels...)
+}
+
+func (t *PipeTransport) PSubscribe(ctx context.Context, patterns ...string) error {
+	return t.subscribe(PIPE_PSUBSCRIBE, patterns...)
+}
+
+func (t *PipeTransport) PUnsubscribe(ctx context.Context, patterns ...string) error {
+	return t.subscribe(PIPE_PUNSUBSCRIBE, patterns...)
+}
+
+func (t *PipeTransport) Receive(ctx context.Context) (channel, pattern string, payload []byte, err error) {
+	select {
+	case msg := <-t.queue:
+		return msg.channel, msg.pattern, msg.payload, nil
+	case <-t.closed:
+		err = fmt.Errorf("%s", "connection closed")
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+func (t *PipeTransport) Request(ctx context.Context, channel, inbox string, payload []byte) (reply []byte, err error) {
+	answer := make(chan []byte, 1)
+	t.mutex.Lock()
+	t.inboxes[inbox] = answer
+	t.mutex.Unlock()
+	defer func() {
+		t.mutex.Lock()
+		delete(t.inboxes, inbox)
+		t.mutex.Unlock()
+		t.write(PIPE_UNSUBSCRIBE, inbox, nil)
+	}()
+	if err = t.write(PIPE_SUBSCRIBE, inbox, nil); err != nil { // other end read it before message
+		return
+	}
+
+	var receivers int64
+	receivers, err = t.Publish(ctx, channel, payload)
+	if err != nil {
+		return
+	}
+	if receivers == 0 {
+		err = fmt.Errorf("nobody listen channel %s", channel)
+		return
+	}
+	select {
+	case reply = <-answer:
+	case <-t.closed:
+		err = fmt.Errorf("%s", "connection closed")
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+func (t *PipeTransport) Channels(ctx context.Context, pattern string) (channels []string, err error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+	found := map[string]bool{}
+	t.mutex.RLock()
+	for _, subs := range []map[string]bool{t.channels, t.peerCh} {
+		for ch := range subs {
+			if MatchPattern(pattern, ch) {
+				found[ch] = true
+			}
+		}
+	}
+	t.mutex.RUnlock()
+	for ch := range found {
+		channels = append(channels, ch)
+	}
+	sort.Strings(channels)
+	return
+}
+
+func (t *PipeTransport) NumSub(ctx context.Context, channels ...string) (subs map[string]int64, err error) {
+	subs = make(map[string]int64, len(channels))
+	t.mutex.RLock()
+	for _, ch := range channels {
+		for _, set := range []map[string]bool{t.channels, t.peerCh} {
+			if set[ch] {
+				subs[ch]++
+			}
+		}
+	}
+	t.mutex.RUnlock()
+	return
+}
+
+func (t *PipeTransport) Ping(ctx context.Context) error {
+	select {
+	case <-t.closed:
+		return fmt.Errorf("%s", "connection closed")
+	default:
+		return nil
+	}
+}
+
+// Reconnect of pipe possible only while it not closed: closed pipe can not be opened again
+func (t *PipeTransport) Reconnect(ctx context.Context) error {
+	return t.Ping(ctx)
+}
+
+func (t *PipeTransport) Close() (err error) {
+	t.once.Do(func() {
+		close(t.closed)
+		err = t.rwc.Close()
+	})
+	return
+}
+
+// pipeBridge relay messages between other end of pipe and bus connection opened on behalf of service of other end
+type pipeBridge struct {
+	pipe     *PipeTransport
+	bus      Transport
+	mutex    sync.Mutex
+	requests map[string]*pipeRequest // inboxes subscribed by other end for Request
+}
+
+type pipeRequest struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// BridgePipe connect other end of pipe to bus, as for task process started with pipe instead of own connection:
+// messages published by other end go to bus, messages of bus go to other end by its subscriptions.
+// bus must be opened on behalf of service of other end (its name and token); bus closed with pipe
+func BridgePipe(rwc io.ReadWriteCloser, bus Transport) *PipeTransport {
+	b := &pipeBridge{
+		pipe:     newPipeTransport(rwc),
+		bus:      bus,
+		requests: map[string]*pipeRequest{},
+	}
+	b.pipe.onPeer = b.follow
+	go b.pipe.read()
+	err := b.pipe.PSubscribe(context.Background(), "*") // all published by other end; first frame for waitPeer
+	if err != nil {
+		sl.L.Warning("pipe bridge: %s", err.Error())
+	}
+	go b.toBus()
+	go b.fromBus()
+	return b.pipe
+}
+
+// follow repeat subscriptions of other end on bus; inboxes of Request are awaited by bus.Request.
+// Channel stay subscribed on bus after PIPE_UNSUBSCRIBE: its messages are not passed to other end anyway
+func (b *pipeBridge) follow(op byte, name string) {
+	ctx := context.Background()
+	var err error
+	switch {
+	case strings.HasPrefix(name, INBOX_PREFIX):
+		b.mutex.Lock()
+		if req, ok := b.requests[name]; ok {
+			req.cancel()
+			delete(b.requests, name)
+		}
+		if op == PIPE_SUBSCRIBE {
+			req := &pipeRequest{}
+			req.ctx, req.cancel = context.WithTimeout(ctx, DEFAULT_REQUEST_TIMEOUT)
+			b.requests[name] = req
+		}
+		b.mutex.Unlock()
+	case op == PIPE_SUBSCRIBE:
+		err = b.bus.Subscribe(ctx, name)
+	case op == PIPE_PSUBSCRIBE:
+		err = b.bus.PSubscribe(ctx, name)
+	case op == PIPE_PUNSUBSCRIBE:
+		err = b.bus.PUnsubscribe(ctx, name)
+	}
+	if err != nil {
+		sl.L.Warning("pipe bridge: %s", err.Error())
+	}
+}
+
+// request return context of Request of other end waiting answer to inbox
+func (b *pipeBridge) request(inbox string) (ctx context.Context, ok bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if req, found := b.requests[inbox]; found {
+		return req.ctx, true
+	}
+	return
+}
+
+// toBus publish messages of other end on bus; close bus when pipe closed
+func (b *pipeBridge) toBus() {
+	defer b.bus.Close()
+	for {
+		channel, _, payload, err := b.pipe.Receive(context.Background())
+		if err != nil {
+			return
+		}
+		msg := RedisMessage{}
+		if msg.UnmarshalBinary(payload) == nil && strings.HasPrefix(msg.ReplyTo, INBOX_PREFIX) {
+			if ctx, ok := b.request(msg.ReplyTo); ok {
+				go b.ask(ctx, channel, msg.ReplyTo, payload)
+				continue
+			}
+		}
+		_, err = b.bus.Publish(context.Background(), channel, payload)
+		if err != nil {
+			sl.L.Warning("pipe bridge: %s", err.Error())
+		}
+	}
+}
+
+// ask make Request of other end on bus and pass answer to it
+func (b *pipeBridge) ask(ctx context.Context, channel, inbox string, payload []byte) {
+	reply, err := b.bus.Request(ctx, channel, inbox, payload)
+	if err != nil {
+		sl.L.Debug("pipe bridge: request to %s: %s", channel, err.Error())
+		return
+	}
+	b.pipe.Publish(context.Background(), inbox, reply)
+}
+
+// fromBus pass messages of bus to other end; on lost bus reconnect and repeat subscriptions of other end
+func (b *pipeBridge) fromBus() {
+	defer b.pipe.Close()
+	ctx := context.Background()
+	for {
+		channel, _, payload, err := b.bus.Receive(ctx)
+		if err == nil {
+			_, err = b.pipe.Publish(ctx, channel, payload)
+		} else if errors.As(err, new(redis.Error)) {
+			sl.L.Warning("pipe bridge: %s", err.Error())
+		}
+		if b.pipe.Ping(ctx) != nil {
+			return
+		}
+		if err == nil || errors.As(err, new(redis.Error)) { // refused subscription; connection is alive
+			continue
+		}
+		sl.L.Warning("pipe bridge: %s", err.Error())
+		for b.bus.Reconnect(ctx) != nil {
+			select {
+			case <-b.pipe.closed:
+				return
+			case <-time.After(time.Second):
+			}
+		}
+		b.pipe.mutex.RLock()
+		var channels, patterns []string
+		for ch := range b.pipe.peerCh {
+			if !strings.HasPrefix(ch, INBOX_PREFIX) {
+				channels = append(channels, ch)
+			}
+		}
+		for p := range b.pipe.peerPt {
+			patterns = append(patterns, p)
+		}
+		b.pipe.mutex.RUnlock()
+		if len(channels) > 0 {
+			b.bus.Subscribe(ctx, channels...)
+		}
+		if len(patterns) > 0 {
+			b.bus.PSubscribe(ctx, patterns...)
+		}
+	}
+}
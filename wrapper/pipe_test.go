@@ -0,0 +1,140 @@
+package wrapper
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// TestPipeTransport messages and request between two services over direct pipe
+func TestPipeTransport(t *testing.T) {
+	t.Chdir(t.TempDir())
+	a, b := PipePair()
+	master := CreateWrapperWithTransport(MASTER, 1, 0, a)
+	worker := CreateWrapperWithTransport("WORKER", 1, 0, b)
+	t.Cleanup(func() { a.Close(); b.Close() })
+
+	got := make(chan *RedisMessage, 10)
+	master.SetOnMessage(func(msg *RedisMessage) {
+		if msg.Key == "KEY" {
+			got <- msg
+		}
+	})
+	worker.SetOnMessage(func(msg *RedisMessage) {
+		if msg.Key == "PING" {
+			worker.Reply(msg, "PONG", msg.Value)
+		}
+	})
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) { // both ends know subscriptions of other one
+		toWorker, _ := a.NumSub(context.Background(), "WORKER")
+		toMaster, _ := b.NumSub(context.Background(), MASTER)
+		if toWorker["WORKER"] == 1 && toMaster[MASTER] == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("subscriptions not known by other end")
+		}
+	}
+
+	worker.SendToService(MASTER, "KEY", "value")
+	select {
+	case msg := <-got:
+		if msg.Sender != "WORKER" || msg.Value != "value" {
+			t.Fatalf("%+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not delivered")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	reply, err := master.Request(ctx, "WORKER", "PING", 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Key != "PONG" || reply.Value != float64(42) {
+		t.Fatalf("%+v", reply)
+	}
+	if n, _ := master.Transport.Publish(ctx, "NOBODY", nil); n != 0 {
+		t.Fatal("published to channel without subscribers")
+	}
+
+	b.Close()
+	for deadline := time.Now().Add(2 * time.Second); a.Ping(ctx) == nil; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("closed end not detected")
+		}
+	}
+}
+
+// TestBridgePipe service over pipe bridged to bus talk with services on bus as if it had own connection
+func TestBridgePipe(t *testing.T) {
+	t.Chdir(t.TempDir())
+	mr := miniredis.RunT(t)
+	master := testWrapper(t, mr, MASTER)
+	bus, err := DialTransport("WORKER", "tcp", mr.Addr(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	own, child := net.Pipe()
+	pipe := NewPipeTransport(child) // end of process reads before bridge start: net.Pipe is not buffered
+	bridge := BridgePipe(own, bus)
+	t.Cleanup(func() { bridge.Close() })
+	pipe.waitPeer(PIPE_PEER_WAIT)
+	worker := CreateWrapperWithTransport("WORKER", 1, 0, pipe)
+
+	got := make(chan *RedisMessage, 10)
+	master.SetOnMessage(func(msg *RedisMessage) {
+		switch msg.Key {
+		case "KEY":
+			got <- msg
+		case "PING":
+			master.Reply(msg, "PONG", msg.Value)
+		}
+	})
+	worker.SetOnMessage(func(msg *RedisMessage) {
+		if msg.Key == "PING" {
+			worker.Reply(msg, "PONG", msg.Value)
+		}
+	})
+	waitFor(t, 2*time.Second, func() bool { // subscription of worker repeated on bus
+		subs, _ := master.Transport.NumSub(context.Background(), "WORKER")
+		return subs["WORKER"] == 1
+	})
+
+	worker.SendToService(MASTER, "KEY", "value")
+	select {
+	case msg := <-got:
+		if msg.Sender != "WORKER" || msg.Value != "value" {
+			t.Fatalf("%+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message of worker not delivered to bus")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	reply, err := master.Request(ctx, "WORKER", "PING", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Key != "PONG" || reply.Value != float64(1) {
+		t.Fatalf("%+v", reply)
+	}
+	reply, err = worker.Request(ctx, MASTER, "PING", 2) // answer awaited on bus by bridge
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Key != "PONG" || reply.Value != float64(2) {
+		t.Fatalf("%+v", reply)
+	}
+
+	worker.Transport.Close() // process exited: bus connection of bridge closed
+	waitFor(t, 2*time.Second, func() bool {
+		subs, _ := master.Transport.NumSub(context.Background(), "WORKER")
+		return subs["WORKER"] == 0
+	})
+}
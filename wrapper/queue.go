@@ -0,0 +1,451 @@
+package wrapper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	sl "github.com/Averianov/cisystemlog"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	QUEUE_PREFIX string = "ci:q:" // keys of queue: ci:q:<queue>:<ready|delayed|inflight|dead|jobs|attempts|errors>
+
+	DEFAULT_MAX_ATTEMPTS int           = 5
+	DEFAULT_VISIBILITY   time.Duration = 30 * time.Second // job returned to queue if consumer not finished it in time
+	DEFAULT_RETRY_MIN    time.Duration = time.Second
+	DEFAULT_RETRY_MAX    time.Duration = 5 * time.Minute
+	QUEUE_POLL_MIN       time.Duration = 50 * time.Millisecond
+	QUEUE_POLL_MAX       time.Duration = time.Second
+	QUEUE_MOVE_LIMIT     int           = 100 // due jobs moved to ready on one dequeue
+)
+
+var (
+	queueEnqueue = registerScript(`
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+if tonumber(ARGV[3]) > 0 then
+	redis.call('ZADD', KEYS[3], ARGV[3], ARGV[1])
+else
+	redis.call('LPUSH', KEYS[2], ARGV[1])
+end
+return 1
+`)
+	// KEYS: ready, delayed, inflight, jobs, attempts, errors; ARGV: now, visibility deadline, move limit
+	queueDequeue = registerScript(`
+for _, key in ipairs({KEYS[2], KEYS[3]}) do
+	for _, id in ipairs(redis.call('ZRANGEBYSCORE', key, '-inf', ARGV[1], 'LIMIT', 0, ARGV[3])) do
+		redis.call('ZREM', key, id)
+		redis.call('RPUSH', KEYS[1], id)
+	end
+end
+local id = redis.call('RPOP', KEYS[1])
+if not id then
+	return false
+end
+local job = redis.call('HGET', KEYS[4], id)
+if not job then
+	return false
+end
+redis.call('ZADD', KEYS[3], ARGV[2], id)
+local attempts = redis.call('HINCRBY', KEYS[5], id, 1)
+local lasterr = redis.call('HGET', KEYS[6], id) or ''
+return {id, job, attempts, lasterr}
+`)
+	queueExtend = registerScript(`
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+	redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+	return 1
+end
+return 0
+`)
+	queueAck = registerScript(`
+local res = redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+redis.call('HDEL', KEYS[4], ARGV[1])
+return res
+`)
+	// ARGV: id, error, retry at; empty retry at - to dead letters
+	queueNack = registerScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[4], ARGV[1], ARGV[2])
+if ARGV[3] == '' then
+	redis.call('LPUSH', KEYS[3], ARGV[1])
+else
+	redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+end
+return 1
+`)
+	// ARGV: id; empty id - all dead letters
+	queueRequeue = registerScript(`
+local ids = {ARGV[1]}
+if ARGV[1] == '' then
+	ids = redis.call('LRANGE', KEYS[1], 0, -1)
+end
+local moved = 0
+for _, id in ipairs(ids) do
+	if redis.call('LREM', KEYS[1], 0, id) > 0 then
+		redis.call('HDEL', KEYS[3], id)
+		redis.call('LPUSH', KEYS[2], id)
+		moved = moved + 1
+	end
+end
+return moved
+`)
+	queuePurge = registerScript(`
+local ids = redis.call('LRANGE', KEYS[1], 0, -1)
+for _, id in ipairs(ids) do
+	redis.call('HDEL', KEYS[2], id)
+	redis.call('HDEL', KEYS[3], id)
+	redis.call('HDEL', KEYS[4], id)
+end
+redis.call('DEL', KEYS[1])
+return #ids
+`)
+)
+
+// Job is unit of work in persistent queue
+type Job struct {
+	ID          string          `json:"id"`
+	Queue       string          `json:"queue"`
+	Sender      string          `json:"sender"`
+	Payload     json.RawMessage `json:"payload"`
+	MaxAttempts int             `json:"max_attempts"`
+	Enqueued    time.Time       `json:"enqueued"`
+
+	Attempts  int    `json:"attempts,omitempty"`   // current attempt on dequeue, done attempts in dead letters
+	LastError string `json:"last_error,omitempty"` // error of previous attempt
+}
+
+// JobOption change job on Enqueue
+type JobOption func(job *Job, delay *time.Duration)
+
+// WithMaxAttempts job moved to dead letters after n failed attempts
+func WithMaxAttempts(n int) JobOption {
+	return func(job *Job, delay *time.Duration) {
+		job.MaxAttempts = n
+	}
+}
+
+// WithDelay job available for consumers after delay
+func WithDelay(d time.Duration) JobOption {
+	return func(job *Job, delay *time.Duration) {
+		*delay = d
+	}
+}
+
+// DecodeJob return payload of job as value of type T
+func DecodeJob[T any](job *Job) (value T, err error) {
+	err = json.Unmarshal(job.Payload, &value)
+	return
+}
+
+// QueueStats is size of queue parts
+type QueueStats struct {
+	Queue    string `json:"queue"`
+	Ready    int64  `json:"ready"`
+	Delayed  int64  `json:"delayed"` // waiting retry or delayed enqueue
+	InFlight int64  `json:"in_flight"`
+	Dead     int64  `json:"dead"`
+}
+
+// queueKeys return keys of queue by parts names
+func queueKeys(queue string, parts ...string) (keys []string) {
+	for _, part := range parts {
+		keys = append(keys, QUEUE_PREFIX+queue+":"+part)
+	}
+	return
+}
+
+func (wpr *Wrapper) queueClient() (client *redis.Client, err error) {
+	if wpr.RClient == nil {
+		err = fmt.Errorf("queues not supported by transport %T", wpr.Transport)
+	}
+	return wpr.RClient, err
+}
+
+// Enqueue add job with payload to queue; job kept in bus until consumer finish it
+func (wpr *Wrapper) Enqueue(ctx context.Context, queue string, payload any, opts ...JobOption) (id string, err error) {
+	var client *redis.Client
+	client, err = wpr.queueClient()
+	if err != nil {
+		return
+	}
+	job := Job{
+		ID:          NewMessageID(),
+		Queue:       queue,
+		Sender:      wpr.Name,
+		MaxAttempts: DEFAULT_MAX_ATTEMPTS,
+		Enqueued:    time.Now(),
+	}
+	var delay time.Duration
+	for _, opt := range opts {
+		opt(&job, &delay)
+	}
+	job.Payload, err = json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	var raw []byte
+	raw, err = json.Marshal(job)
+	if err != nil {
+		return
+	}
+	var at int64
+	if delay > 0 {
+		at = time.Now().Add(delay).UnixMilli()
+	}
+	err = queueEnqueue.Run(ctx, client, queueKeys(queue, "jobs", "ready", "delayed"), job.ID, raw, at).Err()
+	return job.ID, err
+}
+
+// dequeue take next job and hide it from other consumers until visibility deadline; nil if queue empty
+func (wpr *Wrapper) dequeue(ctx context.Context, queue string, visibility time.Duration) (job *Job, err error) {
+	now := time.Now()
+	var res []any
+	res, err = queueDequeue.Run(ctx, wpr.RClient,
+		queueKeys(queue, "ready", "delayed", "inflight", "jobs", "attempts", "errors"),
+		now.UnixMilli(), now.Add(visibility).UnixMilli(), QUEUE_MOVE_LIMIT).Slice()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	if len(res) != 4 {
+		err = fmt.Errorf("queue %s: wrong answer of dequeue", queue)
+		return
+	}
+	job = &Job{}
+	raw, _ := res[1].(string)
+	err = json.Unmarshal([]byte(raw), job)
+	if err != nil {
+		err = fmt.Errorf("queue %s: wrong job %v: %s", queue, res[0], err.Error())
+		return
+	}
+	attempts, _ := res[2].(int64)
+	job.Attempts = int(attempts)
+	job.LastError, _ = res[3].(string)
+	return
+}
+
+// QueueStats return size of queue parts
+func (wpr *Wrapper) QueueStats(ctx context.Context, queue string) (stats QueueStats, err error) {
+	var client *redis.Client
+	client, err = wpr.queueClient()
+	if err != nil {
+		return
+	}
+	keys := queueKeys(queue, "ready", "delayed", "inflight", "dead")
+	pipe := client.Pipeline()
+	ready := pipe.LLen(ctx, keys[0])
+	delayed := pipe.ZCard(ctx, keys[1])
+	inflight := pipe.ZCard(ctx, keys[2])
+	dead := pipe.LLen(ctx, keys[3])
+	_, err = pipe.Exec(ctx)
+	stats = QueueStats{Queue: queue, Ready: ready.Val(), Delayed: delayed.Val(), InFlight: inflight.Val(), Dead: dead.Val()}
+	return
+}
+
+// Queues return names of queues which have jobs
+func (wpr *Wrapper) Queues(ctx context.Context) (queues []string, err error) {
+	var client *redis.Client
+	client, err = wpr.queueClient()
+	if err != nil {
+		return
+	}
+	iter := client.Scan(ctx, 0, QUEUE_PREFIX+"*:jobs", 0).Iterator()
+	for iter.Next(ctx) {
+		queues = append(queues, strings.TrimSuffix(strings.TrimPrefix(iter.Val(), QUEUE_PREFIX), ":jobs"))
+	}
+	err = iter.Err()
+	return
+}
+
+// DeadJobs return jobs of dead letters, newest first; limit 0 - all
+func (wpr *Wrapper) DeadJobs(ctx context.Context, queue string, limit int) (jobs []Job, err error) {
+	var client *redis.Client
+	client, err = wpr.queueClient()
+	if err != nil {
+		return
+	}
+	keys := queueKeys(queue, "dead", "jobs", "attempts", "errors")
+	var ids []string
+	ids, err = client.LRange(ctx, keys[0], 0, int64(limit)-1).Result()
+	if err != nil || len(ids) == 0 {
+		return
+	}
+	var raws, attempts, errs []any
+	if raws, err = client.HMGet(ctx, keys[1], ids...).Result(); err != nil {
+		return
+	}
+	if attempts, err = client.HMGet(ctx, keys[2], ids...).Result(); err != nil {
+		return
+	}
+	if errs, err = client.HMGet(ctx, keys[3], ids...).Result(); err != nil {
+		return
+	}
+	for i := range ids {
+		raw, ok := raws[i].(string)
+		if !ok {
+			continue
+		}
+		job := Job{}
+		if json.Unmarshal([]byte(raw), &job) != nil {
+			continue
+		}
+		if val, ok := attempts[i].(string); ok {
+			fmt.Sscan(val, &job.Attempts)
+		}
+		job.LastError, _ = errs[i].(string)
+		jobs = append(jobs, job)
+	}
+	return
+}
+
+// Requeue return job from dead letters to queue with reset attempts; empty id - all dead letters
+func (wpr *Wrapper) Requeue(ctx context.Context, queue, id string) (moved int64, err error) {
+	var client *redis.Client
+	client, err = wpr.queueClient()
+	if err != nil {
+		return
+	}
+	return queueRequeue.Run(ctx, client, queueKeys(queue, "dead", "ready", "attempts"), id).Int64()
+}
+
+// PurgeDead delete all jobs of dead letters
+func (wpr *Wrapper) PurgeDead(ctx context.Context, queue string) (purged int64, err error) {
+	var client *redis.Client
+	client, err = wpr.queueClient()
+	if err != nil {
+		return
+	}
+	return queuePurge.Run(ctx, client, queueKeys(queue, "dead", "jobs", "attempts", "errors")).Int64()
+}
+
+// ### Consumer ##############################################################
+
+// Consumer run handler for jobs of queue in Concurrency goroutines
+type Consumer struct {
+	wpr         *Wrapper
+	Queue       string
+	Concurrency int
+	Visibility  time.Duration // job returned to queue if not finished in time; extended while handler works
+	RetryMin    time.Duration // backoff before next attempt of failed job
+	RetryMax    time.Duration
+	Handler     func(ctx context.Context, job *Job) error
+}
+
+// Consumer prepare consumer of queue; started by Run
+func (wpr *Wrapper) Consumer(queue string, concurrency int, handler func(ctx context.Context, job *Job) error) *Consumer {
+	return &Consumer{
+		wpr:         wpr,
+		Queue:       queue,
+		Concurrency: concurrency,
+		Visibility:  DEFAULT_VISIBILITY,
+		RetryMin:    DEFAULT_RETRY_MIN,
+		RetryMax:    DEFAULT_RETRY_MAX,
+		Handler:     handler,
+	}
+}
+
+// Run consume jobs until ctx done and wait handlers of taken jobs; error only if queue not supported
+func (c *Consumer) Run(ctx context.Context) (err error) {
+	if _, err = c.wpr.queueClient(); err != nil {
+		return
+	}
+	if c.Concurrency < 1 {
+		c.Concurrency = 1
+	}
+	sl.L.Info("[%s] consume queue %s by %d workers", c.wpr.Name, c.Queue, c.Concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < c.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.work(ctx)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (c *Consumer) work(ctx context.Context) {
+	idle := 0
+	for ctx.Err() == nil {
+		job, err := c.wpr.dequeue(ctx, c.Queue, c.Visibility)
+		if err != nil && ctx.Err() == nil {
+			sl.L.Warning("[%s] queue %s err: %s", c.wpr.Name, c.Queue, err.Error())
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(Backoff(idle, QUEUE_POLL_MIN, QUEUE_POLL_MAX)):
+				idle++
+			}
+			continue
+		}
+		idle = 0
+		c.process(job)
+	}
+}
+
+// process run handler with extension of visibility; handler finish job even if ctx of consumer done
+func (c *Consumer) process(job *Job) {
+	keys := queueKeys(c.Queue, "inflight", "delayed", "dead", "errors")
+	if job.Attempts > job.MaxAttempts { // consumer died while job taken
+		c.nack(keys, job, fmt.Errorf("visibility timeout %s exceeded", c.Visibility), true)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		tick := time.NewTicker(c.Visibility / 3)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+				res, err := queueExtend.Run(ctx, c.wpr.RClient, keys[:1], job.ID, time.Now().Add(c.Visibility).UnixMilli()).Int64()
+				if err == nil && res == 0 {
+					sl.L.Warning("[%s] queue %s: job %s returned to queue while in work", c.wpr.Name, c.Queue, job.ID)
+					return
+				}
+			}
+		}
+	}()
+
+	err := recovered(func() error { return c.Handler(ctx, job) })
+	if err != nil {
+		c.nack(keys, job, err, job.Attempts >= job.MaxAttempts)
+		return
+	}
+	err = queueAck.Run(context.Background(), c.wpr.RClient, queueKeys(c.Queue, "inflight", "jobs", "attempts", "errors"), job.ID).Err()
+	if err != nil {
+		sl.L.Warning("[%s] queue %s: ack of job %s err: %s", c.wpr.Name, c.Queue, job.ID, err.Error())
+	}
+}
+
+// nack return failed job to queue after backoff or move it to dead letters
+func (c *Consumer) nack(keys []string, job *Job, jerr error, dead bool) {
+	retryAt := ""
+	if dead {
+		sl.L.Alert("[%s] queue %s: job %s dead after %d attempts: %s", c.wpr.Name, c.Queue, job.ID, job.Attempts, jerr.Error())
+	} else {
+		retryAt = fmt.Sprint(time.Now().Add(Backoff(job.Attempts-1, c.RetryMin, c.RetryMax)).UnixMilli())
+		sl.L.Warning("[%s] queue %s: job %s attempt %d failed: %s", c.wpr.Name, c.Queue, job.ID, job.Attempts, jerr.Error())
+	}
+	err := queueNack.Run(context.Background(), c.wpr.RClient, keys, job.ID, jerr.Error(), retryAt).Err()
+	if err != nil {
+		sl.L.Warning("[%s] queue %s: nack of job %s err: %s", c.wpr.Name, c.Queue, job.ID, err.Error())
+	}
+}
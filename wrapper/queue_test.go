@@ -0,0 +1,92 @@
+package wrapper
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestQueueRetryAndDeadLetters(t *testing.T) {
+	t.Chdir(t.TempDir())
+	mr := miniredis.RunT(t)
+	producer := testWrapper(t, mr, "PRODUCER")
+	worker := testWrapper(t, mr, "WORKER")
+	ctx := context.Background()
+
+	producer.Enqueue(ctx, "q", "ok")
+	producer.Enqueue(ctx, "q", "flaky")
+	producer.Enqueue(ctx, "q", "bad", WithMaxAttempts(2))
+	producer.Enqueue(ctx, "q", "later", WithDelay(300*time.Millisecond))
+
+	var flaky, done atomic.Int32
+	c := worker.Consumer("q", 2, func(ctx context.Context, job *Job) error {
+		value, _ := DecodeJob[string](job)
+		switch value {
+		case "flaky":
+			if flaky.Add(1) == 1 {
+				return fmt.Errorf("first attempt failed")
+			}
+		case "bad":
+			panic("boom")
+		}
+		done.Add(1)
+		return nil
+	})
+	c.RetryMin, c.RetryMax = 50*time.Millisecond, 100*time.Millisecond
+	cctx, cancel := context.WithCancel(ctx)
+	stopped := make(chan struct{})
+	go func() {
+		c.Run(cctx)
+		close(stopped)
+	}()
+
+	waitFor(t, 5*time.Second, func() bool {
+		st, _ := producer.QueueStats(ctx, "q")
+		return done.Load() == 3 && st.Dead == 1 && st.Ready+st.Delayed+st.InFlight == 0
+	})
+	cancel()
+	<-stopped
+
+	dead, err := producer.DeadJobs(ctx, "q", 0)
+	if err != nil || len(dead) != 1 || dead[0].Attempts != 2 || dead[0].LastError != "panic: boom" {
+		t.Fatalf("dead letters %+v %v", dead, err)
+	}
+	if n, err := producer.Requeue(ctx, "q", ""); n != 1 || err != nil {
+		t.Fatal("requeue", n, err)
+	}
+	if st, _ := producer.QueueStats(ctx, "q"); st.Ready != 1 || st.Dead != 0 {
+		t.Fatalf("after requeue %+v", st)
+	}
+	if queues, _ := producer.Queues(ctx); len(queues) != 1 || queues[0] != "q" {
+		t.Fatal("queues", queues)
+	}
+}
+
+// TestQueueVisibility job taken by died consumer returns to queue after visibility timeout
+func TestQueueVisibility(t *testing.T) {
+	t.Chdir(t.TempDir())
+	mr := miniredis.RunT(t)
+	worker := testWrapper(t, mr, "WORKER")
+	ctx := context.Background()
+
+	id, err := worker.Enqueue(ctx, "q", "job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := worker.dequeue(ctx, "q", 100*time.Millisecond) // never acked
+	if err != nil || job == nil || job.ID != id || job.Attempts != 1 {
+		t.Fatal(job, err)
+	}
+	if again, _ := worker.dequeue(ctx, "q", 100*time.Millisecond); again != nil {
+		t.Fatal("job taken twice in visibility time")
+	}
+	time.Sleep(200 * time.Millisecond)
+	again, err := worker.dequeue(ctx, "q", 100*time.Millisecond)
+	if err != nil || again == nil || again.ID != id || again.Attempts != 2 {
+		t.Fatal("job not returned after visibility", again, err)
+	}
+}
@@ -0,0 +1,214 @@
+package wrapper
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+	"time"
+
+	sl "github.com/Averianov/cisystemlog"
+)
+
+const (
+	READY   string = "READY"   // service started by Run and ready to work
+	FAILURE string = "FAILURE" // key of message to master with reason of service failure
+	RELOAD  string = "RELOAD"  // key of message with new config for Reloader; answer is "OK" or error
+	CONFIG  string = "CONFIG"  // key of message to master with config capability of service: RELOAD - apply without restart
+
+	RELOAD_OK string = "OK"
+
+	DEFAULT_STOP_TIMEOUT time.Duration = 10 * time.Second
+)
+
+// Service is worker managed by Run
+type Service interface {
+	// Start prepare service and run its work (as by wpr.Go) bound to ctx; return when service ready
+	Start(ctx context.Context) error
+	// Stop finish work before deadline of ctx
+	Stop(ctx context.Context) error
+}
+
+// Reloader is service which apply new config without restart
+type Reloader interface {
+	Reload(cfg map[string]string) error
+}
+
+// Run start service, report READY to master and wait stop: cancel of ctx, SIGTERM / SIGINT / SIGUSR1,
+// message STOP to service, wpr.Stop() or failure reported by wpr.Fail; then stop service
+// before wpr.StopTimeout, report STOPPED and finish listener of messages. Panics of Start, Stop, Reload and wpr.Go are reported as FAILURE.
+func Run(ctx context.Context, svc Service) (err error) {
+	wpr := Wpr
+	if wpr == nil {
+		wpr = CreateWrapper(os.Getenv(NAME), -1, -1)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	failures := make(chan error, 1)
+	wpr.mutex.Lock()
+	wpr.failures = failures
+	wpr.control = func(msg *RedisMessage) bool { return wpr.runControl(msg, svc) }
+	wpr.mutex.Unlock()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR1)
+	defer signal.Stop(sig)
+
+	err = recovered(func() error { return svc.Start(ctx) })
+	if err != nil {
+		err = fmt.Errorf("start: %s", err.Error())
+		wpr.Fail(err)
+	} else {
+		wpr.SendToService(MASTER, STATUS, READY)
+		if _, ok := svc.(Reloader); ok {
+			wpr.SendToService(MASTER, CONFIG, RELOAD)
+		}
+		sl.L.Info("[%s] ready", wpr.Name)
+		if timeout := wpr.WatchdogTimeout(); timeout > 0 {
+			sl.L.Info("[%s] watchdog %s: work of service must call wpr.Ping()", wpr.Name, timeout)
+		}
+	loop:
+		for {
+			select {
+			case <-ctx.Done():
+				sl.L.Info("[%s] stop: %s", wpr.Name, ctx.Err().Error())
+				break loop
+			case s := <-sig:
+				sl.L.Info("[%s] stop: got signal %s", wpr.Name, s.String())
+				break loop
+			case <-wpr.StopChan:
+				sl.L.Info("[%s] stop: requested", wpr.Name)
+				break loop
+			case err = <-failures:
+				sl.L.Alert("[%s] stop: %s", wpr.Name, err.Error())
+				break loop
+			}
+		}
+	}
+	cancel()
+
+	serr := wpr.stopService(svc)
+	if serr != nil {
+		wpr.Fail(serr)
+		if err == nil {
+			err = serr
+		}
+	}
+	wpr.Stop()     // for code which still listen StopChan
+	wpr.unlisten() // listener must not reconnect transport closed by RegularStop
+	wpr.RegularStop()
+	wpr.waitListener()
+	return
+}
+
+// waitListener wait return of listener; message in processing by handler is waited not longer than StopTimeout
+func (wpr *Wrapper) waitListener() {
+	timeout := wpr.StopTimeout
+	if timeout <= 0 {
+		timeout = DEFAULT_STOP_TIMEOUT
+	}
+	select {
+	case <-wpr.listened:
+	case <-time.After(timeout):
+		sl.L.Warning("[%s] listener not finished in %s", wpr.Name, timeout)
+	}
+}
+
+// stopService call Stop of service and wait it and goroutines of Go not longer than StopTimeout
+func (wpr *Wrapper) stopService(svc Service) (err error) {
+	timeout := wpr.StopTimeout
+	if timeout <= 0 {
+		timeout = DEFAULT_STOP_TIMEOUT
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		err := recovered(func() error { return svc.Stop(ctx) })
+		wpr.works.Wait() // work started by Go must finish too
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			err = fmt.Errorf("stop: %s", err.Error())
+		}
+	case <-ctx.Done():
+		err = fmt.Errorf("stop: deadline %s exceeded", timeout)
+	}
+	return
+}
+
+// runControl preparing messages of service lifecycle in listener; return true if message handled
+func (wpr *Wrapper) runControl(msg *RedisMessage, svc Service) bool {
+	switch msg.Key {
+	case STOP:
+		wpr.Stop()
+		return true
+	case RELOAD:
+		reloader, ok := svc.(Reloader)
+		if !ok {
+			wpr.Reply(msg, RELOAD, fmt.Sprintf("%s does not support reload", wpr.Name))
+			return true
+		}
+		cfg, err := Decode[map[string]string](msg)
+		if err == nil {
+			for key, val := range cfg { // for code which read env of process
+				os.Setenv(key, val)
+			}
+			err = recovered(func() error { return reloader.Reload(cfg) })
+		}
+		if err != nil {
+			sl.L.Warning("[%s] reload: %s", wpr.Name, err.Error())
+			wpr.Reply(msg, RELOAD, err.Error())
+			return true
+		}
+		sl.L.Info("[%s] reloaded", wpr.Name)
+		wpr.Reply(msg, RELOAD, RELOAD_OK)
+		return true
+	}
+	return false
+}
+
+// Go run work of service in goroutine; error or panic of fn reported by Fail and stop service in Run;
+// Run wait fn (up to StopTimeout) before STOPPED, so fn must return on cancel of ctx of Start
+func (wpr *Wrapper) Go(fn func() error) {
+	wpr.works.Add(1)
+	go func() {
+		defer wpr.works.Done()
+		if err := recovered(fn); err != nil {
+			wpr.Fail(err)
+		}
+	}()
+}
+
+// Fail report reason of service failure to master; service in Run is stopped
+func (wpr *Wrapper) Fail(err error) {
+	sl.L.Alert("[%s] failure: %s", wpr.Name, err.Error())
+	wpr.SendToService(MASTER, FAILURE, err.Error())
+
+	wpr.mutex.RLock()
+	failures := wpr.failures
+	wpr.mutex.RUnlock()
+	if failures != nil {
+		select {
+		case failures <- err:
+		default: // service already stopping
+		}
+	}
+}
+
+// recovered call fn and return panic as error
+func recovered(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+			sl.L.Debug("%s\n%s", err.Error(), debug.Stack())
+		}
+	}()
+	return fn()
+}
@@ -0,0 +1,94 @@
+package wrapper
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// testService run work by Go; work returns after cancel of ctx of Start and delay
+type testService struct {
+	wpr      *Wrapper
+	delay    time.Duration
+	started  atomic.Bool
+	finished atomic.Bool
+}
+
+func (s *testService) Start(ctx context.Context) error {
+	s.wpr.Go(func() error {
+		s.started.Store(true)
+		<-ctx.Done()
+		time.Sleep(s.delay)
+		s.finished.Store(true)
+		return nil
+	})
+	return nil
+}
+
+func (s *testService) Stop(ctx context.Context) error { return nil }
+
+// TestRunWaitsGo report STOPPED only after goroutines of Go; hung goroutine bounded by StopTimeout
+func TestRunWaitsGo(t *testing.T) {
+	t.Chdir(t.TempDir())
+	mr := miniredis.RunT(t)
+	Wpr = testWrapper(t, mr, "SVC")
+	t.Cleanup(func() { Wpr = nil })
+	Wpr.StopTimeout = 500 * time.Millisecond
+
+	svc := &testService{wpr: Wpr, delay: 100 * time.Millisecond}
+	done := make(chan error, 1)
+	go func() { done <- Run(context.Background(), svc) }()
+	waitFor(t, 2*time.Second, svc.started.Load)
+	Wpr.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !svc.finished.Load() {
+		t.Fatal("stopped before work of Go")
+	}
+
+	Wpr = testWrapper(t, mr, "SVC")
+	Wpr.StopTimeout = 200 * time.Millisecond
+	svc = &testService{wpr: Wpr, delay: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- Run(ctx, svc) }()
+	waitFor(t, 2*time.Second, svc.started.Load)
+	start := time.Now()
+	cancel()
+	err := <-done
+	if err == nil || !strings.Contains(err.Error(), "deadline") {
+		t.Fatal("hung work not reported", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatal("stop waited", elapsed)
+	}
+}
+
+// TestRunFinishListener Run return after listener of messages: no goroutines of wrapper left
+func TestRunFinishListener(t *testing.T) {
+	t.Chdir(t.TempDir())
+	mr := miniredis.RunT(t)
+	before := runtime.NumGoroutine()
+	Wpr = testWrapper(t, mr, "SVC")
+	t.Cleanup(func() { Wpr = nil })
+
+	svc := &testService{wpr: Wpr}
+	done := make(chan error, 1)
+	go func() { done <- Run(context.Background(), svc) }()
+	waitFor(t, 2*time.Second, svc.started.Load)
+	Wpr.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-Wpr.listened:
+	default:
+		t.Fatal("listener works after Run")
+	}
+	waitFor(t, 2*time.Second, func() bool { return runtime.NumGoroutine() <= before })
+}
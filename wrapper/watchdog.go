@@ -0,0 +1,52 @@
+package wrapper
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	HEARTBEAT string = "HEARTBEAT" // key of watchdog ping to master
+	WATCHDOG  string = "WATCHDOG"  // env with watchdog timeout of service in milliseconds; not set - watchdog off
+
+	WATCHDOG_MIN_PING time.Duration = time.Second // pings are sent not often than quarter of timeout and this
+)
+
+// watchdog is state of pings of service to master
+type watchdog struct {
+	sync.Mutex
+	timeout time.Duration
+	last    time.Time // last sent ping
+}
+
+// watchdogTimeout read timeout of watchdog from env of process
+func watchdogTimeout() time.Duration {
+	ms, err := strconv.ParseInt(os.Getenv(WATCHDOG), 10, 64)
+	if err != nil || ms <= 0 {
+		return 0
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// Ping report to master that work of service makes progress; call it from the work loop which may hang.
+// With watchdog (WatchdogTimeout > 0) only these pings keep service alive: neither listener nor Run ping for it,
+// as they keep working while the work is deadlocked. Pings are sent not often than quarter of timeout
+func (wpr *Wrapper) Ping() {
+	wpr.dog.Lock()
+	if wpr.dog.timeout <= 0 || time.Since(wpr.dog.last) < max(wpr.dog.timeout/4, WATCHDOG_MIN_PING) {
+		wpr.dog.Unlock()
+		return
+	}
+	wpr.dog.last = time.Now()
+	wpr.dog.Unlock()
+	wpr.SendToService(MASTER, HEARTBEAT, wpr.Name) // error logged by SendToService
+}
+
+// WatchdogTimeout return timeout of watchdog given by dispatcher; 0 - watchdog off
+func (wpr *Wrapper) WatchdogTimeout() time.Duration {
+	wpr.dog.Lock()
+	defer wpr.dog.Unlock()
+	return wpr.dog.timeout
+}
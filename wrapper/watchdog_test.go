@@ -0,0 +1,72 @@
+package wrapper
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// loopService ping from work loop of Go; work hangs when stuck is set
+type loopService struct {
+	wpr   *Wrapper
+	stuck atomic.Bool
+}
+
+func (s *loopService) Start(ctx context.Context) error {
+	s.wpr.Go(func() error {
+		for ctx.Err() == nil {
+			if !s.stuck.Load() {
+				s.wpr.Ping()
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return nil
+	})
+	return nil
+}
+
+func (s *loopService) Stop(ctx context.Context) error { return nil }
+
+// TestWatchdogPings only work of service pings master: not listener and not Run while work is stuck
+func TestWatchdogPings(t *testing.T) {
+	t.Chdir(t.TempDir())
+	mr := miniredis.RunT(t)
+	master := testWrapper(t, mr, MASTER)
+	var pings atomic.Int64
+	master.SetOnMessage(func(msg *RedisMessage) {
+		if msg.Key == HEARTBEAT {
+			pings.Add(1)
+		}
+	})
+	t.Setenv(WATCHDOG, "100")
+	Wpr = testWrapper(t, mr, "SVC")
+	t.Cleanup(func() { Wpr = nil })
+	if Wpr.WatchdogTimeout() != 100*time.Millisecond {
+		t.Fatal("timeout", Wpr.WatchdogTimeout())
+	}
+
+	for i := 0; i < 10; i++ {
+		master.SendToService("SVC", "KEY", i)
+	}
+	svc := &loopService{wpr: Wpr}
+	svc.stuck.Store(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, svc) }()
+	time.Sleep(1500 * time.Millisecond) // more than WATCHDOG_MIN_PING
+	if pings.Load() != 0 {
+		t.Fatal("pinged while work is stuck")
+	}
+
+	svc.stuck.Store(false)
+	waitFor(t, 2*time.Second, func() bool { return pings.Load() > 0 })
+	time.Sleep(500 * time.Millisecond)
+	if n := pings.Load(); n > 2 { // not often than WATCHDOG_MIN_PING
+		t.Fatal("too many pings", n)
+	}
+	cancel()
+	<-done
+}
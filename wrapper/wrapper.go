@@ -2,12 +2,15 @@ package wrapper
 
 import (
 	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
-	"sync"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -17,13 +20,18 @@ import (
 )
 
 const (
-	NAME           string = "NAME"
-	MASTER         string = "MASTER"
-	SENDER         string = "SENDER"
-	LOG_LEVEL      string = "LOGLEVEL"
-	SIZE_LOG_FILE  string = "SIZE_LOG_FILE"
-	TIMELOCATION   string = "TIMELOCATION"
-	CI_REDIS_PORT 	   string = "CIREDISPORT"
+	NAME          string = "NAME"
+	MASTER        string = "MASTER"
+	SENDER        string = "SENDER"
+	LOG_LEVEL     string = "LOGLEVEL"
+	SIZE_LOG_FILE string = "SIZE_LOG_FILE"
+	TIMELOCATION  string = "TIMELOCATION"
+	CI_REDIS_PORT string = "CIREDISPORT"
+	REPLICA_GROUP string = "REPLICA_GROUP" // name of replicas group, as WORKER1
+	REPLICA_INDEX string = "REPLICA_INDEX" // index of replica in group, from 0
+
+	REPLICA_SEPARATOR string = "#"      // replica name is group + separator + index: WORKER1#0
+	LOG_DIR           string = "./log/" // log files of services: LOG_DIR/NAME.log
 	//PORT_FILE_PATH string = "./port"
 
 	DEFAULT_TRYING_COUNT int    = 2
@@ -34,11 +42,16 @@ const (
 	STATUS string = "STATUS"
 	START  string = "START"
 	STOP   string = "STOP"
-
-	LAUNCHED string = "LAUNCHED"
-	STOPPED  string = "STOPPED"
-	GETINFO  string = "GETINFO"
-	EXIT     string = "EXIT"
+	SCALE  string = "SCALE" // value "NAME COUNT" - change count of replicas
+
+	LAUNCHED  string = "LAUNCHED"
+	STOPPED   string = "STOPPED"
+	COMPLETED string = "COMPLETED" // oneshot task finished own job
+	GETINFO   string = "GETINFO"
+	EXIT      string = "EXIT"
+	RESTART   string = "RESTART" // value is name of task or replicas group
+	SIGNAL    string = "SIGNAL"  // signal got by master process; value is name of signal as SIGTERM
+	OK        string = "OK"      // answer of master on successful command
 )
 
 var (
@@ -46,37 +59,189 @@ var (
 
 	// Sender, Key, Value receive from redis response
 	RadioKat func(sender, key string, value any) // function which preparing receive data from redis channel
+
+	// RadioKatMessage receive whole message for Decode; if set - used instead of RadioKat and RadioKatTopic
+	RadioKatMessage func(msg *RedisMessage)
+
+	logsOnce sync.Once
+
+	signalNames = map[os.Signal]string{
+		syscall.SIGTERM: "SIGTERM",
+		syscall.SIGINT:  "SIGINT",
+		syscall.SIGHUP:  "SIGHUP",
+		syscall.SIGUSR1: "SIGUSR1",
+	}
 )
 
 type Wrapper struct {
 	Name      string
-	RClient   *redis.Client
-	PubSub    *redis.PubSub
+	Group     string // replicas group; empty for service without replicas
+	Replica   int    // index of replica in group
+	Tags      []string
+	Codec     Codec                   // codec for values of sending messages; JSON by default
+	Token     string                  // credentials generated by dispatcher; empty - bus without auth
+	Transport Transport               // bus of messages
+	RClient   *redis.Client           // client of Redis bus for groups; nil on in-process bus
+	PubSub    *redis.PubSub           // replaced on reconnect; read by Subscription
+	OnMessage func(msg *RedisMessage) // handler of this wrapper; used instead of global RadioKat (several wrappers in one process)
 	Env       map[string]string
-	TimeDelay map[string]int
-	NextTry   map[string]int64
-	StopChan  chan struct{}
-    stopOnce sync.Once
+
+	OnConnState  func(state string, err error) // called on CONNECTED / DISCONNECTED of bus
+	BackoffMin   time.Duration                 // first delay of reconnect
+	BackoffMax   time.Duration                 // cap of reconnect delay
+	OutboxSize   int                           // messages kept while bus is disconnected
+	OutboxPolicy string                        // DROP_OLDEST or DROP_NEWEST on full outbox
+	StopTimeout  time.Duration                 // deadline of Service.Stop in Run
+	conn         connection
+
+	failures  chan error                         // failures of service managed by Run
+	works     sync.WaitGroup                     // goroutines of wpr.Go; waited by Run before STOPPED
+	control   func(msg *RedisMessage) bool       // lifecycle messages of service managed by Run
+	watchers  map[string][]func(change KVChange) // callbacks of KV.Watch by topic pattern
+	dog       watchdog                           // pings to master
+	StopChan  chan struct{}                      // closed by Stop; do not close directly
+	listen    context.Context                    // canceled by Run to finish listener of messages
+	unlisten  context.CancelFunc
+	listened  chan struct{} // closed when listener returned
+	stopOnce  sync.Once
+	closeOnce sync.Once
+	mutex     sync.RWMutex // guard PubSub, OnMessage and OnConnState changed while listener works
+	rrMutex   sync.Mutex
+	rrIndex   map[string]int // round-robin position by replicas group
 }
 
 type RedisMessage struct {
-	Sender string `json:"s"`
-	Key    string `json:"k"`
-	Value  any    `json:"v"`
+	Sender      string            `json:"s"`
+	Key         string            `json:"k"`
+	Value       any               `json:"v"`
+	ContentType string            `json:"ct,omitempty"`  // codec of Data; empty - value in "v" as JSON
+	Data        []byte            `json:"d,omitempty"`   // value encoded by codec
+	ID          string            `json:"id,omitempty"`  // unique id of message
+	Version     string            `json:"ver,omitempty"` // protocol version; empty - legacy envelope
+	CreatedAt   int64             `json:"ts,omitempty"`  // unix milliseconds
+	ExpiresAt   int64             `json:"exp,omitempty"` // unix milliseconds; 0 - never expired
+	ReplyTo     string            `json:"rt,omitempty"`  // channel for answer
+	Headers     map[string]string `json:"h,omitempty"`
+	Signature   string            `json:"sig,omitempty"` // HMAC of message by token of service
+
+	Channel string          `json:"-"` // channel or topic where message was received
+	Pattern string          `json:"-"` // pattern of Subscribe which matched topic; empty for own channels
+	raw     json.RawMessage // JSON of value for Decode
 }
 
-// MarshalBinary converts the struct to bytes for Redis storage
+// MarshalBinary converts the struct to bytes for Redis storage: JSON envelope; value encoded by codec
+// (Data) follows JSON header of binary envelope as is, without base64 of JSON
 func (m *RedisMessage) MarshalBinary() (data []byte, err error) {
-	return json.Marshal(m)
+	if m.Data == nil {
+		return json.Marshal(m)
+	}
+	head := *m
+	head.Data = nil
+	var header []byte
+	header, err = json.Marshal(&head)
+	if err != nil {
+		return
+	}
+	data = make([]byte, 0, 1+binary.MaxVarintLen64+len(header)+len(m.Data))
+	data = append(data, BINARY_ENVELOPE)
+	data = binary.AppendUvarint(data, uint64(len(header)))
+	data = append(data, header...)
+	data = append(data, m.Data...)
+	return
+}
+
+// UnmarshalBinary converts bytes from Redis back into the struct; JSON or binary envelope
+func (m *RedisMessage) UnmarshalBinary(data []byte) (err error) {
+	var payload []byte // Data after header of binary envelope
+	if len(data) > 0 && data[0] == BINARY_ENVELOPE {
+		size, n := binary.Uvarint(data[1:])
+		if n <= 0 || size > uint64(len(data)-1-n) {
+			return fmt.Errorf("%s", "wrong binary envelope")
+		}
+		data, payload = data[1+n:1+n+int(size)], data[1+n+int(size):]
+	}
+
+	type envelope RedisMessage // without methods for default unmarshaling
+	aux := struct {
+		*envelope
+		V json.RawMessage `json:"v"`
+	}{envelope: (*envelope)(m)}
+	err = json.Unmarshal(data, &aux)
+	if err != nil {
+		return
+	}
+
+	if len(aux.V) > 0 && string(aux.V) != "null" {
+		m.raw = aux.V
+		err = json.Unmarshal(aux.V, &m.Value)
+		if err != nil {
+			return
+		}
+	}
+
+	if payload != nil {
+		m.Data = payload
+	}
+	if m.Data != nil { // value for RadioKat in common form
+		switch m.ContentType {
+		case CT_PROTOBUF: // type of message unknown; use Decode
+			m.Value = m.Data
+		default:
+			var c Codec
+			c, err = CodecByType(m.ContentType)
+			if err != nil {
+				return
+			}
+			err = c.Unmarshal(m.Data, &m.Value)
+		}
+	}
+	return
 }
 
-// UnmarshalBinary converts bytes from Redis back into the struct
-func (m *RedisMessage) UnmarshalBinary(data []byte) error {
-	return json.Unmarshal(data, m)
+// NewMessage prepare message from service; value encoded by codec of wrapper
+func (wpr *Wrapper) NewMessage(key string, value any, opts ...MessageOption) (msg *RedisMessage, err error) {
+	msg = &RedisMessage{
+		Sender:    wpr.Name,
+		Key:       key,
+		ID:        NewMessageID(),
+		Version:   PROTOCOL_VERSION,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	for _, opt := range opts {
+		opt(msg)
+	}
+	if wpr.Codec == nil || wpr.Codec.ContentType() == CT_JSON {
+		msg.raw, err = json.Marshal(value)
+		msg.Value = msg.raw // keep exactly signed bytes
+		return
+	}
+	msg.ContentType = wpr.Codec.ContentType()
+	msg.Data, err = wpr.Codec.Marshal(value)
+	return
 }
 
 // CreateWrapper got name current service and logLevel & sizeLogFile for cisystemlog
 func CreateWrapper(name string, logLevel int32, sizeLogFile int64) (wpr *Wrapper) {
+	return createWrapper(name, logLevel, sizeLogFile, nil)
+}
+
+// CreateWrapperWithTransport create wrapper connected to given bus, as InprocBus for goroutine tasks and tests;
+// name of service not checked with env of process
+func CreateWrapperWithTransport(name string, logLevel int32, sizeLogFile int64, t Transport) (wpr *Wrapper) {
+	return createWrapper(name, logLevel, sizeLogFile, t)
+}
+
+// logFileName return name of log file of service; replica log to own file NAME#i, not to file of group
+func logFileName(name string) string {
+	if group, ok := os.LookupEnv(REPLICA_GROUP); ok && name == group {
+		if val, ok := os.LookupEnv(NAME); ok && val != "" {
+			return strings.ToUpper(val)
+		}
+	}
+	return name
+}
+
+func createWrapper(name string, logLevel int32, sizeLogFile int64, t Transport) (wpr *Wrapper) {
 	var err error
 	defer func() {
 		if err != nil {
@@ -112,14 +277,33 @@ func CreateWrapper(name string, logLevel int32, sizeLogFile int64) (wpr *Wrapper
 		}
 	}
 
-	sl.CreateLogs(name, "./log/", logLevel, sizeLogFile)
+	logName := name
+	if t == nil {
+		logName = logFileName(name)
+	}
+	logsOnce.Do(func() { // logger is global: wrappers of one process (goroutine tasks, tests) share the first one
+		if sl.L == nil {
+			sl.CreateLogs(logName, LOG_DIR, logLevel, sizeLogFile)
+		}
+	})
 
-	Wpr = &Wrapper{
-		StopChan:  make(chan struct{}),
-		Env:       make(map[string]string),
-		TimeDelay: make(map[string]int),
-		NextTry:   make(map[string]int64),
+	wpr = &Wrapper{
+		Codec:    JSONCodec{},
+		StopChan: make(chan struct{}),
+		Env:      make(map[string]string),
+		Replica:  -1,
+		rrIndex:  make(map[string]int),
+
+		BackoffMin:   DEFAULT_BACKOFF_MIN,
+		BackoffMax:   DEFAULT_BACKOFF_MAX,
+		OutboxSize:   DEFAULT_OUTBOX_SIZE,
+		OutboxPolicy: DEFAULT_OUTBOX_POLICY,
+		StopTimeout:  DEFAULT_STOP_TIMEOUT,
+		conn:         connection{patterns: map[string]bool{}},
+		dog:          watchdog{timeout: watchdogTimeout()},
+		listened:     make(chan struct{}),
 	}
+	wpr.listen, wpr.unlisten = context.WithCancel(context.Background())
 
 	if location, ok := os.LookupEnv(TIMELOCATION); ok {
 		ciutils.TimeLocation, err = time.LoadLocation(location)
@@ -130,22 +314,34 @@ func CreateWrapper(name string, logLevel int32, sizeLogFile int64) (wpr *Wrapper
 	}
 
 	// Recheck nameing task in process as in dispatcher
-	if name != MASTER && name != SENDER && len(os.Environ()) > 0 {
+	if t == nil && name != MASTER && name != SENDER && len(os.Environ()) > 0 {
 		for _, val := range os.Environ() {
 			//sl.L.Debug("[%s] got env: %s", name, val)
 			senv := strings.Split(val, "=")
-			Wpr.Env[senv[0]] = senv[1]
+			wpr.Env[senv[0]] = senv[1]
 			sl.L.Debug("[%s] added env: %s=%s", name, senv[0], senv[1])
 		}
 
 		val, ok := os.LookupEnv(NAME)
-		if !ok || name != val || name != Wpr.Env[NAME] {
+		if group, isReplica := os.LookupEnv(REPLICA_GROUP); isReplica && ok && name == group { // replica got own name from dispatcher
+			name = val
+			wpr.Group = group
+			wpr.Replica = ciutils.StrToInt(wpr.Env[REPLICA_INDEX])
+		}
+		if !ok || name != val || name != wpr.Env[NAME] {
 			err = fmt.Errorf("service with name \"%s\" not equal naming with started process.", name)
 			return
 		}
 		name = val
 	}
-	Wpr.Name = name
+	wpr.Name = name
+
+	if ct, ok := os.LookupEnv(CODEC); ok {
+		wpr.Codec, err = CodecByType(strings.ToLower(ct))
+		if err != nil {
+			return
+		}
+	}
 
 	// _, err = ciutils.MakeSureFileExists(PORT_FILE_PATH)
 	// if err != nil {
@@ -161,59 +357,147 @@ func CreateWrapper(name string, logLevel int32, sizeLogFile int64) (wpr *Wrapper
 	// }
 	// rport := string(raw)
 
-	var rport string
-	var ok bool
-	if rport, ok = os.LookupEnv(CI_REDIS_PORT); !ok && name == MASTER {
-		err = fmt.Errorf("The environment %s must be set", CI_REDIS_PORT)
-		sl.L.Warning("[%s] %s", name, err.Error())
-		return
-	}
+	wpr.Token = LoadToken(name)
+	if t == nil {
+		var network, addr string
+		network, addr, err = BusAddr()
+		if err != nil && name != MASTER {
+			sl.L.Warning("[%s] %s", name, err.Error())
+			err = nil
+		}
+		if err != nil {
+			sl.L.Warning("[%s] %s", name, err.Error())
+			return
+		}
 
-	sl.L.Debug("[%s] connect to Redis on: %s", name, rport)
-	Wpr.RClient = redis.NewClient(&redis.Options{
-		Addr:             "localhost:" + rport,
-		ReadTimeout:      -1, // Disable network timeout to read
-		WriteTimeout:     5 * time.Second,
-		DisableIndentity: true,
-		DB:               0,
-	})
+		sl.L.Debug("[%s] connect to Redis on: %s", name, addr)
+		t, err = DialTransport(name, network, addr, wpr.Token)
+		if err != nil {
+			sl.L.Warning("[%s] %s", name, err.Error())
+			return
+		}
+	}
+	wpr.Transport = t
+	if rt, ok := t.(*RedisTransport); ok {
+		wpr.RClient, wpr.PubSub = rt.Client, rt.pubsub()
+	}
 
 	ctx := context.Background()
-	Wpr.PubSub = Wpr.RClient.Subscribe(ctx, name)
+	if tags, ok := wpr.Env[TAGS]; ok && tags != "" {
+		for _, tag := range strings.Split(tags, ",") {
+			wpr.Tags = append(wpr.Tags, strings.ToUpper(strings.TrimSpace(tag)))
+		}
+	}
+	if serr := wpr.Transport.Subscribe(ctx, wpr.channels()...); serr != nil {
+		wpr.disconnected(serr) // bus not ready yet; listener reconnect
+	} else {
+		wpr.conn.state = CONN_CONNECTED
+	}
+	wpr.JoinGroups(ctx)
 
-	if Wpr.Name != MASTER && Wpr.Name != SENDER {
-		Wpr.SendToService(MASTER, STATUS, LAUNCHED)
+	if wpr.Name != MASTER && wpr.Name != SENDER {
+		wpr.SendToService(MASTER, STATUS, LAUNCHED)
 	}
 
 	sig := make(chan os.Signal, 1)
 	if name == MASTER {
 		signal.Notify(sig, syscall.SIGUSR1) // for cooperative shutdown
 		signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+		signal.Notify(sig, syscall.SIGHUP) // reload configs
 		//signal.Notify(sig, syscall.SIGQUIT) // for force shutdown
 	}
-	go Wpr.RadioKatListner(sig)
-	return Wpr
+	Wpr = wpr // before listener: handlers of messages may use Wpr
+	go wpr.RadioKatListner(sig)
+	return wpr
 }
 
 func (wpr *Wrapper) Shutdown(reason string) {
-    wpr.stopOnce.Do(func() {
-        sl.L.Alert("[%s] shutdown: %s", wpr.Name, reason)
+	wpr.stopOnce.Do(func() {
+		sl.L.Alert("[%s] shutdown: %s", wpr.Name, reason)
 		wpr.RegularStop()
-        close(wpr.StopChan)
+		wpr.Stop()
 		time.Sleep(5 * time.Second)
 		os.Exit(0)
-    })
+	})
+}
+
+// Stop close StopChan; safe to call many times from any goroutine
+func (wpr *Wrapper) Stop() {
+	wpr.closeOnce.Do(func() {
+		close(wpr.StopChan)
+	})
+}
+
+// Subscription return current subscription of Redis transport; nil on other transports
+func (wpr *Wrapper) Subscription() *redis.PubSub {
+	wpr.mutex.RLock()
+	defer wpr.mutex.RUnlock()
+	return wpr.PubSub
+}
+
+// SetOnMessage change handler of this wrapper while listener works
+func (wpr *Wrapper) SetOnMessage(handler func(msg *RedisMessage)) {
+	wpr.mutex.Lock()
+	wpr.OnMessage = handler
+	wpr.mutex.Unlock()
+}
+
+// SetOnConnState change callback of bus state while listener works
+func (wpr *Wrapper) SetOnConnState(callback func(state string, err error)) {
+	wpr.mutex.Lock()
+	wpr.OnConnState = callback
+	wpr.mutex.Unlock()
+}
+
+// controller return handler of lifecycle messages; set while service managed by Run
+func (wpr *Wrapper) controller() func(msg *RedisMessage) bool {
+	wpr.mutex.RLock()
+	defer wpr.mutex.RUnlock()
+	return wpr.control
+}
+
+func (wpr *Wrapper) handlers() (onMessage func(msg *RedisMessage), onConnState func(state string, err error)) {
+	wpr.mutex.RLock()
+	defer wpr.mutex.RUnlock()
+	return wpr.OnMessage, wpr.OnConnState
+}
+
+// Close finish listener of messages and close transport without report to master, as for tools like sender
+func (wpr *Wrapper) Close() (err error) {
+	wpr.unlisten()
+	err = wpr.Transport.Close()
+	wpr.waitListener()
+	return
 }
 
 func (wpr *Wrapper) RegularStop() {
-	Wpr.SendToService(MASTER, STATUS, STOPPED)
-	Wpr.PubSub.Close()
+	wpr.SendToService(MASTER, STATUS, STOPPED)
+	wpr.LeaveGroups(context.Background())
+	wpr.Transport.Close()
+}
+
+// Complete report to master that oneshot task successfully finished own job
+func (wpr *Wrapper) Complete() (err error) {
+	err = wpr.SendToService(MASTER, STATUS, COMPLETED)
+	if err != nil {
+		sl.L.Warning("[%s] %s", wpr.Name, err.Error())
+	}
+	return
 }
 
 func (wpr *Wrapper) StartService(serviceName string) (err error) {
 	err = wpr.SendToService(MASTER, START, serviceName)
 	if err != nil {
-		sl.L.Warning("[%s] %s", Wpr.Name, err.Error())
+		sl.L.Warning("[%s] %s", wpr.Name, err.Error())
+	}
+	return
+}
+
+// ScaleService ask master to change count of replicas of service
+func (wpr *Wrapper) ScaleService(serviceName string, replicas int) (err error) {
+	err = wpr.SendToService(MASTER, SCALE, fmt.Sprintf("%s %d", serviceName, replicas))
+	if err != nil {
+		sl.L.Warning("[%s] %s", wpr.Name, err.Error())
 	}
 	return
 }
@@ -221,80 +505,128 @@ func (wpr *Wrapper) StartService(serviceName string) (err error) {
 func (wpr *Wrapper) StopService(serviceName string) (err error) {
 	err = wpr.SendToService(MASTER, STOP, serviceName)
 	if err != nil {
-		sl.L.Warning("[%s] %s", Wpr.Name, err.Error())
+		sl.L.Warning("[%s] %s", wpr.Name, err.Error())
 	}
 	return
 }
 
 func (wpr *Wrapper) ReadGroup() (channel, sender, key string, value any, err error) {
-	int64Now := ciutils.TimeToInt64(ciutils.Now())
-	// sl.L.Debug("[%s] now: %v; NextTry: %v", wpr.Name,
-	// 	ciutils.TimeToStringInFormat(ciutils.Int64ToTime(int64Now), "15:04:05"),
-	// 	ciutils.TimeToStringInFormat(ciutils.Int64ToTime(wpr.NextTry[wpr.Name]), "15:04:05"))
-
-	if wpr.NextTry[wpr.Name] > int64Now {
-		err = fmt.Errorf("%s", JUST_WAIT)
-		//sl.L.Debug("[%s] %s", wpr.Name, err.Error())
+	var msg *RedisMessage
+	channel, msg, err = wpr.ReadMessage()
+	if err != nil {
 		return
 	}
+	return channel, msg.Sender, msg.Key, msg.Value, nil
+}
 
-	var rmsg *redis.Message
-	rmsg, err = wpr.PubSub.ReceiveMessage(context.Background())
-	if err != nil {
-		//sl.L.Debug("[%s] Error ReceiveMessage:%s", wpr.Name, err.Error())
-		if wpr.TimeDelay[wpr.Name] == 0 {
-			wpr.TimeDelay[wpr.Name] = 1
+// ReadMessage receive whole message with envelope
+func (wpr *Wrapper) ReadMessage() (channel string, msg *RedisMessage, err error) {
+	ctx := wpr.listen
+	for { // dropped messages are skipped
+		if !wpr.Connected() {
+			err = wpr.reconnect(ctx)
+			if err != nil {
+				return
+			}
 		}
-		wpr.TimeDelay[wpr.Name] = wpr.TimeDelay[wpr.Name] * 2
-		//sl.L.Debug("[%s] TimeDelay: %v; NextTry: %v", wpr.Name, wpr.TimeDelay[wpr.Name], ciutils.Int64ToTime(wpr.NextTry[wpr.Name]))
-		wpr.NextTry[wpr.Name] = ciutils.TimeToInt64(ciutils.Now().Add(time.Duration(wpr.TimeDelay[wpr.Name]) * time.Second))
-		return
-	}
-	wpr.TimeDelay[wpr.Name] = 1
 
-	//sl.L.Debug("[%s] GOT RAW %v", wpr.Name, rmsg)
-	channel = rmsg.Channel
-	// PREPARING
-	input := RedisMessage{}
-	err = json.Unmarshal([]byte(rmsg.Payload), &input)
-	if err != nil {
-		sl.L.Warning(err.Error())
-		return
+		var pattern string
+		var payload []byte
+		channel, pattern, payload, err = wpr.Transport.Receive(ctx)
+		var refused redis.Error
+		if errors.As(err, &refused) { // command refused by bus, as NOPERM of subscription; connection is alive
+			sl.L.Warning("[%s] bus: %s", wpr.Name, err.Error())
+			continue
+		}
+		if err != nil {
+			wpr.disconnected(err)
+			return
+		}
+
+		//sl.L.Debug("[%s] GOT RAW %v", wpr.Name, payload)
+		// PREPARING
+		input := RedisMessage{Channel: channel, Pattern: pattern}
+		err = input.UnmarshalBinary(payload)
+		if err == nil {
+			err = input.checkEnvelope()
+		}
+		if err == nil {
+			err = wpr.verify(channel, &input)
+		}
+		if err != nil { // drop message (also malformed one) and read next
+			sl.L.Warning("[%s] drop: %s", wpr.Name, err.Error())
+			continue
+		}
+		sl.L.Debug("[%s] GOT from %s: %s-%v", wpr.Name, input.Sender, input.Key, input.Value)
+		return channel, &input, nil
 	}
-	sl.L.Debug("[%s] GOT from %s: %s-%v", wpr.Name, input.Sender, input.Key, input.Value)
-	return wpr.Name, input.Sender, input.Key, input.Value, nil
 }
 
-func (wpr *Wrapper) SendToService(channelName, key string, value any) (err error) {
+func (wpr *Wrapper) SendToService(channelName, key string, value any, opts ...MessageOption) (err error) {
 	ctx := context.Background()
 	channelName = strings.ToUpper(channelName)
 	sl.L.Debug("[%s] Send to %s: %s-%v", wpr.Name, channelName, key, value)
 
-	int64Now := ciutils.TimeToInt64(ciutils.Now())
-	// sl.L.Debug("[%s] now: %v; NextTry: %v", wpr.Name,
-	// 	ciutils.TimeToStringInFormat(ciutils.Int64ToTime(int64Now), "15:04:05"),
-	// 	ciutils.TimeToStringInFormat(ciutils.Int64ToTime(wpr.NextTry[channelName]), "15:04:05"))
-
-	if wpr.NextTry[channelName] > int64Now {
-		//err = fmt.Errorf("[%s] Too mutch error Send to %s, wait to next available try", wpr.Name, channelName)
-		err = fmt.Errorf("%s", JUST_WAIT)
-		//sl.L.Debug("[%s] %s", wpr.Name, err.Error())
+	var msg *RedisMessage
+	msg, err = wpr.NewMessage(key, value, opts...)
+	if err != nil {
+		sl.L.Warning("[%s] %s", wpr.Name, err.Error())
 		return
 	}
+	if wpr.Connected() && channelName != MASTER && channelName != SENDER && !strings.Contains(channelName, REPLICA_SEPARATOR) {
+		// name of replicas group is decided by replicas subscribed on bus, not by receivers of channel:
+		// pattern subscriber as monitor receive any channel
+		var replica string
+		replica, err = wpr.NextReplica(ctx, channelName)
+		if err == nil && replica != "" {
+			sl.L.Debug("[%s] Send to replica %s", wpr.Name, replica)
+			channelName = replica
+		}
+	}
+	wpr.signFor(channelName, msg)
+	_, err = wpr.send(ctx, channelName, msg)
+	if err != nil {
+		sl.L.Warning("[%s] %s", wpr.Name, err.Error())
+	}
+	return
+}
 
-	err = wpr.RClient.Publish(ctx, channelName, &RedisMessage{wpr.Name, key, value}).Err()
-	//err = wpr.RClient.Publish(ctx, channelName, data).Err()
+// send publish message or keep it in outbox while bus is disconnected
+func (wpr *Wrapper) send(ctx context.Context, channel string, msg *RedisMessage) (receivers int64, err error) {
+	if !wpr.Connected() {
+		return 0, wpr.enqueue(channel, msg)
+	}
+	receivers, err = wpr.publish(ctx, channel, msg)
+	if err != nil {
+		wpr.disconnected(err)
+		return 0, wpr.enqueue(channel, msg)
+	}
+	return
+}
+
+// publish send message to channel through transport
+func (wpr *Wrapper) publish(ctx context.Context, channel string, msg *RedisMessage) (receivers int64, err error) {
+	var payload []byte
+	payload, err = msg.MarshalBinary()
 	if err != nil {
-		sl.L.Debug("[%s] Error: %s", wpr.Name, err.Error())
-		if wpr.TimeDelay[channelName] == 0 {
-			wpr.TimeDelay[channelName] = 1
-		}
-		wpr.TimeDelay[channelName] = wpr.TimeDelay[channelName] * 2
-		//sl.L.Debug("[%s] TimeDelay: %v; NextTry: %v", wpr.Name, wpr.TimeDelay[channelName], wpr.NextTry[channelName])
-		wpr.NextTry[channelName] = ciutils.TimeToInt64(ciutils.Now().Add(time.Duration(wpr.TimeDelay[channelName]) * time.Second))
 		return
 	}
-	wpr.TimeDelay[channelName] = 1
+	return wpr.Transport.Publish(ctx, channel, payload)
+}
+
+// NextReplica choose replica of group by round-robin from subscribed channels
+func (wpr *Wrapper) NextReplica(ctx context.Context, group string) (replica string, err error) {
+	var replicas []string
+	replicas, err = wpr.Transport.Channels(ctx, group+REPLICA_SEPARATOR+"*")
+	if err != nil || len(replicas) == 0 {
+		return
+	}
+	sort.Strings(replicas)
+
+	wpr.rrMutex.Lock()
+	replica = replicas[wpr.rrIndex[group]%len(replicas)]
+	wpr.rrIndex[group]++
+	wpr.rrMutex.Unlock()
 	return
 }
 
@@ -304,49 +636,84 @@ func (wpr *Wrapper) RadioKatListner(signal <-chan os.Signal) {
 		if err != nil {
 			sl.L.Alert("[%s] Shutdown RadioKat with err: %s", wpr.Name, err.Error())
 		}
-		wpr.SendToService(MASTER, STATUS, STOPPED)
+		if wpr.listen.Err() == nil { // else STOPPED sent by Run
+			wpr.SendToService(MASTER, STATUS, STOPPED)
+		}
+		close(wpr.listened)
 	}()
 
+	stop := wpr.StopChan
 	for {
 		select {
-		case <-signal:
-			if wpr.Name == MASTER {
-				RunRadioKat(MASTER, STATUS, EXIT)
+		case <-wpr.listen.Done():
+			return
+		case s := <-signal:
+			if wpr.Name == MASTER { // handled by policy of dispatcher
+				RunRadioKat(MASTER, SIGNAL, signalNames[s])
 				continue
 			}
-			
+
 			wpr.SendToService(MASTER, STATUS, EXIT)
 			wpr.Shutdown("Got cooperative shutdown signal (SIGUSR1)")
 			return
-		case <-wpr.StopChan:
+		case <-stop:
+			if wpr.controller() != nil { // service stopped by Run
+				stop = nil
+				continue
+			}
 			if wpr.Name == MASTER {
+				stop = nil // closed channel must not be selected again
 				RunRadioKat(MASTER, STATUS, EXIT)
 				continue
 			}
 			wpr.Shutdown("RadioKat stopped from StopChannel")
-			close(wpr.StopChan)
 			return
 		default:
-			var sender, key string
-			var value any
-			_, sender, key, value, err = wpr.ReadGroup()
+			var channel string
+			var msg *RedisMessage
+			channel, msg, err = wpr.ReadMessage()
 			if err != nil {
+				if wpr.listen.Err() != nil { // transport closed by Run
+					err = nil
+					return
+				}
 				if err.Error() != JUST_WAIT {
 					sl.L.Warning(err.Error())
 				}
-				time.Sleep(1 * time.Second)
+				select {
+				case <-wpr.listen.Done():
+				case <-time.After(1 * time.Second):
+				}
 				continue
 			}
 
-			if  val, ok := value.(string); ok && key == STATUS && strings.ToUpper(val) == GETINFO {
+			sender, key, value := msg.Sender, msg.Key, msg.Value
+			if val, ok := value.(string); ok && key == STATUS && strings.ToUpper(val) == GETINFO && wpr.Name != MASTER && wpr.Name != SENDER {
 				wpr.SendToService(MASTER, STATUS, LAUNCHED)
 				continue
 			}
 
+			if wpr.watched(channel, msg) {
+				continue
+			}
+			if control := wpr.controller(); control != nil && control(msg) {
+				continue
+			}
+
 			sl.L.Debug("[%s] sender: %s key: %s value: %v", wpr.Name, sender, key, value)
-			if RadioKat != nil {
+			wpr.trackLoad(1)
+			onMessage, _ := wpr.handlers()
+			switch true {
+			case onMessage != nil:
+				onMessage(msg)
+			case RadioKatMessage != nil:
+				RadioKatMessage(msg)
+			case msg.Pattern != "": // topic subscribed by Subscribe
+				RunRadioKatTopic(channel, sender, key, value)
+			case RadioKat != nil:
 				RunRadioKat(sender, key, value)
 			}
+			wpr.trackLoad(-1)
 		}
 	}
 }
@@ -0,0 +1,216 @@
+package wrapper
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// testWrapper connect wrapper to miniredis without auth; logs go to temporary directory
+func testWrapper(t *testing.T, mr *miniredis.Miniredis, name string) *Wrapper {
+	t.Helper()
+	tr, err := DialTransport(name, "tcp", mr.Addr(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wpr := CreateWrapperWithTransport(name, 1, 0, tr)
+	t.Cleanup(func() { wpr.Transport.Close() })
+	return wpr
+}
+
+// waitFor poll cond until it is true or timeout
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(timeout); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestWrapperConcurrent hammer SendToService, SetOnMessage, SetOnConnState and Stop from many
+// goroutines while listeners work; run with -race
+func TestWrapperConcurrent(t *testing.T) {
+	t.Chdir(t.TempDir())
+	mr := miniredis.RunT(t)
+	master := testWrapper(t, mr, MASTER) // Stop of master does not exit process
+	worker := testWrapper(t, mr, "WORKER")
+
+	var got atomic.Int64
+	count := func(msg *RedisMessage) { got.Add(1) }
+	worker.SetOnMessage(count)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if err := master.SendToService("WORKER", "KEY", j); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				worker.SendToService(MASTER, "KEY", j)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				worker.SetOnMessage(count)
+				worker.SetOnConnState(func(state string, err error) {})
+				worker.Subscription()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				master.Stop()
+			}
+		}()
+	}
+	wg.Wait()
+
+	waitFor(t, 5*time.Second, func() bool { return got.Load() == 8*50 })
+	select {
+	case <-master.StopChan:
+	default:
+		t.Fatal("StopChan not closed")
+	}
+}
+
+// TestWrapperDropped skip long run of messages which must be dropped, also malformed ones, and get next valid one
+func TestWrapperDropped(t *testing.T) {
+	t.Chdir(t.TempDir())
+	mr := miniredis.RunT(t)
+	master := testWrapper(t, mr, MASTER)
+	worker := testWrapper(t, mr, "WORKER")
+
+	var got atomic.Int64
+	worker.SetOnMessage(func(msg *RedisMessage) {
+		if msg.Key == "VALID" {
+			got.Add(1)
+		}
+	})
+	for i := 0; i < 1000; i++ {
+		msg, _ := master.NewMessage("KEY", i)
+		msg.Version = "999.0"
+		if _, err := master.send(context.Background(), "WORKER", msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, raw := range []string{"not envelope", "{broken json", "\x00\x01"} { // malformed payloads
+		if _, err := master.Transport.Publish(context.Background(), "WORKER", []byte(raw)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	master.SendToService("WORKER", "VALID", 1)
+	waitFor(t, 5*time.Second, func() bool { return got.Load() == 1 })
+	if !worker.Connected() {
+		t.Fatal("worker disconnected by malformed message")
+	}
+}
+
+// TestWrapperTopics message of pattern subscription go to RadioKatTopic by pattern of bus, own channels to RadioKat
+func TestWrapperTopics(t *testing.T) {
+	t.Chdir(t.TempDir())
+	topics, direct := make(chan string, 10), make(chan string, 10)
+	RadioKatTopic = func(topic, sender, key string, value any) { topics <- topic } // before listeners
+	RadioKat = func(sender, key string, value any) {
+		if key != STATUS { // LAUNCHED of worker to master
+			direct <- key
+		}
+	}
+	t.Cleanup(func() { RadioKatTopic, RadioKat = nil, nil })
+	mr := miniredis.RunT(t)
+	master := testWrapper(t, mr, MASTER)
+	worker := testWrapper(t, mr, "WORKER")
+
+	if err := worker.Subscribe("WORK*"); err != nil { // own channel WORKER matches pattern too
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	master.Publish("WORKS.DONE", "EVENT", 1)
+	select {
+	case topic := <-topics:
+		if topic != "WORKS.DONE" {
+			t.Fatal("topic", topic)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("topic not delivered")
+	}
+
+	master.SendToService("WORKER", "KEY", 1) // delivered by own channel and by pattern
+	var keys, own []string
+	for len(keys)+len(own) < 2 {
+		select {
+		case key := <-direct:
+			keys = append(keys, key)
+		case topic := <-topics:
+			own = append(own, topic)
+		case <-time.After(2 * time.Second):
+			t.Fatal("not delivered", keys, own)
+		}
+	}
+	if len(keys) != 1 || keys[0] != "KEY" || len(own) != 1 || own[0] != "WORKER" {
+		t.Fatal("direct", keys, "topics", own)
+	}
+}
+
+// TestSendToReplicas message to group go to one replica by round-robin, though monitor listen all channels by pattern
+func TestSendToReplicas(t *testing.T) {
+	t.Chdir(t.TempDir())
+	mr := miniredis.RunT(t)
+	master := testWrapper(t, mr, MASTER)
+	monitor := testWrapper(t, mr, "MONITOR")
+	if err := monitor.Subscribe("*"); err != nil {
+		t.Fatal(err)
+	}
+	var got [2]atomic.Int32
+	for i := range got {
+		replica := testWrapper(t, mr, fmt.Sprintf("WORKER%s%d", REPLICA_SEPARATOR, i))
+		replica.SetOnMessage(func(msg *RedisMessage) {
+			if msg.Key == "KEY" {
+				got[i].Add(1)
+			}
+		})
+	}
+	waitFor(t, 2*time.Second, func() bool {
+		replicas, _ := master.Transport.Channels(context.Background(), "WORKER#*")
+		return len(replicas) == 2
+	})
+
+	for i := 0; i < 4; i++ {
+		master.SendToService("worker", "KEY", i)
+	}
+	waitFor(t, 2*time.Second, func() bool { return got[0].Load()+got[1].Load() == 4 })
+	time.Sleep(100 * time.Millisecond)
+	if got[0].Load() != 2 || got[1].Load() != 2 {
+		t.Fatal("replicas got", got[0].Load(), got[1].Load())
+	}
+}
+
+// TestLogFileName replica log to own file, service without replicas to file by its name
+func TestLogFileName(t *testing.T) {
+	if name := logFileName("WORKER"); name != "WORKER" {
+		t.Fatal(name)
+	}
+	t.Setenv(REPLICA_GROUP, "WORKER")
+	t.Setenv(NAME, "worker#1")
+	if name := logFileName("WORKER"); name != "WORKER#1" {
+		t.Fatal(name)
+	}
+	if name := logFileName("OTHER"); name != "OTHER" {
+		t.Fatal(name)
+	}
+}